@@ -0,0 +1,56 @@
+package debughttp
+
+import (
+	"crypto/sha256"
+	"strconv"
+	"sync"
+)
+
+// aliasMapMax is the most values an aliasMap remembers before it
+// forgets the oldest
+const aliasMapMax = 10000
+
+// aliasMap assigns stable sequential aliases, eg "TOKEN_1", to the
+// distinct values redacted with RedactMapping.
+//
+// Only a hash of each value is kept so the secrets aren't held in
+// memory, and at most aliasMapMax of them so rotating tokens don't
+// make it grow forever.
+type aliasMap struct {
+	mu      sync.Mutex
+	aliases map[[sha256.Size]byte]string // alias indexed by hash of value
+	order   [][sha256.Size]byte          // hashes in the order they were added
+	next    int                          // number of the next alias
+}
+
+// newAliasMap makes an empty aliasMap
+func newAliasMap() *aliasMap {
+	return &aliasMap{
+		aliases: make(map[[sha256.Size]byte]string),
+		next:    1,
+	}
+}
+
+// alias replaces value with its alias, assigning the next one if it
+// hasn't been seen before. An empty value is left empty as there is
+// nothing to hide.
+func (m *aliasMap) alias(value []byte) []byte {
+	if len(value) == 0 {
+		return value
+	}
+	key := sha256.Sum256(value)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	alias, found := m.aliases[key]
+	if !found {
+		if len(m.order) >= aliasMapMax {
+			delete(m.aliases, m.order[0])
+			m.order = m.order[1:]
+		}
+		alias = "TOKEN_" + strconv.Itoa(m.next)
+		m.next++
+		m.aliases[key] = alias
+		m.order = append(m.order, key)
+	}
+	return []byte(alias)
+}
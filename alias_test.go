@@ -0,0 +1,81 @@
+package debughttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAliasMap(t *testing.T) {
+	m := newAliasMap()
+	assert.Equal(t, "TOKEN_1", string(m.alias([]byte("secret1"))))
+	assert.Equal(t, "TOKEN_2", string(m.alias([]byte("secret2"))))
+	assert.Equal(t, "TOKEN_1", string(m.alias([]byte("secret1"))))
+	assert.Equal(t, "", string(m.alias(nil)))
+	assert.Equal(t, "TOKEN_3", string(m.alias([]byte("secret3"))))
+
+	// Concurrent use assigns each value one alias
+	var wg sync.WaitGroup
+	results := make([]string, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = string(m.alias([]byte("concurrent")))
+		}(i)
+	}
+	wg.Wait()
+	for _, result := range results {
+		assert.Equal(t, "TOKEN_4", result)
+	}
+}
+
+func TestAliasMapBounded(t *testing.T) {
+	m := newAliasMap()
+	for i := 1; i <= aliasMapMax; i++ {
+		assert.Equal(t, "TOKEN_"+strconv.Itoa(i), string(m.alias([]byte("secret"+strconv.Itoa(i)))))
+	}
+	assert.Equal(t, "TOKEN_1", string(m.alias([]byte("secret1"))))
+
+	// The oldest value is forgotten to make room
+	assert.Equal(t, "TOKEN_10001", string(m.alias([]byte("new"))))
+	assert.Equal(t, aliasMapMax, len(m.aliases))
+	assert.Equal(t, "TOKEN_10002", string(m.alias([]byte("secret1"))))
+	assert.Equal(t, "TOKEN_3", string(m.alias([]byte("secret3"))))
+}
+
+func TestRedactMapping(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Set-Cookie", "session="+r.Header.Get("Authorization")+"; Path=/")
+	}))
+	defer ts.Close()
+
+	client, capture := NewCaptureClient(&Options{
+		Flags:         DumpHeaders,
+		RedactMapping: true,
+	})
+	var all []string
+	for _, token := range []string{"Bearer one", "Bearer two", "Bearer one"} {
+		capture.Reset()
+		req, err := http.NewRequest("GET", ts.URL, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", token)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		out := capture.String()
+		assert.NotContains(t, out, "one")
+		assert.NotContains(t, out, "two")
+		all = append(all, out)
+	}
+	assert.True(t, strings.Contains(all[0], "Authorization: TOKEN_1\r\n"), all[0])
+	assert.True(t, strings.Contains(all[0], "Set-Cookie: session=TOKEN_1; Path=/\r\n"), all[0])
+	assert.True(t, strings.Contains(all[1], "Authorization: TOKEN_2\r\n"), all[1])
+	assert.True(t, strings.Contains(all[2], "Authorization: TOKEN_1\r\n"), all[2])
+}
@@ -0,0 +1,100 @@
+package debughttp
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+)
+
+// redactionAudit collects the names of what was redacted from a dump
+// for RedactAudit, eg "Authorization", "Cookie(session)" or
+// "query(api_key)", in the order they were redacted. Its methods do
+// nothing on a nil *redactionAudit so the redactors can always call
+// them.
+type redactionAudit struct {
+	names []string
+	seen  map[string]bool
+}
+
+// add records name once
+func (a *redactionAudit) add(name string) {
+	if a == nil || a.seen[name] {
+		return
+	}
+	if a.seen == nil {
+		a.seen = make(map[string]bool)
+	}
+	a.seen[name] = true
+	a.names = append(a.names, name)
+}
+
+// header records the header called name (canonical) whose value was
+// redacted, naming the cookies if it is a Cookie header. Empty values
+// have nothing to redact so aren't recorded.
+func (a *redactionAudit) header(name string, value []byte) {
+	if a == nil || len(bytes.TrimSpace(value)) == 0 {
+		return
+	}
+	if name != "Cookie" {
+		a.add(name)
+		return
+	}
+	for _, cookie := range bytes.Split(value, []byte(";")) {
+		if cookie := cookieName(cookie); cookie != "" {
+			a.add("Cookie(" + cookie + ")")
+		}
+	}
+}
+
+// changed records the header called name (canonical) whose value was
+// rewritten from before to after, naming only the cookies which were
+// changed if it is a Cookie header
+func (a *redactionAudit) changed(name string, before, after []byte) {
+	if a == nil || bytes.Equal(before, after) {
+		return
+	}
+	if name != "Cookie" {
+		a.add(name)
+		return
+	}
+	kept := map[string]bool{}
+	for _, cookie := range bytes.Split(after, []byte(";")) {
+		kept[string(bytes.TrimSpace(cookie))] = true
+	}
+	for _, cookie := range bytes.Split(before, []byte(";")) {
+		if !kept[string(bytes.TrimSpace(cookie))] {
+			a.header(name, cookie)
+		}
+	}
+}
+
+// pattern records that pattern of RedactLinePatterns matched
+func (a *redactionAudit) pattern(pattern *regexp.Regexp) {
+	if a == nil {
+		return
+	}
+	a.add("line(" + pattern.String() + ")")
+}
+
+// redactor records that the i-th of Options.Redactors changed the dump
+func (a *redactionAudit) redactor(i int) {
+	if a == nil {
+		return
+	}
+	a.add(fmt.Sprintf("Redactors[%d]", i))
+}
+
+// cookieName returns the name of the cookie in the Cookie or
+// Set-Cookie header value, eg "session" for "session=abc; Path=/"
+func cookieName(value []byte) string {
+	if end := bytes.IndexAny(value, "=;"); end >= 0 {
+		value = value[:end]
+	}
+	return string(bytes.TrimSpace(value))
+}
+
+// auditedRedactor is implemented by the default Redactors to record
+// what they redact in audit, which may be nil
+type auditedRedactor interface {
+	redactAudited(buf []byte, dir Direction, contentType string, audit *redactionAudit) []byte
+}
@@ -0,0 +1,123 @@
+package debughttp
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactionAudit(t *testing.T) {
+	// A nil audit records nothing
+	var none *redactionAudit
+	none.add("A")
+	none.header("Cookie", []byte("a=1"))
+	none.changed("A", []byte("1"), []byte("2"))
+	none.pattern(regexp.MustCompile("x"))
+	none.redactor(0)
+
+	audit := new(redactionAudit)
+	audit.header("Authorization", []byte("secret"))
+	audit.header("X-Empty", []byte(" "))
+	audit.header("Cookie", []byte("theme=dark; session=secret"))
+	audit.changed("X-Same", []byte("1"), []byte("1"))
+	audit.changed("Cookie", []byte("a=1; b=2; c=3"), []byte("a=1; b=XXXX; c=XXXX"))
+	audit.changed("X-Token", []byte("1"), []byte("XXXX"))
+	audit.pattern(regexp.MustCompile(`/users/([^/]+)`))
+	audit.redactor(1)
+	audit.add("Authorization")
+	assert.Equal(t, []string{
+		"Authorization",
+		"Cookie(theme)",
+		"Cookie(session)",
+		"Cookie(b)",
+		"Cookie(c)",
+		"X-Token",
+		"line(/users/([^/]+))",
+		"Redactors[1]",
+	}, audit.names)
+}
+
+func TestRedactAudit(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/path" {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "NEWSECRET", Path: "/"})
+		}
+	}))
+	defer ts.Close()
+
+	client, capture := NewCaptureClient(&Options{
+		Flags:               DumpHeaders,
+		RedactAudit:         true,
+		RedactQueryPatterns: []*regexp.Regexp{regexp.MustCompile(`^api_key$`)},
+		RedactFunc: func(name, value string) (string, bool) {
+			if name == "Cookie" {
+				return strings.Replace(value, "session=SECRET", "session=XXXX", 1), true
+			}
+			return value, false
+		},
+	})
+	req, err := http.NewRequest("GET", ts.URL+"/path?api_key=SECRET&page=2", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer SECRET")
+	req.Header.Set("Cookie", "theme=dark; session=SECRET")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	// The audit lines come before the dumps and only have the names
+	lines := capture.Lines()
+	require.Equal(t, 10, len(lines))
+	assert.Equal(t, "redacted: Authorization, query(api_key), Cookie(session)", lines[2])
+	assert.Equal(t, "redacted: Set-Cookie(session)", lines[7])
+	assert.NotContains(t, lines[2]+lines[7], "SECRET")
+
+	// Nothing is logged if nothing was redacted
+	capture.Reset()
+	resp, err = client.Get(ts.URL + "/?page=2")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, 8, len(capture.Lines()))
+	assert.NotContains(t, capture.String(), "redacted:")
+}
+
+func TestRedactAuditPatternsAndRedactors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "/users/SECRET/next")
+	}))
+	defer ts.Close()
+
+	userPattern := regexp.MustCompile(`/users/([^/]+)`)
+	client, capture := NewCaptureClient(&Options{
+		Flags:              DumpBodies,
+		RedactAudit:        true,
+		RedactLinePatterns: []*regexp.Regexp{userPattern},
+		Redactors: []Redactor{
+			// Changes nothing so isn't named
+			RedactorFunc(func(buf []byte, dir Direction, contentType string) []byte {
+				return buf
+			}),
+			// Changes the length of the body
+			RedactorFunc(func(buf []byte, dir Direction, contentType string) []byte {
+				return bytes.Replace(buf, []byte(`"password":"SECRET"`), []byte(`"password":"[hidden]"`), 1)
+			}),
+		},
+	})
+	req, err := http.NewRequest("POST", ts.URL+"/users/SECRET/login", strings.NewReader(`{"password":"SECRET"}`))
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	// The pattern and the Redactors entry which matched are named
+	lines := capture.Lines()
+	require.Equal(t, 10, len(lines))
+	assert.Equal(t, "redacted: line(/users/([^/]+)), Redactors[1]", lines[2])
+	assert.Equal(t, "redacted: line(/users/([^/]+))", lines[7])
+	assert.NotContains(t, capture.String(), "SECRET")
+}
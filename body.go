@@ -0,0 +1,354 @@
+package debughttp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// readCloser joins an io.Reader and an io.Closer
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// bufferedBody is a request body which has been read into memory
+type bufferedBody struct {
+	*bytes.Reader
+}
+
+// Close does nothing
+func (bufferedBody) Close() error {
+	return nil
+}
+
+// countingBody wraps a response body counting the bytes read from it
+// so the amount actually read can be reported when it is closed.
+type countingBody struct {
+	io.ReadCloser
+	t      *Transport
+	tx     *transaction
+	length int64 // the expected length or -1 if unknown
+	n      int64 // bytes read so far - use atomic
+	eof    int32 // set to 1 when EOF has been read - use atomic
+	once   sync.Once
+}
+
+// newCountingBody wraps the response body of tx in a countingBody
+func newCountingBody(t *Transport, tx *transaction) *countingBody {
+	return &countingBody{
+		ReadCloser: tx.resp.Body,
+		t:          t,
+		tx:         tx,
+		length:     tx.resp.ContentLength,
+	}
+}
+
+// Read reads from the body counting the bytes
+func (b *countingBody) Read(p []byte) (n int, err error) {
+	n, err = b.ReadCloser.Read(p)
+	atomic.AddInt64(&b.n, int64(n))
+	if errors.Is(err, io.EOF) {
+		atomic.StoreInt32(&b.eof, 1)
+	}
+	return n, err
+}
+
+// Close closes the body and logs how much of it was read
+func (b *countingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		b.t.logf(b.tx.req, "%s (%s): %s", "HTTP RESPONSE BODY", b.tx.id(), b.summary())
+	})
+	return err
+}
+
+// summary describes how much of the body was read
+func (b *countingBody) summary() string {
+	n := atomic.LoadInt64(&b.n)
+	if atomic.LoadInt32(&b.eof) != 0 || (b.length >= 0 && n >= b.length) {
+		return fmt.Sprintf("read %d bytes", n)
+	}
+	if b.length >= 0 {
+		return fmt.Sprintf("read %d of %d bytes (closed early)", n, b.length)
+	}
+	return fmt.Sprintf("read %d bytes (closed early)", n)
+}
+
+// timingBody wraps a response body to time how long the transaction
+// took including reading the body, which is logged when it is closed.
+type timingBody struct {
+	io.ReadCloser
+	t    *Transport
+	tx   *transaction
+	mu   sync.Mutex
+	end  time.Time // when EOF was read
+	once sync.Once
+}
+
+// newTimingBody wraps the response body of tx in a timingBody
+func newTimingBody(t *Transport, tx *transaction) *timingBody {
+	return &timingBody{
+		ReadCloser: tx.resp.Body,
+		t:          t,
+		tx:         tx,
+	}
+}
+
+// Read reads from the body noting when EOF is read
+func (b *timingBody) Read(p []byte) (n int, err error) {
+	n, err = b.ReadCloser.Read(p)
+	if errors.Is(err, io.EOF) {
+		b.mu.Lock()
+		if b.end.IsZero() {
+			b.end = b.t.now()
+		}
+		b.mu.Unlock()
+	}
+	return n, err
+}
+
+// Close closes the body and logs the timings
+func (b *timingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		b.mu.Lock()
+		end := b.end
+		b.mu.Unlock()
+		if end.IsZero() {
+			end = b.t.now()
+		}
+		total := end.Sub(b.tx.start)
+		if ttfb, ok := b.tx.ttfb(); ok {
+			b.t.logf(b.tx.req, "%s (%s): ttfb=%v total=%v", "HTTP RESPONSE TIMING", b.tx.id(), ttfb, total)
+		} else {
+			b.t.logf(b.tx.req, "%s (%s): total=%v", "HTTP RESPONSE TIMING", b.tx.id(), total)
+		}
+	})
+	return err
+}
+
+// teeBody wraps a request body which can't be replayed, capturing the
+// start of it as the transport reads it so it can be dumped without
+// being buffered first.
+type teeBody struct {
+	io.ReadCloser
+	mu   sync.Mutex
+	max  int64  // maximum number of bytes to capture or <= 0 for all
+	buf  []byte // the bytes captured so far
+	n    int64  // the number of bytes read so far
+	read bool   // set once the body has been read from
+	done bool   // set when EOF has been read or the body closed
+}
+
+// newTeeBody wraps body in a teeBody capturing up to max bytes
+func newTeeBody(body io.ReadCloser, max int64) *teeBody {
+	return &teeBody{
+		ReadCloser: body,
+		max:        max,
+	}
+}
+
+// Read reads from the body capturing the bytes
+func (b *teeBody) Read(p []byte) (n int, err error) {
+	n, err = b.ReadCloser.Read(p)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	captured := p[:n]
+	if b.max > 0 {
+		room := b.max - int64(len(b.buf))
+		if room < 0 {
+			room = 0
+		}
+		if int64(len(captured)) > room {
+			captured = captured[:room]
+		}
+	}
+	b.buf = append(b.buf, captured...)
+	b.n += int64(n)
+	b.read = true
+	if errors.Is(err, io.EOF) {
+		b.done = true
+	}
+	return n, err
+}
+
+// Close closes the body
+func (b *teeBody) Close() error {
+	b.mu.Lock()
+	b.done = true
+	b.mu.Unlock()
+	return b.ReadCloser.Close()
+}
+
+// captured returns a copy of the bytes captured so far, the number of
+// bytes read and whether the body has been finished with.
+func (b *teeBody) captured() (buf []byte, n int64, done bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf...), b.n, b.done
+}
+
+// unread returns true if the body hasn't been read from at all, eg
+// because the server rejected an Expect: 100-continue request.
+func (b *teeBody) unread() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.read
+}
+
+// hashLen is the number of hex digits of the body hashes to show
+const hashLen = 16
+
+// hashingBody wraps a body hashing the bytes read from it so the hash
+// can be logged when it is closed without the body being buffered.
+type hashingBody struct {
+	io.ReadCloser
+	t     *Transport
+	tx    *transaction
+	title string // the title of the log line
+	mu    sync.Mutex
+	hash  hash.Hash
+	n     int64 // bytes read so far
+	eof   bool  // set when EOF has been read
+	once  sync.Once
+}
+
+// newHashingBody wraps body in a hashingBody which logs its hash with
+// title when it is closed
+func newHashingBody(t *Transport, tx *transaction, body io.ReadCloser, title string) *hashingBody {
+	return &hashingBody{
+		ReadCloser: body,
+		t:          t,
+		tx:         tx,
+		title:      title,
+		hash:       sha256.New(),
+	}
+}
+
+// Read reads from the body hashing the bytes
+func (b *hashingBody) Read(p []byte) (n int, err error) {
+	n, err = b.ReadCloser.Read(p)
+	b.mu.Lock()
+	_, _ = b.hash.Write(p[:n])
+	b.n += int64(n)
+	if errors.Is(err, io.EOF) {
+		b.eof = true
+	}
+	b.mu.Unlock()
+	return n, err
+}
+
+// Close closes the body and logs its hash
+func (b *hashingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		b.t.logf(b.tx.req, "%s (%s): %s", b.title, b.tx.id(), b.summary())
+	})
+	return err
+}
+
+// summary describes the hash of the body
+func (b *hashingBody) summary() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sum := hex.EncodeToString(b.hash.Sum(nil))[:hashLen]
+	if !b.eof {
+		return fmt.Sprintf("sha256:%s (%d bytes, closed early)", sum, b.n)
+	}
+	return fmt.Sprintf("sha256:%s (%d bytes)", sum, b.n)
+}
+
+// readResult is the result of one Read of a body
+type readResult struct {
+	p   []byte
+	err error
+}
+
+// readChunk reads once from body into a new buffer sending the result
+// to results
+func readChunk(body io.Reader, results chan<- readResult) {
+	p := make([]byte, 32*1024)
+	n, err := body.Read(p)
+	results <- readResult{p: p[:n], err: err}
+}
+
+// readTimed reads body until EOF, an error or timeout, whichever is
+// first, returning what it read and a replacement body which reads
+// the same bytes as body would have.
+//
+// If it timed out then at most one Read of body is still in progress
+// and its result is returned by the replacement body after captured,
+// so nothing more than was captured is consumed.
+func readTimed(body io.ReadCloser, timeout time.Duration) (captured []byte, rest io.ReadCloser, timedOut bool, err error) {
+	results := make(chan readResult, 1)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	go readChunk(body, results)
+	for {
+		select {
+		case r := <-results:
+			captured = append(captured, r.p...)
+			if r.err == io.EOF {
+				_ = body.Close()
+				return captured, ioutil.NopCloser(bytes.NewReader(captured)), false, nil
+			}
+			if r.err != nil {
+				return captured, readCloser{io.MultiReader(bytes.NewReader(captured), errorReader{r.err}), body}, false, r.err
+			}
+			go readChunk(body, results)
+		case <-timer.C:
+			pending := &pendingBody{body: body, results: results}
+			return captured, readCloser{io.MultiReader(bytes.NewReader(captured), pending), pending}, true, nil
+		}
+	}
+}
+
+// errorReader is an io.Reader which always returns err
+type errorReader struct {
+	err error
+}
+
+func (r errorReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+// pendingBody reads a body which has a Read in progress started by
+// readTimed, returning its result before reading the body directly.
+type pendingBody struct {
+	body    io.ReadCloser
+	results <-chan readResult // the Read in progress if not nil
+	buf     []byte            // unread data from the Read in progress
+	err     error             // error from the Read in progress
+}
+
+// Read reads from the Read in progress then from the body
+func (b *pendingBody) Read(p []byte) (n int, err error) {
+	if b.results != nil {
+		r := <-b.results
+		b.results = nil
+		b.buf, b.err = r.p, r.err
+	}
+	if len(b.buf) > 0 {
+		n = copy(p, b.buf)
+		b.buf = b.buf[n:]
+		return n, nil
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.body.Read(p)
+}
+
+// Close closes the body which finishes any Read in progress
+func (b *pendingBody) Close() error {
+	return b.body.Close()
+}
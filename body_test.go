@@ -0,0 +1,459 @@
+package debughttp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCountingBody(t *testing.T) {
+	const responseBody = "0123456789"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/chunked" {
+			w.(http.Flusher).Flush()
+		} else {
+			w.Header().Set("Content-Length", fmt.Sprint(len(responseBody)))
+		}
+		fmt.Fprint(w, responseBody)
+	}))
+	defer ts.Close()
+
+	var lines []string
+	client := NewClient(&Options{
+		Flags: DumpSizes,
+		Logf: func(format string, v ...interface{}) {
+			lines = append(lines, fmt.Sprintf(format, v...))
+		},
+	})
+
+	for _, test := range []struct {
+		name string
+		path string
+		read int
+		want string
+	}{
+		{name: "All", path: "/", read: -1, want: "read 10 bytes"},
+		{name: "Exact", path: "/", read: 10, want: "read 10 bytes"},
+		{name: "Early", path: "/", read: 4, want: "read 4 of 10 bytes (closed early)"},
+		{name: "None", path: "/", read: 0, want: "read 0 of 10 bytes (closed early)"},
+		{name: "UnknownLength", path: "/chunked", read: 4, want: "read 4 bytes (closed early)"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			lines = nil
+			resp, err := client.Get(ts.URL + test.path)
+			require.NoError(t, err)
+			if test.read < 0 {
+				_, err = ioutil.ReadAll(resp.Body)
+			} else {
+				_, err = io.ReadFull(resp.Body, make([]byte, test.read))
+			}
+			require.NoError(t, err)
+			assert.Equal(t, 0, len(lines))
+			require.NoError(t, resp.Body.Close())
+			require.NoError(t, resp.Body.Close())
+			require.Equal(t, 1, len(lines))
+			assert.True(t, strings.HasPrefix(lines[0], "HTTP RESPONSE BODY (req "), lines[0])
+			assert.True(t, strings.HasSuffix(lines[0], "): "+test.want), lines[0])
+		})
+	}
+}
+
+func TestTeeBody(t *testing.T) {
+	for _, test := range []struct {
+		max  int64
+		want string
+	}{
+		{0, "0123456789"},
+		{4, "0123"},
+		{20, "0123456789"},
+	} {
+		b := newTeeBody(ioutil.NopCloser(strings.NewReader("0123456789")), test.max)
+		buf, n, done := b.captured()
+		assert.Equal(t, "", string(buf))
+		assert.Equal(t, int64(0), n)
+		assert.False(t, done)
+
+		p := make([]byte, 3)
+		_, err := b.Read(p)
+		require.NoError(t, err)
+		buf, n, done = b.captured()
+		assert.Equal(t, test.want[:3], string(buf))
+		assert.Equal(t, int64(3), n)
+		assert.False(t, done)
+
+		rest, err := ioutil.ReadAll(b)
+		require.NoError(t, err)
+		assert.Equal(t, "3456789", string(rest))
+		buf, n, done = b.captured()
+		assert.Equal(t, test.want, string(buf), test.max)
+		assert.Equal(t, int64(10), n)
+		assert.True(t, done)
+		require.NoError(t, b.Close())
+	}
+}
+
+func TestTeeRequestBody(t *testing.T) {
+	const requestBody = "Request text which is only read once"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, requestBody, string(body))
+		fmt.Fprint(w, "Response body")
+	}))
+	defer ts.Close()
+
+	for _, test := range []struct {
+		name           string
+		maxReqBodySize int64
+		want           string
+	}{
+		{name: "Full", want: requestBody},
+		{name: "Truncated", maxReqBodySize: 12, want: requestBody[:12] + "\n... [24 bytes truncated]\n"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var lines []string
+			client := NewClient(&Options{
+				Flags:          DumpBodies,
+				TeeRequestBody: true,
+				MaxReqBodySize: test.maxReqBodySize,
+				Logf: func(format string, v ...interface{}) {
+					lines = append(lines, fmt.Sprintf(format, v...))
+				},
+			})
+
+			// Use a plain io.Reader so there is no GetBody
+			body := &readCounter{Reader: strings.NewReader(requestBody)}
+			req, err := http.NewRequest("POST", ts.URL, body)
+			require.NoError(t, err)
+			require.Nil(t, req.GetBody)
+			req.ContentLength = int64(len(requestBody))
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+
+			// The caller's request isn't modified
+			assert.Same(t, body, req.Body)
+
+			// No buffering warning and the request block comes
+			// before the response block
+			require.Equal(t, 8, len(lines))
+			assert.Equal(t, SeparatorReq, lines[0])
+			assert.Contains(t, lines[1], "HTTP REQUEST")
+			assert.True(t, strings.HasPrefix(lines[2], "POST / HTTP/1.1\r\n"), lines[2])
+			assert.Contains(t, lines[2], "Content-Length: 36\r\n")
+			assert.True(t, strings.HasSuffix(lines[2], "\r\n\r\n"+test.want), lines[2])
+			assert.Equal(t, SeparatorReq, lines[3])
+			assert.Contains(t, lines[5], "HTTP RESPONSE")
+			assert.Contains(t, lines[6], "Response body")
+		})
+	}
+}
+
+func TestExpectContinue(t *testing.T) {
+	const requestBody = "Request body sent after 100 Continue"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/reject" {
+			// Replying without reading the body means the server
+			// doesn't send 100 Continue
+			w.WriteHeader(http.StatusExpectationFailed)
+			return
+		}
+		// Reading the body makes the server send 100 Continue
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, requestBody, string(body))
+		fmt.Fprint(w, "Response body")
+	}))
+	defer ts.Close()
+
+	for _, test := range []struct {
+		name      string
+		path      string
+		wantReads bool
+		want      string
+	}{
+		{name: "Accepted", path: "/", wantReads: true, want: "\r\n\r\n" + requestBody},
+		{name: "Rejected", path: "/reject", wantReads: false, want: "\r\n\r\n" + notSentBodyNote + "\n"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var capture Capture
+			client := &http.Client{
+				Transport: New(&Options{
+					Flags: DumpBodies,
+					Logf:  capture.Logf,
+				}, &http.Transport{ExpectContinueTimeout: 5 * time.Second}),
+			}
+
+			// Use a plain io.Reader so there is no GetBody
+			body := &readCounter{Reader: strings.NewReader(requestBody)}
+			req, err := http.NewRequest("POST", ts.URL+test.path, body)
+			require.NoError(t, err)
+			req.ContentLength = int64(len(requestBody))
+			req.Header.Set("Expect", "100-continue")
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+
+			// The body wasn't read before the round trip so there is
+			// no buffering warning and the request is logged after it.
+			// The caller's request isn't modified.
+			assert.Equal(t, test.wantReads, body.reads > 0)
+			assert.Same(t, body, req.Body)
+			lines := capture.Lines()
+			require.Equal(t, 8, len(lines))
+			assert.Contains(t, lines[1], "HTTP REQUEST")
+			assert.Contains(t, lines[2], "Expect: 100-continue\r\n")
+			assert.True(t, strings.HasSuffix(lines[2], test.want), lines[2])
+			assert.Contains(t, lines[5], "HTTP RESPONSE")
+		})
+	}
+}
+
+func TestMaxBodyDumpContentLength(t *testing.T) {
+	big := strings.Repeat("x", 2000)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/big":
+			fmt.Fprint(w, big)
+		case "/chunked":
+			w.(http.Flusher).Flush()
+			fmt.Fprint(w, big)
+		case "/chunked-small":
+			w.(http.Flusher).Flush()
+			fmt.Fprint(w, "small")
+		default:
+			fmt.Fprint(w, "small")
+		}
+	}))
+	defer ts.Close()
+
+	for _, test := range []struct {
+		path     string
+		want     string
+		wantBody string
+	}{
+		{path: "/small", want: "\r\n\r\nsmall", wantBody: "small"},
+		{path: "/big", want: "\r\n\r\n[body omitted: 2000 bytes]\n", wantBody: big},
+		{path: "/chunked-small", want: "\r\n5\r\nsmall\r\n0\r\n\r\n", wantBody: "small"},
+		{path: "/chunked", want: "\r\n\r\n[body omitted: more than 1000 bytes]\n", wantBody: big},
+	} {
+		t.Run(test.path, func(t *testing.T) {
+			var lines []string
+			var events []Event
+			client := NewClient(&Options{
+				Flags:                    DumpBodies,
+				MaxBodyDumpContentLength: 1000,
+				Logf: func(format string, v ...interface{}) {
+					lines = append(lines, fmt.Sprintf(format, v...))
+				},
+				OnEvent: func(ev Event) {
+					events = append(events, ev)
+				},
+			})
+			resp, err := client.Get(ts.URL + test.path)
+			require.NoError(t, err)
+			body, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+
+			// The caller always gets the whole body
+			assert.Equal(t, test.wantBody, string(body))
+
+			require.Equal(t, 8, len(lines))
+			assert.True(t, strings.HasSuffix(lines[6], test.want), lines[6])
+			require.Equal(t, 2, len(events))
+			if len(test.wantBody) > 1000 {
+				assert.Nil(t, events[1].Body)
+			} else {
+				assert.Equal(t, test.wantBody, string(events[1].Body))
+			}
+		})
+	}
+}
+
+func TestBodyTooBigOnce(t *testing.T) {
+	small := NewDefault(&Options{MaxBodyDumpContentLength: 10})
+	large := NewDefault(&Options{MaxBodyDumpContentLength: 20})
+	body := &readCounter{Reader: strings.NewReader(strings.Repeat("x", 15))}
+	tx := &transaction{
+		resp:    &http.Response{ContentLength: -1, Body: body},
+		peekMax: maxBodyDumpContentLength([]*Transport{small, large}),
+	}
+
+	// The start of the body is only read once for all the outputs
+	assert.Equal(t, "[body omitted: more than 10 bytes]", small.bodyTooBig(tx))
+	reads := body.reads
+	assert.Equal(t, "", large.bodyTooBig(tx))
+	assert.Equal(t, "[body omitted: more than 10 bytes]", small.bodyTooBig(tx))
+	assert.Equal(t, reads, body.reads)
+
+	got, err := ioutil.ReadAll(tx.resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("x", 15), string(got))
+}
+
+func TestReadTimed(t *testing.T) {
+	// Complete
+	body := ioutil.NopCloser(strings.NewReader("all of it"))
+	captured, rest, timedOut, err := readTimed(body, time.Minute)
+	require.NoError(t, err)
+	assert.False(t, timedOut)
+	assert.Equal(t, "all of it", string(captured))
+	got, err := ioutil.ReadAll(rest)
+	require.NoError(t, err)
+	assert.Equal(t, "all of it", string(got))
+
+	// Error
+	errRead := errors.New("read failed")
+	body = ioutil.NopCloser(io.MultiReader(strings.NewReader("some"), errorReader{errRead}))
+	captured, rest, timedOut, err = readTimed(body, time.Minute)
+	assert.Equal(t, errRead, err)
+	assert.False(t, timedOut)
+	assert.Equal(t, "some", string(captured))
+	got, err = ioutil.ReadAll(rest)
+	assert.Equal(t, errRead, err)
+	assert.Equal(t, "some", string(got))
+
+	// Timeout with a Read in progress
+	pr, pw := io.Pipe()
+	go func() {
+		_, _ = pw.Write([]byte("first "))
+	}()
+	captured, rest, timedOut, err = readTimed(pr, 50*time.Millisecond)
+	require.NoError(t, err)
+	assert.True(t, timedOut)
+	assert.Equal(t, "first ", string(captured))
+	go func() {
+		_, _ = pw.Write([]byte("second"))
+		_ = pw.Close()
+	}()
+	got, err = ioutil.ReadAll(rest)
+	require.NoError(t, err)
+	assert.Equal(t, "first second", string(got))
+	require.NoError(t, rest.Close())
+}
+
+func TestBodyDumpTimeout(t *testing.T) {
+	release := make(chan struct{})
+	var releaseOnce sync.Once
+	unblock := func() { releaseOnce.Do(func() { close(release) }) }
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "first part")
+		w.(http.Flusher).Flush()
+		<-release
+		fmt.Fprint(w, " second part")
+	}))
+	defer ts.Close()
+	defer unblock()
+
+	var events []Event
+	client, capture := NewCaptureClient(&Options{
+		Flags:           DumpBodies,
+		BodyDumpTimeout: 100 * time.Millisecond,
+		OnEvent: func(ev Event) {
+			events = append(events, ev)
+		},
+	})
+	resp, err := client.Get(ts.URL)
+	require.NoError(t, err)
+
+	lines := capture.Lines()
+	require.Equal(t, 8, len(lines))
+	assert.True(t, strings.HasSuffix(lines[6], "\r\n\r\nfirst part\n[body dump timed out after 100ms]\n"), lines[6])
+	require.Equal(t, 2, len(events))
+	assert.Nil(t, events[1].Body)
+
+	// The caller gets the whole body
+	unblock()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, "first part second part", string(body))
+}
+
+func TestBodyHash(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(w, r.Body)
+	}))
+	defer ts.Close()
+
+	client, capture := NewCaptureClient(&Options{
+		Flags:    0,
+		BodyHash: true,
+	})
+	post := func(body string) (reqHash, respHash string) {
+		capture.Reset()
+		// Not replayable so it is streamed
+		resp, err := client.Post(ts.URL, "text/plain", ioutil.NopCloser(strings.NewReader(body)))
+		require.NoError(t, err)
+		got, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, body, string(got))
+		lines := capture.Lines()
+		require.Equal(t, 2, len(lines))
+		assert.Regexp(t, `^HTTP REQUEST BODY \(req 0x[0-9a-f]+\): sha256:[0-9a-f]{16} \(`+fmt.Sprint(len(body))+` bytes\)$`, lines[0])
+		assert.Regexp(t, `^HTTP RESPONSE BODY \(req 0x[0-9a-f]+\): sha256:[0-9a-f]{16} \(`+fmt.Sprint(len(body))+` bytes\)$`, lines[1])
+		return lines[0][strings.Index(lines[0], "sha256:"):], lines[1][strings.Index(lines[1], "sha256:"):]
+	}
+
+	req1, resp1 := post("Hello, World")
+	req2, resp2 := post("Hello, World")
+	req3, _ := post("Hello, world")
+	assert.Equal(t, "sha256:03675ac53ff9cd15 (12 bytes)", req1)
+	assert.Equal(t, req1, resp1)
+	assert.Equal(t, req1, req2)
+	assert.Equal(t, resp1, resp2)
+	assert.NotEqual(t, req1, req3)
+
+	// Closed early
+	capture.Reset()
+	resp, err := client.Post(ts.URL, "text/plain", strings.NewReader("Hello, World"))
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	lines := capture.Lines()
+	require.Equal(t, 2, len(lines))
+	assert.Regexp(t, `^HTTP RESPONSE BODY \(req 0x[0-9a-f]+\): sha256:e3b0c44298fc1c14 \(0 bytes, closed early\)$`, lines[1])
+}
+
+func TestTimingBody(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Response body")
+	}))
+	defer ts.Close()
+
+	client, capture := NewCaptureClient(&Options{
+		Flags:    DumpHeaders | DumpTiming,
+		IDPrefix: "r",
+	})
+	// Each reading of the clock is 100ms after the last: the start,
+	// the first byte, the end of the round trip and EOF
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	var mu sync.Mutex
+	client.Transport.(*Transport).SetClock(func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(100 * time.Millisecond)
+		return now
+	})
+	resp, err := client.Get(ts.URL)
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	lines := capture.Lines()
+	require.Equal(t, 10, len(lines))
+	assert.Equal(t, "timing: round trip 200ms ttfb=100ms", lines[6])
+	assert.Equal(t, "HTTP RESPONSE TIMING (req r1): ttfb=100ms total=300ms", lines[9])
+}
@@ -0,0 +1,166 @@
+package debughttp
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+)
+
+// budgetNote is shown instead of bodies which would take the bodies
+// held for dumping over TotalBodyBudget
+const budgetNote = "[body skipped: global budget exhausted]"
+
+// bodyBudget is the number of bytes of bodies which may be held for
+// dumping at once by all the round trips of a Transport
+type bodyBudget struct {
+	remaining int64 // use atomic
+}
+
+// newBodyBudget makes a bodyBudget of n bytes
+func newBodyBudget(n int64) *bodyBudget {
+	return &bodyBudget{remaining: n}
+}
+
+// reserve takes n bytes from the budget returning false if there
+// aren't enough left
+func (b *bodyBudget) reserve(n int64) bool {
+	for {
+		remaining := atomic.LoadInt64(&b.remaining)
+		if n > remaining {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&b.remaining, remaining, remaining-n) {
+			return true
+		}
+	}
+}
+
+// release returns n bytes to the budget
+func (b *bodyBudget) release(n int64) {
+	atomic.AddInt64(&b.remaining, n)
+}
+
+// budgetState is the result of checking a body against the budget
+type budgetState uint8
+
+const (
+	budgetUnchecked budgetState = iota
+	budgetReserved              // the body is in the budget so can be dumped
+	budgetExhausted             // the body must be skipped
+)
+
+// reserveBody reserves n bytes for a body of tx, which is released
+// when the round trip has been logged
+func (tx *transaction) reserveBody(n int64) budgetState {
+	if !tx.budget.reserve(n) {
+		return budgetExhausted
+	}
+	tx.reserved += n
+	return budgetReserved
+}
+
+// releaseBudget releases the bytes reserved by tx
+func (tx *transaction) releaseBudget() {
+	tx.budget.release(tx.reserved)
+	tx.reserved = 0
+}
+
+// requestBodyInBudget returns true if the request body of tx can be
+// dumped within TotalBodyBudget, reserving it the first time it is
+// called.
+//
+// If the length of the body is unknown then up to the remaining budget
+// is read to find out without disturbing the body. Captured bodies are
+// accounted for once they have been captured so may take the memory in
+// use over the budget briefly.
+func (t *Transport) requestBodyInBudget(tx *transaction) bool {
+	req := tx.req
+	if tx.budget == nil || req.Body == nil || req.Body == http.NoBody {
+		return true
+	}
+	if tx.reqBudget == budgetUnchecked {
+		n := req.ContentLength
+		if tx.tee != nil {
+			body, _, _ := tx.tee.captured()
+			n = int64(len(body))
+		} else if n <= 0 {
+			max := atomic.LoadInt64(&tx.budget.remaining)
+			start, err := peekRequestBody(req, max+1)
+			n = int64(len(start))
+			if err != nil || n > max {
+				tx.reqBudget = budgetExhausted
+				return false
+			}
+		}
+		tx.reqBudget = tx.reserveBody(n)
+	}
+	return tx.reqBudget == budgetReserved
+}
+
+// peekRequestBody returns up to max bytes from the start of the body
+// of req without disturbing the body which is handed to the underlying
+// transport.
+//
+// Like requestBody it uses req.GetBody or rewinds the body if it can,
+// otherwise what was read is spliced back onto the start of req.Body.
+func peekRequestBody(req *http.Request, max int64) ([]byte, error) {
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = body.Close()
+		}()
+		return ioutil.ReadAll(io.LimitReader(body, max))
+	}
+	if seeker, ok := req.Body.(io.Seeker); ok {
+		pos, err := seeker.Seek(0, io.SeekCurrent)
+		if err == nil {
+			start, err := ioutil.ReadAll(io.LimitReader(req.Body, max))
+			if _, seekErr := seeker.Seek(pos, io.SeekStart); seekErr != nil {
+				return nil, seekErr
+			}
+			return start, err
+		}
+	}
+	start, err := ioutil.ReadAll(io.LimitReader(req.Body, max))
+	req.Body = readCloser{
+		Reader: io.MultiReader(bytes.NewReader(start), req.Body),
+		Closer: req.Body,
+	}
+	return start, err
+}
+
+// responseBodyInBudget returns true if the response body of tx can be
+// dumped within TotalBodyBudget, reserving it the first time it is
+// called.
+//
+// If the length of the body is unknown then up to the remaining budget
+// is read to find out, and the body is replaced so nothing is lost.
+func (t *Transport) responseBodyInBudget(tx *transaction) bool {
+	resp := tx.resp
+	if tx.budget == nil || resp.Body == nil || resp.Body == http.NoBody {
+		return true
+	}
+	if tx.respBudget == budgetUnchecked {
+		n := resp.ContentLength
+		if n < 0 {
+			max := atomic.LoadInt64(&tx.budget.remaining)
+			start, err := ioutil.ReadAll(io.LimitReader(resp.Body, max+1))
+			resp.Body = readCloser{
+				Reader: io.MultiReader(bytes.NewReader(start), resp.Body),
+				Closer: resp.Body,
+			}
+			n = int64(len(start))
+			if err != nil || n > max {
+				tx.respBudget = budgetExhausted
+				return false
+			}
+		}
+		tx.respBudget = tx.reserveBody(n)
+	}
+	return tx.respBudget == budgetReserved
+}
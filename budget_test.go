@@ -0,0 +1,149 @@
+package debughttp
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBodyBudget(t *testing.T) {
+	b := newBodyBudget(10)
+	assert.True(t, b.reserve(6))
+	assert.False(t, b.reserve(5))
+	assert.True(t, b.reserve(4))
+	assert.False(t, b.reserve(1))
+	assert.True(t, b.reserve(0))
+	b.release(10)
+	assert.True(t, b.reserve(10))
+}
+
+func TestTotalBodyBudget(t *testing.T) {
+	const n = 5
+	var arrived int32
+	ready := make(chan struct{})
+	var readyOnce sync.Once
+	unblock := func() { readyOnce.Do(func() { close(ready) }) }
+	defer unblock()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Wait until all the requests are in flight
+		if atomic.AddInt32(&arrived, 1) == n {
+			unblock()
+		}
+		select {
+		case <-ready:
+		case <-time.After(10 * time.Second):
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	client, capture := NewCaptureClient(&Options{
+		Flags:           DumpBodies,
+		TotalBodyBudget: 250,
+	})
+	transport := client.Transport.(*Transport)
+	body := strings.Repeat("x", 100)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Post(ts.URL, "text/plain", strings.NewReader(body))
+			if assert.NoError(t, err) {
+				assert.NoError(t, resp.Body.Close())
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Only two of the request bodies fit in the budget at once
+	dumped, skipped := 0, 0
+	for _, line := range capture.Lines() {
+		if !strings.HasPrefix(line, "POST / HTTP/1.1\r\n") {
+			continue
+		}
+		if strings.HasSuffix(line, "\r\n\r\n"+body) {
+			dumped++
+		} else if strings.HasSuffix(line, "\r\n\r\n"+budgetNote+"\n") {
+			skipped++
+		}
+	}
+	assert.Equal(t, 2, dumped)
+	assert.Equal(t, n-2, skipped)
+
+	// The budget is released once the round trips are logged
+	assert.Equal(t, int64(250), atomic.LoadInt64(&transport.budget.remaining))
+	capture.Reset()
+	resp, err := client.Post(ts.URL, "text/plain", strings.NewReader(body))
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	lines := capture.Lines()
+	require.Equal(t, 8, len(lines))
+	assert.True(t, strings.HasSuffix(lines[2], "\r\n\r\n"+body), lines[2])
+	assert.True(t, strings.HasSuffix(lines[6], "\r\n\r\nok"), lines[6])
+}
+
+func TestTotalBodyBudgetUnknownLengthRequest(t *testing.T) {
+	body := strings.Repeat("x", 100)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, body, string(got))
+	}))
+	defer ts.Close()
+
+	client, capture := NewCaptureClient(&Options{
+		Flags:           DumpBodies,
+		TotalBodyBudget: 60,
+	})
+	// Use a plain io.Reader so there is no GetBody or length
+	req, err := http.NewRequest("POST", ts.URL, &readCounter{Reader: strings.NewReader(body)})
+	require.NoError(t, err)
+	require.Equal(t, int64(0), req.ContentLength)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	// Only the budget was read to find it was too big so the body
+	// wasn't buffered and is intact for the server
+	lines := capture.Lines()
+	require.Equal(t, 8, len(lines))
+	assert.True(t, strings.HasSuffix(lines[2], "\r\n\r\n"+budgetNote+"\n"), lines[2])
+	assert.NotContains(t, capture.String(), "buffering it in memory")
+}
+
+func TestTotalBodyBudgetResponse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Flush so the length is unknown
+		_, _ = w.Write([]byte(strings.Repeat("y", 50)))
+		w.(http.Flusher).Flush()
+		_, _ = w.Write([]byte(strings.Repeat("y", 50)))
+	}))
+	defer ts.Close()
+
+	client, capture := NewCaptureClient(&Options{
+		Flags:           DumpBodies,
+		TotalBodyBudget: 60,
+	})
+	resp, err := client.Get(ts.URL)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, resp.Body.Close()) }()
+	lines := capture.Lines()
+	require.Equal(t, 8, len(lines))
+	assert.True(t, strings.HasSuffix(lines[6], "\r\n\r\n"+budgetNote+"\n"), lines[6])
+
+	// The body is intact for the caller
+	got := new(strings.Builder)
+	_, err = io.Copy(got, resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("y", 100), got.String())
+}
@@ -0,0 +1,62 @@
+package debughttp
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Capture collects the logs of a Transport so they can be checked in
+// tests. Make one with NewCaptureClient or use its Logf method as
+// Options.Logf.
+//
+// It is safe for concurrent use.
+type Capture struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+// NewCaptureClient returns an http.Client which logs the HTTP
+// transactions as directed in opt to the Capture returned.
+//
+// Logf is set to the Capture's and LogfCtx and Writer are cleared in
+// a copy of opt so all the output is captured.
+func NewCaptureClient(opt *Options) (*http.Client, *Capture) {
+	if opt == nil {
+		opt = &DefaultOptions
+	}
+	c := new(Capture)
+	captureOpt := *opt
+	captureOpt.Logf = c.Logf
+	captureOpt.LogfCtx = nil
+	captureOpt.Writer = nil
+	return NewClient(&captureOpt), c
+}
+
+// Logf adds a line to the Capture
+func (c *Capture) Logf(format string, v ...interface{}) {
+	line := fmt.Sprintf(format, v...)
+	c.mu.Lock()
+	c.lines = append(c.lines, line)
+	c.mu.Unlock()
+}
+
+// Lines returns a copy of the lines captured so far
+func (c *Capture) Lines() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.lines...)
+}
+
+// String returns the lines captured so far joined with newlines
+func (c *Capture) String() string {
+	return strings.Join(c.Lines(), "\n")
+}
+
+// Reset discards the lines captured so far
+func (c *Capture) Reset() {
+	c.mu.Lock()
+	c.lines = nil
+	c.mu.Unlock()
+}
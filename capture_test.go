@@ -0,0 +1,74 @@
+package debughttp
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapture(t *testing.T) {
+	var c Capture
+	assert.Equal(t, 0, len(c.Lines()))
+	assert.Equal(t, "", c.String())
+
+	c.Logf("one %d", 1)
+	c.Logf("two")
+	assert.Equal(t, []string{"one 1", "two"}, c.Lines())
+	assert.Equal(t, "one 1\ntwo", c.String())
+
+	// Lines returns a copy
+	lines := c.Lines()
+	lines[0] = "changed"
+	assert.Equal(t, "one 1", c.Lines()[0])
+
+	c.Reset()
+	assert.Equal(t, 0, len(c.Lines()))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c.Logf("line %d", i)
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, 10, len(c.Lines()))
+}
+
+func TestNewCaptureClient(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Response body")
+	}))
+	defer ts.Close()
+
+	var out bytes.Buffer
+	client, capture := NewCaptureClient(&Options{
+		Flags:  DumpBodies,
+		Writer: &out,
+	})
+	resp, err := client.Get(ts.URL)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	lines := capture.Lines()
+	require.Equal(t, 8, len(lines))
+	assert.Equal(t, SeparatorReq, lines[0])
+	assert.True(t, strings.HasSuffix(lines[6], "\r\n\r\nResponse body"), lines[6])
+	assert.Contains(t, capture.String(), "HTTP RESPONSE")
+	assert.Equal(t, 0, out.Len())
+
+	// Defaults
+	client, capture = NewCaptureClient(nil)
+	resp, err = client.Get(ts.URL)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, 8, len(capture.Lines()))
+}
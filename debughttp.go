@@ -54,9 +54,13 @@ If you want more control over what is logged and what isn't logged
 then you can use the Options struct, eg
 
 	client := debughttp.NewClient(&debughttp.Options{
-		Flags: debughttp.DumpRequests|debughttp.DumpAuth,
+		Flags:         debughttp.DumpRequests|debughttp.DumpAuth,
+		AllowAuthDump: true,
 	}
 
+DumpAuth shows the secrets in the Auth headers so it only takes
+effect if AllowAuthDump is set too.
+
 If you are integrating this with code which has its own logging system
 then you will want to pass in the Logf parameter to control where
 the logs are sent.
@@ -67,7 +71,14 @@ replacement. (If you find one which doesn't, then report an issue!)
 
 To create a new Transport use the NewDefault function to base one
 off the default transport or the New function to base one off an
-existing transport.
+existing transport. Use NewRoundTripper to wrap any other
+http.RoundTripper and WrapClient to add logging to an existing
+http.Client keeping its other settings.
+
+As a safety net, if the environment variable DEBUGHTTP_DISABLE is set
+to a true value such as "1" then all the Transports pass the requests
+straight through without logging anything, whatever their Options.
+This can be overridden with SetDisabled.
 
 This means that you can use this library for debugging other people's
 code. For example this is how you add this library to the AWS SDK
@@ -80,24 +91,76 @@ code. For example this is how you add this library to the AWS SDK
 If you do this you can see exactly what requests are sent to and from
 AWS.
 
+Likewise with golang.org/x/oauth2 pass a client in the context so it
+is used both for the token refreshes and beneath the oauth2.Transport
+which adds the Bearer token, which is redacted unless DumpAuth is set
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, debughttp.NewClient(nil))
+	client := config.Client(ctx, token)
+
+To see the requests as they were made, before the token was added,
+wrap the oauth2 client with WrapClient instead or as well.
+
 Warnings
 
 If dumping bodies is enabled the bodies are held in memory so large
 requests and responses can use a lot of memory.
 
+The request body is read and logged before the request is handed to
+the underlying transport, so it will be shown even if the server
+rejects the request without reading it. Use MaxReqBodySize to limit
+how much of it is shown. Note that if the body can't be replayed
+with req.GetBody or rewound with Seek then all of it will still be
+buffered in memory unless TeeRequestBody is set. This captures the
+body as it is sent instead, keeping at most MaxReqBodySize of it, and
+logs the request after the round trip.
+
+The body of a request with an "Expect: 100-continue" header mustn't
+be read before the server has agreed to receive it, so it is always
+captured as the transport sends it, whether or not TeeRequestBody is
+set, and the request is logged after the round trip. If the server
+rejects the request the body is shown as "(body not sent)".
+
 The Accept-Encoding as shown may not be correct in the Request and
 the Response may not show Content-Encoding if the Go standard
 libraries auto gzip encoding was in effect. In this case the body of
-the request will be gunzipped before showing it.
+the response will be gunzipped before showing it and the response
+title is marked "[auto-decompressed]". If the caller asked for a
+compressed response itself then the body is shown as received and
+the title says so. Set RegzipBodies to see roughly how big an
+auto-decompressed body was on the wire.
 */
 package debughttp
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/http/httputil"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
 	"reflect"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
@@ -106,6 +169,26 @@ var (
 	SeparatorResp = "<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<"
 )
 
+// Direction is which way an HTTP message is travelling
+type Direction int
+
+// Direction definitions
+const (
+	DirectionRequest  Direction = iota // the request sent to the server
+	DirectionResponse                  // the response received from the server
+)
+
+// String turns a Direction into a human readable string
+func (dir Direction) String() string {
+	switch dir {
+	case DirectionRequest:
+		return "request"
+	case DirectionResponse:
+		return "response"
+	}
+	return fmt.Sprintf("Direction(%d)", int(dir))
+}
+
 // DumpFlags describes the Dump options in force
 type DumpFlags int
 
@@ -115,14 +198,205 @@ const (
 	DumpBodies                          // dump the bodies also
 	DumpRequests                        // dump all the headers and the request bodies but not the response bodies
 	DumpResponses                       // dump all the headers and the response bodies but not the request bodies
-	DumpAuth                            // dump the auth instead of redacting it
+	DumpAuth                            // dump the auth instead of redacting it - ignored unless AllowAuthDump is set
+	DumpSummary                         // log a one line summary of each transaction
+	DumpTiming                          // show how long the round trip and the first byte of the response took, and the total including reading the body when it is closed
+	DumpTLS                             // show the TLS connection state in the response
+	DumpConn                            // show the local and remote addresses of the connection, the TLS SNI and the proxy in the response
+	DumpLine                            // dump just the request and status lines - overridden by the other dump flags
+	DumpSizes                           // log how many bytes of the response body the caller read when it closes it
+	DumpCompact                         // log one line for the request and one for the response with counts of the headers and the body sizes
+	DumpWire                            // dump the bytes actually sent and received on HTTP/1.x connections - see New
+	DumpStruct                          // show the fields of the http.Request and http.Response which aren't on the wire, eg Uncompressed
+)
+
+// dumpDetailFlags are the flags which cause more than the request
+// and status lines to be dumped
+const dumpDetailFlags = DumpHeaders | DumpBodies | DumpAuth | DumpRequests | DumpResponses
+
+// dumpBlockFlags are the flags which cause the request and response
+// blocks to be logged
+const dumpBlockFlags = dumpDetailFlags | DumpLine
+
+// verbosityFlags converts a Verbosity level into DumpFlags
+//
+//	0: nothing
+//	1: DumpSummary
+//	2: DumpHeaders
+//	3: DumpBodies
+//	4: DumpBodies|DumpTiming|DumpTLS|DumpConn
+//
+// Levels above 4 are treated as 4.
+func verbosityFlags(verbosity int) DumpFlags {
+	switch {
+	case verbosity <= 0:
+		return 0
+	case verbosity == 1:
+		return DumpSummary
+	case verbosity == 2:
+		return DumpHeaders
+	case verbosity == 3:
+		return DumpBodies
+	}
+	return DumpBodies | DumpTiming | DumpTLS | DumpConn
+}
+
+// Format describes how the transactions are logged
+type Format int
+
+// Format definitions
+const (
+	FormatRaw      Format = iota // request and response blocks with the raw HTTP - the default
+	FormatHTTPFile               // requests only in .http file syntax as used by the VS Code and JetBrains REST clients
+	FormatLogfmt                 // one logfmt line per transaction after the round trip, with the headers if the Flags dump them
+	FormatPcapText               // two lines per transaction after the round trip like a packet capture summary, with → and ← arrows
 )
 
 // Options controls the configuration of the HTTP debugging
 type Options struct {
-	Flags DumpFlags                             // Which parts of the HTTP transaction we are dumping
-	Logf  func(format string, v ...interface{}) // Where to log the dumped transactions - defaults to log.Printf if not set
-	Auth  [][]byte                              // which headers we are treating as Auth to redact - defaults to Auth if not set
+	Flags                    DumpFlags                                                  // Which parts of the HTTP transaction we are dumping
+	Logf                     func(format string, v ...interface{})                      // Where to log the dumped transactions - defaults to log.Printf if not set
+	Auth                     [][]byte                                                   // which headers we are treating as Auth to redact - defaults to Auth if nil, an empty slice redacts nothing
+	AllowAuthDump            bool                                                       // must be set for DumpAuth to take effect, otherwise it is cleared with a warning, so showing secrets is a deliberate choice
+	RedactJWT                bool                                                       // if DumpAuth is set, show only the header of any JWTs in the Auth headers
+	MarkAuthPresent          bool                                                       // if set, replace redacted Auth values with "[present, N chars]" or "[absent]" if empty, and add "Authorization: [absent]" to request dumps with none of the Auth headers
+	RedactURLUser            bool                                                       // if set, redact the user name as well as the password in logged URLs
+	RedactQueryPatterns      []*regexp.Regexp                                           // if set, redact the values of the query parameters in logged URLs whose names match any of these, eg `(?i)_token$` or `^X-Amz-Signature$`
+	RedactLinePatterns       []*regexp.Regexp                                           // if set, redact the text matching any of these, or just their first group if they have one, in the request and status lines and the URL headers such as Location, even if DumpAuth is set
+	RedactShowLength         bool                                                       // if set, replace redacted Auth values with "[REDACTED N chars]" rather than "XXXX"
+	RedactMapping            bool                                                       // if set, replace each distinct redacted Auth or cookie value with the same alias every time, eg "TOKEN_1", so reuse can be seen without the values - only hashes of the last 10000 values are kept
+	RedactAudit              bool                                                       // if set, log the names, never the values, of the headers, cookies, query parameters, RedactLinePatterns and Redactors which redacted something from each dump, eg "redacted: Authorization, Cookie(session), query(api_key)"
+	MaxBodyDumpContentLength int64                                                      // if > 0, don't dump response bodies longer than this, eg downloads, showing "[body omitted: N bytes]" instead, and truncate NDJSON lines longer than this
+	DetectBodyLeak           bool                                                       // if set, warn if a response body is garbage collected without being closed or read to the end as that leaks the connection
+	BodyHash                 bool                                                       // if set, log a short sha256 hash and the size of each body when it is closed, eg to check two bodies are the same without dumping them
+	BodyDumpTimeout          time.Duration                                              // if > 0, stop reading a response body to dump it after this long, dumping what was read, leaving the rest for the caller
+	MaxConcurrentDumps       int                                                        // if > 0, the maximum number of transactions dumping bodies at once - others are dumped without their bodies
+	TotalBodyBudget          int64                                                      // if > 0, the maximum number of bytes of bodies held for dumping at once by all the round trips - others are dumped without their bodies
+	MaxReqBodySize           int64                                                      // if > 0, the maximum number of bytes of the request body to show
+	Caller                   bool                                                       // if set, log the file:line of the code which made the request - this is expensive
+	CallerSkip               int                                                        // number of extra stack frames to skip when finding the Caller, eg to skip retry wrappers
+	SeparatorFunc            func(req *http.Request, dir Direction) string              // if set, makes the separator lines around the request and response blocks instead of SeparatorReq and SeparatorResp - the summary and compact lines have none unless LineSeparators is set
+	LineSeparators           bool                                                       // if set, put the separator lines around the summary and compact lines too
+	LogfCtx                  func(ctx context.Context, format string, v ...interface{}) // if set, used instead of Logf and passed the request's context, eg for trace ids
+	LeveledLogger            Logger                                                     // if set, used instead of Logf and LogfCtx with failed round trips logged at Errorf, problems dumping and the one line logs of error responses at Warnf and the rest at Debugf
+	ErrorStatusFunc          func(code int) bool                                        // if set, returns whether a response with this status code is an error, eg for LeveledLogger - defaults to code >= 400
+	RedactPII                bool                                                       // if set, redact personally identifiable information matching PIIPatterns from dumped bodies
+	PIIPatterns              []PIIPattern                                               // patterns to use for RedactPII - defaults to PIIPatterns if nil
+	MaxHeaders               int                                                        // if > 0, the maximum number of header lines to show in each dump
+	AttemptFunc              func(req *http.Request) int                                // if set, returns the attempt number of req to show, eg from a header - defaults to AttemptFromContext
+	ReqTitle                 string                                                     // if set, the title of the request blocks instead of "HTTP REQUEST" or "HTTP CONNECT TUNNEL REQUEST"
+	RespTitle                string                                                     // if set, the title of the response blocks instead of "HTTP RESPONSE" or "HTTP CONNECT TUNNEL RESPONSE"
+	ShowProto                bool                                                       // if set, add the protocol actually used, eg "[protocol HTTP/2.0]", to the response title as the dumped request line always says HTTP/1.1
+	IDPrefix                 string                                                     // if set, identify the transactions with this followed by a sequence number, eg "worker3-17", instead of the address of the request
+	IDStart                  int64                                                      // the sequence number of the first transaction for IDPrefix, eg time.Now().Unix() so restarts don't reuse ids - defaults to 1
+	OperationFunc            func(req *http.Request) string                             // if set, returns the name of the API operation of req, eg "GetObject", to show in the titles
+	OnEvent                  func(Event)                                                // if set, called with an Event for each request and response whatever the Flags
+	Writer                   io.Writer                                                  // if set, write the dumped transactions here, one line per log, instead of to Logf or LogfCtx
+	Gzip                     bool                                                       // if set, gzip the output to Writer (not Logf) - Close the Transport to finish the stream
+	Format                   Format                                                     // how to format the dumped transactions - defaults to FormatRaw
+	FormatBodies             bool                                                       // if set, reformat dumped bodies according to their Content-Type using BodyFormatters
+	BodyFormatters           map[string]BodyFormatter                                   // formatters for FormatBodies - defaults to BodyFormatters if nil
+	ProtoResolver            ProtoResolver                                              // if set, used by FormatBodies to show protobuf bodies as text if it can resolve their message type
+	DumpQuotedBodies         bool                                                       // if set, show dumped bodies as quoted Go string literals, eg to paste into tests
+	DecodeBase64Bodies       bool                                                       // if set, show the decoded body after any dumped body which is entirely base64
+	DumpCertChain            bool                                                       // if set, show a one line summary of each TLS peer certificate in the response
+	RedactFunc               func(name, value string) (string, bool)                    // if set, called with the canonical name and value of each header - return the new value and true to replace it
+	Redactors                []Redactor                                                 // extra Redactors to run in order on each dump after the Auth, Set-Cookie and URL headers have been redacted
+	ShowSetCookie            bool                                                       // if set, show the cookie values in Set-Cookie response headers which are redacted unless DumpAuth is set
+	TeeRequestBody           bool                                                       // if set, capture request bodies which can't be replayed as they are sent instead of buffering them, logging the request after the round trip
+	FoldHeaders              bool                                                       // if set, combine headers with the same name onto one line, except Set-Cookie
+	CollapseRepeatedHeaders  bool                                                       // if set, show runs of identical header lines once with a count, eg "Via: 1.1 cdn (x5)"
+	AlignHeaders             bool                                                       // if set, pad the header names in each dump so the values all start in the same column
+	RegzipBodies             bool                                                       // if set, show the gzipped size of dumped response bodies which net/http decompressed, as an estimate of their size on the wire
+	IncludeJSONFields        []string                                                   // if set, reduce dumped JSON bodies to just these fields given as dotted paths, eg "error.message", showing the others as "..."
+	NoRequest                bool                                                       // if set, don't log the request blocks, only the response blocks
+	NoResponse               bool                                                       // if set, don't log the response blocks, only the request blocks
+	RedirectSummary          bool                                                       // if set, log the chain of redirects followed with the final response, eg "redirect chain: GET /a -> 302 -> GET /b -> 200"
+	HighlightRateLimit       bool                                                       // if set, add a line summarising any rate limit headers to the response, eg "rate-limit: remaining=0 reset=30s retry-after=30s"
+	ShowRemoteAddr           bool                                                       // if set, show the target host:port and the address actually connected to, eg a proxy, in the summary and compact lines
+	OncePerEndpoint          bool                                                       // if set, only dump the first round trip to each endpoint, logging how many times it has been seen the 10th, 100th, etc time
+	EndpointFunc             func(req *http.Request) string                             // if set, returns the endpoint of req for OncePerEndpoint - defaults to the method and the path without the query, eg "GET /v1/objects"
+	TransportConfig          func(t *http.Transport)                                    // if set, called by NewDefault and NewClient to tune the base transport before it is wrapped, eg to set MaxIdleConnsPerHost or DialContext
+	ShowInjected             bool                                                       // if set, mark each request header with "[set by caller]" or "[set by net/http]", eg for User-Agent or Accept-Encoding
+
+	// RedactFromEnv is the name of an environment variable, eg
+	// "DEBUGHTTP_REDACT", containing a comma separated list of extra
+	// header names to redact, eg "X-Internal-Token,X-Session". These
+	// are added to Auth (after it has been defaulted) so they are
+	// always redacted, even if Auth is empty. It is read once in New.
+	// If empty, no environment variable is read.
+	RedactFromEnv string
+
+	// ShouldLog, if set, is called after each round trip to decide
+	// whether to log it. If it returns false nothing is logged for
+	// the transaction. resp is nil if err is set.
+	//
+	// The request is logged after the round trip as it can't be
+	// logged until ShouldLog has been called.
+	ShouldLog func(req *http.Request, resp *http.Response, dur time.Duration, err error) bool
+
+	// BodyFilter, if set, is called with the request body and its
+	// Content-Type before each round trip to decide whether to log it,
+	// eg to pick out one GraphQL operation. If it returns false
+	// nothing is logged for the transaction.
+	//
+	// The request body is read to do this, so if it can't be replayed
+	// it is buffered in memory.
+	BodyFilter func(body []byte, contentType string) bool
+
+	// PostProcess, if set, is called with each request or response
+	// block, as selected by dir, just before it is logged, after all
+	// the redaction and formatting. The block has the separators, the
+	// title and the dump with its lines as they would have been logged
+	// joined with "\n". The block returned is logged instead as one
+	// line, or nothing at all is logged for it if it returns nil.
+	//
+	// It isn't called for the summary, compact or other one line logs.
+	PostProcess func(block []byte, dir Direction) []byte
+
+	// ActiveFrom and ActiveUntil, if not zero, limit the logging to
+	// this time window, eg for a scheduled debugging session. Outside
+	// it the requests are passed straight through. The window can be
+	// changed later with SetActiveWindow.
+	ActiveFrom  time.Time
+	ActiveUntil time.Time
+
+	// Verbosity is an alternative to setting Flags:
+	//
+	//	0: nothing
+	//	1: a one line summary of each transaction
+	//	2: headers
+	//	3: headers and bodies
+	//	4: everything, including timing, TLS and connection details
+	//
+	// The flags for the Verbosity level are ORed with Flags, so setting
+	// both adds the detail from each.
+	Verbosity int
+
+	// Sinks are extra destinations for the dumped transactions, each
+	// with its own Format and Flags. The transaction is only sent once
+	// and rendered to Logf (or Writer) and then to each of the Sinks.
+	// To use only the Sinks leave Flags and Verbosity unset.
+	//
+	// Sinks are not inherited by PerHost.
+	Sinks []Sink
+
+	// PerHost overrides these Options for requests to particular
+	// hosts. It is looked up first by req.URL.Host (eg
+	// "example.com:8080") then by the host name without the port (eg
+	// "example.com").
+	//
+	// The Flags of the per host Options are used as is, so leaving
+	// them as 0 silences that host. If Auth, RedactFromEnv,
+	// PIIPatterns, Redactors, RedactFunc, RedactQueryPatterns,
+	// RedactLinePatterns, BodyFormatters or ProtoResolver are not set
+	// in the per host Options they are inherited from these Options.
+	// If none of Logf, LogfCtx, LeveledLogger or Writer are set the
+	// host shares our Logf, LogfCtx, LeveledLogger and Writer output,
+	// otherwise it uses only its own. If IDPrefix isn't set the host
+	// shares our IDPrefix and sequence numbers. If RedactMapping is
+	// set in both the host shares our aliases.
+	PerHost map[string]Options
 }
 
 // Default options if nil is passed in to New or NewDefault or NewClient
@@ -140,38 +414,375 @@ var DumpBodyOptions = Options{
 }
 
 // Auth is the headers which we redact if DumpAuth is not set in Options
+//
+// The header names are matched case insensitively.
 var Auth = [][]byte{
 	[]byte("Authorization: "),
+	[]byte("Proxy-Authorization: "),
 	[]byte("X-Auth-Token: "),
 }
 
 // Transport wraps an *http.Transport and logs requests and responses
 //
-// Create one with New, NewDefault or NewClient - don't use directly
+// Create one with New, NewDefault, NewRoundTripper or NewClient -
+// don't use directly
 type Transport struct {
+	stats *Stats // use atomic - shared with any copies made by withFlags
 	*http.Transport
-	opt Options
+	next      http.RoundTripper // where the requests are sent - the *http.Transport unless made by NewRoundTripper
+	opt       Options
+	perHost   map[string]*Transport // Transports to use for hosts in opt.PerHost
+	out       *writerOutput         // output to opt.Writer if set
+	sinks     []*Transport          // Transports to render opt.Sinks
+	redactors []Redactor            // the Auth, Set-Cookie, URL, RedactFunc and RedactLinePatterns redactors followed by opt.Redactors
+	dumpSem   chan struct{}         // limits the transactions dumping bodies if opt.MaxConcurrentDumps is set
+	budget    *bodyBudget           // the bytes of bodies which may be held for dumping if opt.TotalBodyBudget is set
+	endpoints *endpointSet          // the endpoints seen if opt.OncePerEndpoint is set
+	now       func() time.Time      // returns the current time - time.Now unless changed with SetClock
+	seq       *int64                // the last sequence number used if opt.IDPrefix is set - use atomic
+	window    *activeWindow         // the time window to log in
+	aliases   *aliasMap             // the aliases of the redacted values if opt.RedactMapping is set
+	closers   []io.Closer           // things to close in reverse order on Close
+	closeOnce sync.Once             // make sure we only Close once
 }
 
 // New wraps the http.Transport passed in and logs all
 // round trips according to the Flags in opt
+//
+// If DumpWire is set in the Flags (or the Flags of one of the Sinks)
+// then a clone of transport is used with its dialers wrapped to
+// capture the bytes on the connections. It makes the TLS connections
+// itself so only HTTP/1.1 is offered and resp.TLS isn't set. The
+// PerHost Transports share the clone so DumpWire must be set in the
+// top level Options for them to use it.
+//
+// If DumpConn is set anywhere in opt and transport has a Proxy then a
+// clone of transport is used too, with Proxy wrapped to record the
+// proxy chosen for each request.
 func New(opt *Options, transport *http.Transport) *Transport {
+	if opt == nil {
+		opt = &DefaultOptions
+	}
+	if transport != nil && wantsWire(opt) {
+		transport = wireTransport(transport)
+	}
+	if transport != nil && transport.Proxy != nil && wantsConn(opt) {
+		transport = proxyTransport(transport)
+	}
+	var next http.RoundTripper
+	if transport != nil {
+		next = transport
+	}
+	return newTransport(opt, transport, next)
+}
+
+// NewRoundTripper wraps the http.RoundTripper passed in and logs all
+// round trips according to the Flags in opt.
+//
+// If rt is an *http.Transport this is the same as New, otherwise the
+// embedded *http.Transport of the result is nil so only use the
+// methods of Transport on it.
+func NewRoundTripper(opt *Options, rt http.RoundTripper) *Transport {
+	if transport, ok := rt.(*http.Transport); ok {
+		return New(opt, transport)
+	}
+	return newTransport(opt, nil, rt)
+}
+
+// newTransport makes a Transport which sends the requests to next
+func newTransport(opt *Options, transport *http.Transport, next http.RoundTripper) *Transport {
 	if opt == nil {
 		opt = &DefaultOptions
 	}
 	t := &Transport{
+		stats:     new(Stats),
 		Transport: transport,
+		next:      next,
 		opt:       *opt,
+		now:       time.Now,
+		window:    newActiveWindow(opt.ActiveFrom, opt.ActiveUntil),
+	}
+	t.opt.Flags |= verbosityFlags(t.opt.Verbosity)
+	authDumpDenied := t.opt.Flags&DumpAuth != 0 && !t.opt.AllowAuthDump
+	if authDumpDenied {
+		t.opt.Flags &^= DumpAuth
 	}
 	if t.opt.Logf == nil {
 		t.opt.Logf = log.Printf
 	}
+	// Note that only a nil Auth gets the defaults - an empty non-nil
+	// Auth means redact nothing.
 	if t.opt.Auth == nil {
 		t.opt.Auth = Auth
 	}
+	if t.opt.RedactFromEnv != "" {
+		t.opt.Auth = addAuth(t.opt.Auth, os.Getenv(t.opt.RedactFromEnv))
+	}
+	if t.opt.PIIPatterns == nil {
+		t.opt.PIIPatterns = PIIPatterns
+	}
+	if t.opt.BodyFormatters == nil {
+		t.opt.BodyFormatters = BodyFormatters
+	}
+	t.redactors = append([]Redactor{authRedactor{t: t}, setCookieRedactor{t: t}, urlRedactor{t: t}, funcRedactor{t: t}, lineRedactor{t: t}}, t.opt.Redactors...)
+	if t.opt.MaxConcurrentDumps > 0 {
+		t.dumpSem = make(chan struct{}, t.opt.MaxConcurrentDumps)
+	}
+	if t.opt.TotalBodyBudget > 0 {
+		t.budget = newBodyBudget(t.opt.TotalBodyBudget)
+	}
+	if t.opt.OncePerEndpoint {
+		t.endpoints = newEndpointSet()
+	}
+	if t.opt.RedactMapping {
+		t.aliases = newAliasMap()
+	}
+	if t.opt.IDPrefix != "" {
+		seq := t.opt.IDStart - 1
+		if seq < 0 {
+			seq = 0
+		}
+		t.seq = &seq
+	}
+	if t.opt.Writer != nil {
+		t.out = newWriterOutput(t)
+	}
+	if authDumpDenied {
+		t.logfLevel(nil, levelWarn, "debughttp: DumpAuth ignored as AllowAuthDump isn't set")
+	}
+	for _, sink := range t.opt.Sinks {
+		s := newSink(t, sink, transport)
+		s.aliases = t.aliases
+		t.sinks = append(t.sinks, s)
+	}
+	if len(t.opt.PerHost) > 0 {
+		t.perHost = make(map[string]*Transport, len(t.opt.PerHost))
+		for host, hostOpt := range t.opt.PerHost {
+			hostOpt.PerHost = nil
+			// Share our output unless the host has its own
+			shareOutput := hostOpt.Logf == nil && hostOpt.LogfCtx == nil && hostOpt.LeveledLogger == nil && hostOpt.Writer == nil
+			if shareOutput {
+				hostOpt.Logf = t.opt.Logf
+				hostOpt.LogfCtx = t.opt.LogfCtx
+				hostOpt.LeveledLogger = t.opt.LeveledLogger
+			}
+			if hostOpt.Auth == nil {
+				hostOpt.Auth = t.opt.Auth
+			}
+			if hostOpt.PIIPatterns == nil {
+				hostOpt.PIIPatterns = t.opt.PIIPatterns
+			}
+			if hostOpt.Redactors == nil {
+				hostOpt.Redactors = t.opt.Redactors
+			}
+			if hostOpt.RedactFunc == nil {
+				hostOpt.RedactFunc = t.opt.RedactFunc
+			}
+			if hostOpt.RedactQueryPatterns == nil {
+				hostOpt.RedactQueryPatterns = t.opt.RedactQueryPatterns
+			}
+			if hostOpt.RedactLinePatterns == nil {
+				hostOpt.RedactLinePatterns = t.opt.RedactLinePatterns
+			}
+			if hostOpt.RedactFromEnv == "" {
+				hostOpt.RedactFromEnv = t.opt.RedactFromEnv
+			}
+			if hostOpt.BodyFormatters == nil {
+				hostOpt.BodyFormatters = t.opt.BodyFormatters
+			}
+			if hostOpt.ProtoResolver == nil {
+				hostOpt.ProtoResolver = t.opt.ProtoResolver
+			}
+			shareSeq := hostOpt.IDPrefix == ""
+			if shareSeq {
+				hostOpt.IDPrefix = t.opt.IDPrefix
+			}
+			t.perHost[host] = newTransport(&hostOpt, transport, next)
+			if shareOutput {
+				t.perHost[host].out = t.out
+			}
+			if shareSeq {
+				t.perHost[host].seq = t.seq
+			}
+			if hostOpt.RedactMapping && t.aliases != nil {
+				t.perHost[host].aliases = t.aliases
+			}
+		}
+	}
 	return t
 }
 
+// Close flushes any buffered output, stops any background goroutines
+// and closes any writers owned by the Transport.
+//
+// It is a no-op if no features which need it are in use and it is
+// safe to call more than once. The Transport shouldn't be used after
+// it has been closed.
+func (t *Transport) Close() (err error) {
+	t.closeOnce.Do(func() {
+		for _, host := range t.perHost {
+			if closeErr := host.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+		for _, sink := range t.sinks {
+			if closeErr := sink.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+		for i := len(t.closers) - 1; i >= 0; i-- {
+			if closeErr := t.closers[i].Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+	})
+	return err
+}
+
+// SetClock sets the function the Transport and its PerHost and Sinks
+// Transports use to get the current time, or time.Now if now is nil.
+//
+// It is intended for tests which need the timings and timestamps in
+// the dumps to be deterministic and must be called before the
+// Transport is used.
+func (t *Transport) SetClock(now func() time.Time) {
+	if now == nil {
+		now = time.Now
+	}
+	t.now = now
+	for _, host := range t.perHost {
+		host.SetClock(now)
+	}
+	for _, sink := range t.sinks {
+		sink.SetClock(now)
+	}
+}
+
+// Stats are counts of the round trips done by a Transport
+type Stats struct {
+	Requests    int64 // the number of round trips started
+	NewConns    int64 // the number of round trips which used a new connection
+	ReusedConns int64 // the number of round trips which reused an idle connection
+}
+
+// Stats returns the counts of the round trips done by the Transport
+// including those done by the PerHost Transports.
+//
+// Comparing NewConns and ReusedConns helps when tuning keep-alives
+// and MaxIdleConnsPerHost.
+func (t *Transport) Stats() Stats {
+	stats := Stats{
+		Requests:    atomic.LoadInt64(&t.stats.Requests),
+		NewConns:    atomic.LoadInt64(&t.stats.NewConns),
+		ReusedConns: atomic.LoadInt64(&t.stats.ReusedConns),
+	}
+	for _, host := range t.perHost {
+		hostStats := host.Stats()
+		stats.Requests += hostStats.Requests
+		stats.NewConns += hostStats.NewConns
+		stats.ReusedConns += hostStats.ReusedConns
+	}
+	return stats
+}
+
+// Options returns a copy of the Options in effect after New has
+// filled in the defaults.
+//
+// The slices and maps are copied so changing them won't affect the
+// Transport.
+func (t *Transport) Options() Options {
+	opt := t.opt
+	if opt.Auth != nil {
+		opt.Auth = make([][]byte, len(t.opt.Auth))
+		for i, authBuf := range t.opt.Auth {
+			opt.Auth[i] = append([]byte(nil), authBuf...)
+		}
+	}
+	if opt.PIIPatterns != nil {
+		opt.PIIPatterns = append([]PIIPattern(nil), t.opt.PIIPatterns...)
+	}
+	if opt.Redactors != nil {
+		opt.Redactors = append([]Redactor(nil), t.opt.Redactors...)
+	}
+	if opt.Sinks != nil {
+		opt.Sinks = append([]Sink(nil), t.opt.Sinks...)
+	}
+	if opt.IncludeJSONFields != nil {
+		opt.IncludeJSONFields = append([]string(nil), t.opt.IncludeJSONFields...)
+	}
+	if opt.RedactQueryPatterns != nil {
+		opt.RedactQueryPatterns = append([]*regexp.Regexp(nil), t.opt.RedactQueryPatterns...)
+	}
+	if opt.RedactLinePatterns != nil {
+		opt.RedactLinePatterns = append([]*regexp.Regexp(nil), t.opt.RedactLinePatterns...)
+	}
+	if opt.BodyFormatters != nil {
+		opt.BodyFormatters = make(map[string]BodyFormatter, len(t.opt.BodyFormatters))
+		for mediaType, formatter := range t.opt.BodyFormatters {
+			opt.BodyFormatters[mediaType] = formatter
+		}
+	}
+	if opt.PerHost != nil {
+		opt.PerHost = make(map[string]Options, len(t.opt.PerHost))
+		for host, hostOpt := range t.opt.PerHost {
+			opt.PerHost[host] = hostOpt
+		}
+	}
+	return opt
+}
+
+// CloseIdleConnections closes any idle connections of the wrapped
+// http.RoundTripper if it supports it.
+func (t *Transport) CloseIdleConnections() {
+	if closer, ok := t.next.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+// CloseClient closes the Transport of client if it is a *Transport,
+// eg one made by NewClient, as http.Client doesn't expose it.
+func CloseClient(client *http.Client) error {
+	if t, ok := client.Transport.(*Transport); ok {
+		return t.Close()
+	}
+	return nil
+}
+
+// addAuth returns a copy of auth with the comma separated header
+// names in names added, skipping any which are already present.
+func addAuth(auth [][]byte, names string) [][]byte {
+	newAuth := append([][]byte{}, auth...)
+	seen := make(map[string]struct{}, len(auth))
+	for _, authBuf := range auth {
+		seen[headerName(authBuf)] = struct{}{}
+	}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		canonical := headerName([]byte(name))
+		if _, found := seen[canonical]; found {
+			continue
+		}
+		seen[canonical] = struct{}{}
+		newAuth = append(newAuth, []byte(canonical+": "))
+	}
+	return newAuth
+}
+
+// hostTransport returns the Transport configured in PerHost for the
+// host of req or nil if there isn't one
+func (t *Transport) hostTransport(req *http.Request) *Transport {
+	if t.perHost == nil || req.URL == nil {
+		return nil
+	}
+	if host, ok := t.perHost[req.URL.Host]; ok {
+		return host
+	}
+	return t.perHost[req.URL.Hostname()]
+}
+
 // setDefaults for a from b
 //
 // Copy the public members from b to a.  We can't just use a struct
@@ -193,14 +804,18 @@ func setDefaults(a, b interface{}) {
 
 // NewDefault returns an http.RoundTripper based off
 // http.DefaultTransport which will log the HTTP transactions as
-// directed in opt
+// directed in opt. If opt is nil then DefaultOptions is used.
 //
-// If opt is nil then DefaultOptions is used
+// If TransportConfig is set in opt it is called with the new
+// http.Transport before it is wrapped.
 func NewDefault(opt *Options) *Transport {
 	// Start with a sensible set of defaults then override.
 	// This also means we get new stuff when it gets added to go
 	t := new(http.Transport)
 	setDefaults(t, http.DefaultTransport.(*http.Transport))
+	if opt != nil && opt.TransportConfig != nil {
+		opt.TransportConfig(t)
+	}
 
 	// Wrap that http.Transport in our own transport
 	return New(opt, t)
@@ -215,78 +830,1652 @@ func NewClient(opt *Options) *http.Client {
 	return client
 }
 
-// cleanAuth gets rid of one authBuf header within the first 4k
-func cleanAuth(buf, authBuf []byte) []byte {
+// WrapClient returns a copy of client with its Transport wrapped to
+// log the HTTP transactions as directed in opt. The other settings
+// of client, eg Jar, Timeout and CheckRedirect, are kept.
+//
+// If client.Transport is nil then http.DefaultTransport is wrapped as
+// that is what client would have used. If client is nil this is the
+// same as NewClient.
+func WrapClient(opt *Options, client *http.Client) *http.Client {
+	if client == nil {
+		return NewClient(opt)
+	}
+	wrapped := *client
+	rt := client.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	wrapped.Transport = NewRoundTripper(opt, rt)
+	return &wrapped
+}
+
+// headerName returns the canonical header name from an Auth entry
+// such as "Authorization: " or "x-auth-token"
+func headerName(authBuf []byte) string {
+	return textproto.CanonicalMIMEHeaderKey(strings.TrimRight(string(authBuf), ": \t"))
+}
+
+// rewriteHeaders calls rewrite on the value of each header in the
+// header section of the dump in buf (within the first 4k) whose name
+// matches authBuf, replacing the value with the result.
+//
+// Header names are compared in canonical form so the case of authBuf
+// and the dump don't matter.
+//
+// Only the value is replaced - any line ending and everything after it
+// is kept, and a last line with no newline is rewritten too.
+func rewriteHeaders(buf, authBuf []byte, rewrite func(value []byte) []byte) []byte {
+	name := headerName(authBuf)
+	return rewriteHeaderValues(buf, func(key string, value []byte) ([]byte, bool) {
+		if key != name {
+			return nil, false
+		}
+		return rewrite(value), true
+	})
+}
+
+// rewriteHeaderValues calls rewrite with the canonical name and the
+// value of each header in the dump in buf (within the first 4k),
+// replacing the value with the one returned if rewrite returns true.
+//
+// Continuation lines of a folded value (obs-fold) are part of the
+// value so they are replaced with it.
+func rewriteHeaderValues(buf []byte, rewrite func(name string, value []byte) ([]byte, bool)) []byte {
 	// Find how much buffer to check
 	n := 4096
 	if len(buf) < n {
 		n = len(buf)
 	}
-	// See if there is an Authorization: header
-	i := bytes.Index(buf[:n], authBuf)
-	if i < 0 {
+	var out []byte
+	copied := 0 // how much of buf has been copied to out
+	for start := 0; start < n; {
+		end := bytes.IndexByte(buf[start:], '\n')
+		if end < 0 {
+			end = len(buf)
+		} else {
+			end += start
+		}
+		line := bytes.TrimRight(buf[start:end], "\r")
+		if len(line) == 0 {
+			// end of the headers
+			break
+		}
+		// Header names can't contain spaces which skips the request
+		// and status lines
+		colon := bytes.IndexByte(line, ':')
+		if colon > 0 && bytes.IndexAny(line[:colon], " \t") < 0 {
+			valueStart := colon + 1
+			for valueStart < len(line) && (line[valueStart] == ' ' || line[valueStart] == '\t') {
+				valueStart++
+			}
+			valueStart += start
+			valueEnd := start + len(line)
+			// Include any obs-fold continuation lines, which start
+			// with a space or tab, in the value
+			for end+1 < len(buf) && (buf[end+1] == ' ' || buf[end+1] == '\t') {
+				next := end + 1
+				end = bytes.IndexByte(buf[next:], '\n')
+				if end < 0 {
+					end = len(buf)
+				} else {
+					end += next
+				}
+				valueEnd = next + len(bytes.TrimRight(buf[next:end], "\r"))
+			}
+			if value, ok := rewrite(textproto.CanonicalMIMEHeaderKey(string(line[:colon])), buf[valueStart:valueEnd]); ok {
+				out = append(out, buf[copied:valueStart]...)
+				out = append(out, value...)
+				copied = valueEnd
+			}
+		}
+		start = end + 1
+	}
+	if out == nil {
 		return buf
 	}
-	i += len(authBuf)
-	// Overwrite the next 4 chars with 'X'
-	for j := 0; i < len(buf) && j < 4; j++ {
-		if buf[i] == '\n' {
-			break
+	return append(out, buf[copied:]...)
+}
+
+// maskValue replaces the first 4 chars of value with 'X' and drops
+// the rest
+func maskValue(value []byte) []byte {
+	n := len(value)
+	if n > 4 {
+		n = 4
+	}
+	return bytes.Repeat([]byte("X"), n)
+}
+
+// lengthValue replaces value with a note of its length
+func lengthValue(value []byte) []byte {
+	return []byte(fmt.Sprintf("[REDACTED %d chars]", len(value)))
+}
+
+// presentValue replaces value with a note of whether it was set and
+// its length
+func presentValue(value []byte) []byte {
+	switch len(value) {
+	case 0:
+		return []byte(absentNote)
+	case 1:
+		return []byte("[present, 1 char]")
+	}
+	return []byte(fmt.Sprintf("[present, %d chars]", len(value)))
+}
+
+// absentNote marks an Auth header which is empty or missing with
+// MarkAuthPresent
+const absentNote = "[absent]"
+
+// markAuthAbsent adds a line to the request dump in buf marking the
+// first of the Auth headers as absent if none of them are in it
+func (t *Transport) markAuthAbsent(buf []byte) []byte {
+	if len(t.opt.Auth) == 0 {
+		return buf
+	}
+	d, ok := splitDump(buf)
+	if !ok {
+		return buf
+	}
+	for _, line := range d.headers {
+		name, _, ok := splitHeader(line)
+		if !ok {
+			continue
 		}
-		buf[i] = 'X'
-		i++
+		for _, authBuf := range t.opt.Auth {
+			if name == headerName(authBuf) {
+				return buf
+			}
+		}
+	}
+	d.headers = append(d.headers, []byte(headerName(t.opt.Auth[0])+": "+absentNote))
+	return d.join()
+}
+
+// maskFunc returns the function to redact the values of the Auth
+// headers according to the Options
+func (t *Transport) maskFunc() func(value []byte) []byte {
+	if t.opt.MarkAuthPresent {
+		return presentValue
+	}
+	if t.opt.RedactShowLength {
+		return lengthValue
 	}
-	// Snip out to the next '\n'
-	j := bytes.IndexByte(buf[i:], '\n')
-	if j < 0 {
-		return buf[:i]
+	if t.aliases != nil {
+		return t.aliases.alias
 	}
-	n = copy(buf[i:], buf[i+j:])
-	return buf[:i+n]
+	return maskValue
+}
+
+// cleanAuth gets rid of the values of the authBuf headers within the
+// first 4k
+func cleanAuth(buf, authBuf []byte) []byte {
+	return rewriteHeaders(buf, authBuf, maskValue)
 }
 
 // cleanAuths gets rid of all the possible Auth headers
 func (t *Transport) cleanAuths(buf []byte) []byte {
+	return t.cleanAuthsAudited(buf, nil)
+}
+
+// cleanAuthsAudited is cleanAuths recording the headers redacted in
+// audit
+func (t *Transport) cleanAuthsAudited(buf []byte, audit *redactionAudit) []byte {
+	mask := t.maskFunc()
 	for _, authBuf := range t.opt.Auth {
-		buf = cleanAuth(buf, authBuf)
+		name := headerName(authBuf)
+		buf = rewriteHeaders(buf, authBuf, func(value []byte) []byte {
+			audit.header(name, value)
+			return mask(value)
+		})
 	}
 	return buf
 }
 
-// RoundTrip implements the RoundTripper interface.
-func (t *Transport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
-	// Logf request
-	if t.opt.Flags&(DumpHeaders|DumpBodies|DumpAuth|DumpRequests|DumpResponses) != 0 {
-		t.opt.Logf("%s", SeparatorReq)
-		t.opt.Logf("%s (req %p)", "HTTP REQUEST", req)
-		buf, derr := httputil.DumpRequestOut(req, t.opt.Flags&(DumpBodies|DumpRequests) != 0)
-		if derr != nil {
-			t.opt.Logf("Dump request failed: %v", derr)
-		} else {
-			if t.opt.Flags&DumpAuth == 0 {
-				buf = t.cleanAuths(buf)
+// packageDir is the directory containing the source of this package
+var packageDir = func() string {
+	_, file, _, _ := runtime.Caller(0)
+	return path.Dir(file)
+}()
+
+// isInternalFrame returns true if frame is in net/http or in the non
+// test code of this package
+func isInternalFrame(frame runtime.Frame) bool {
+	if strings.HasPrefix(frame.Function, "net/http.") {
+		return true
+	}
+	return path.Dir(frame.File) == packageDir && !strings.HasSuffix(frame.File, "_test.go")
+}
+
+// caller returns the "dir/file.go:line" of the code which made the
+// request.
+//
+// It walks up the stack past net/http and this package to find the
+// first application frame then skips skip more frames.
+func caller(skip int) string {
+	pc := make([]uintptr, 64)
+	n := runtime.Callers(2, pc)
+	frames := runtime.CallersFrames(pc[:n])
+	for {
+		frame, more := frames.Next()
+		if !isInternalFrame(frame) {
+			if skip <= 0 {
+				return fmt.Sprintf("%s/%s:%d", path.Base(path.Dir(frame.File)), path.Base(frame.File), frame.Line)
 			}
-			t.opt.Logf("%s", string(buf))
+			skip--
 		}
-		t.opt.Logf("%s", SeparatorReq)
+		if !more {
+			break
+		}
+	}
+	return "unknown"
+}
+
+// Logger is a leveled logger which can be set as LeveledLogger in
+// Options so the dumps can be routed by level.
+//
+// The SugaredLogger from go.uber.org/zap and the Logger and Entry from
+// github.com/sirupsen/logrus can be used directly, and SlogLogger
+// adapts a *slog.Logger.
+type Logger interface {
+	Debugf(format string, v ...interface{})
+	Warnf(format string, v ...interface{})
+	Errorf(format string, v ...interface{})
+}
+
+// level is the level of a log line for a LeveledLogger
+type level int
+
+// level definitions
+const (
+	levelDebug level = iota // the dumps
+	levelWarn               // problems dumping the transaction and error responses
+	levelError              // failed round trips
+)
+
+// isErrorStatus returns true if a response with the status code is an
+// error according to ErrorStatusFunc
+func (t *Transport) isErrorStatus(code int) bool {
+	if t.opt.ErrorStatusFunc != nil {
+		return t.opt.ErrorStatusFunc(code)
+	}
+	return code >= http.StatusBadRequest
+}
+
+// statusLevel returns the level to log the one line logs of resp at
+func (t *Transport) statusLevel(resp *http.Response) level {
+	if t.isErrorStatus(resp.StatusCode) {
+		return levelWarn
+	}
+	return levelDebug
+}
+
+// logf logs a line of the dumps at levelDebug
+func (t *Transport) logf(req *http.Request, format string, v ...interface{}) {
+	t.logfLevel(req, levelDebug, format, v...)
+}
+
+// logfLevel logs to the Writer if set, or using LeveledLogger at lvl
+// if set, or using LogfCtx with the context of req if set, or Logf
+// otherwise
+func (t *Transport) logfLevel(req *http.Request, lvl level, format string, v ...interface{}) {
+	if t.out != nil {
+		t.out.printf(format, v...)
+		return
+	}
+	if logger := t.opt.LeveledLogger; logger != nil {
+		switch lvl {
+		case levelError:
+			logger.Errorf(format, v...)
+		case levelWarn:
+			logger.Warnf(format, v...)
+		default:
+			logger.Debugf(format, v...)
+		}
+		return
+	}
+	if t.opt.LogfCtx != nil {
+		ctx := context.Background()
+		if req != nil {
+			ctx = req.Context()
+		}
+		t.opt.LogfCtx(ctx, format, v...)
+		return
+	}
+	t.opt.Logf(format, v...)
+}
+
+// separator returns the separator line for req in direction dir
+func (t *Transport) separator(req *http.Request, dir Direction) string {
+	if t.opt.SeparatorFunc != nil {
+		return t.opt.SeparatorFunc(req, dir)
+	}
+	if dir == DirectionRequest {
+		return SeparatorReq
+	}
+	return SeparatorResp
+}
+
+// requestBody returns the body of req without disturbing the body
+// which is handed to the underlying transport.
+//
+// Reading the body consumes it, so use req.GetBody to get a fresh
+// copy of the body if possible, or rewind the body if it is seekable.
+// Only if neither of these is possible is the body buffered in memory
+// and req.Body replaced with the buffered copy.
+func (t *Transport) requestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
 	}
-	// Do round trip
-	resp, err = t.Transport.RoundTrip(req)
-	// Logf response
-	if t.opt.Flags&(DumpHeaders|DumpBodies|DumpAuth|DumpRequests|DumpResponses) != 0 {
-		t.opt.Logf("%s", SeparatorResp)
-		t.opt.Logf("%s (req %p)", "HTTP RESPONSE", req)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
 		if err != nil {
-			t.opt.Logf("HTTP request failed: %v", err)
-		} else {
-			buf, derr := httputil.DumpResponse(resp, t.opt.Flags&(DumpBodies|DumpResponses) != 0)
-			if derr != nil {
-				t.opt.Logf("Dump response failed: %v", derr)
-			} else {
-				t.opt.Logf("%s", string(buf))
+			return nil, err
+		}
+		defer func() {
+			_ = body.Close()
+		}()
+		return ioutil.ReadAll(body)
+	}
+	if seeker, ok := req.Body.(io.Seeker); ok {
+		pos, err := seeker.Seek(0, io.SeekCurrent)
+		if err == nil {
+			buf, err := ioutil.ReadAll(req.Body)
+			if _, seekErr := seeker.Seek(pos, io.SeekStart); seekErr != nil {
+				return nil, seekErr
+			}
+			return buf, err
+		}
+	}
+	t.logfLevel(req, levelWarn, "Warning: request body can't be replayed so buffering it in memory to dump it")
+	buf, err := ioutil.ReadAll(req.Body)
+	_ = req.Body.Close()
+	// This can be seeked so it is only buffered once
+	req.Body = bufferedBody{bytes.NewReader(buf)}
+	return buf, err
+}
+
+// Notes shown in the request dumps in place of the body
+const (
+	noBodyNote      = "(no body)"       // the body is nil or http.NoBody
+	emptyBodyNote   = "(empty body)"    // the body is present but empty
+	notSentBodyNote = "(body not sent)" // the captured body was never read by the transport
+)
+
+// requestBodyNote returns a note to show in place of the request body
+// of tx if it is missing or empty or "" otherwise. A missing body is
+// never read.
+func (t *Transport) requestBodyNote(tx *transaction) string {
+	req := tx.req
+	if req.Body == nil || req.Body == http.NoBody {
+		return noBodyNote
+	}
+	if body, err := t.txRequestBody(tx); err == nil && len(body) == 0 {
+		if tx.tee != nil {
+			if tx.tee.unread() {
+				return notSentBodyNote
 			}
+			if _, n, done := tx.tee.captured(); n > 0 || !done {
+				return ""
+			}
+		}
+		return emptyBodyNote
+	}
+	return ""
+}
+
+// dumpRequest dumps the request of tx, including the body if body is
+// set, without disturbing the body which is handed to the underlying
+// transport.
+func (t *Transport) dumpRequest(tx *transaction, body bool) ([]byte, error) {
+	req := tx.req
+	if !body || req.Body == nil || req.Body == http.NoBody {
+		return dumpRequestHeaders(req)
+	}
+	if tx.tee != nil {
+		return dumpTeeRequest(tx)
+	}
+	buf, err := t.txRequestBody(tx)
+	if err != nil {
+		return nil, err
+	}
+	reqCopy := *req
+	reqCopy.Body = ioutil.NopCloser(bytes.NewReader(buf))
+	return httputil.DumpRequestOut(&reqCopy, true)
+}
+
+// dumpTeeRequest dumps the request of tx with the body captured as it
+// was sent, noting any which wasn't captured or hasn't been sent yet.
+func dumpTeeRequest(tx *transaction) ([]byte, error) {
+	reqCopy := *tx.req
+	reqCopy.Body = ioutil.NopCloser(bytes.NewReader(nil))
+	buf, err := httputil.DumpRequestOut(&reqCopy, false)
+	if err != nil {
+		return nil, err
+	}
+	body, n, done := tx.tee.captured()
+	buf = append(buf, body...)
+	if n > int64(len(body)) {
+		buf = append(buf, fmt.Sprintf("\n... [%d bytes truncated]\n", n-int64(len(body)))...)
+	}
+	if !done && !tx.tee.unread() {
+		buf = append(buf, "\n... [request body not completely sent]\n"...)
+	}
+	return buf, nil
+}
+
+// expectsContinue returns true if req has a body which the transport
+// will only send once the server has replied "100 Continue"
+func expectsContinue(req *http.Request) bool {
+	if req.Body == nil || req.Body == http.NoBody {
+		return false
+	}
+	for _, value := range req.Header.Values("Expect") {
+		if strings.EqualFold(strings.TrimSpace(value), "100-continue") {
+			return true
 		}
-		t.opt.Logf("%s", SeparatorResp)
 	}
-	return resp, err
+	return false
+}
+
+// replayable returns true if the body of req can be read for dumping
+// without buffering it.
+func replayable(req *http.Request) bool {
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
+		return true
+	}
+	_, ok := req.Body.(io.Seeker)
+	return ok
+}
+
+// truncateBody truncates the body in the dump in buf to max bytes,
+// noting how many bytes were removed. If max <= 0 it does nothing.
+func truncateBody(buf []byte, max int64) []byte {
+	if max <= 0 {
+		return buf
+	}
+	i := bytes.Index(buf, []byte("\r\n\r\n"))
+	if i < 0 {
+		return buf
+	}
+	i += 4
+	bodySize := int64(len(buf) - i)
+	if bodySize <= max {
+		return buf
+	}
+	buf = buf[:i+int(max)]
+	return append(buf, fmt.Sprintf("\n... [%d bytes truncated]\n", bodySize-max)...)
+}
+
+// limitHeaders truncates the header section of the dump in buf to
+// max header lines, noting how many were removed. The request or
+// status line and the body are always kept. If max <= 0 it does
+// nothing.
+func limitHeaders(buf []byte, max int) []byte {
+	if max <= 0 {
+		return buf
+	}
+	// Skip the request or status line
+	start := bytes.IndexByte(buf, '\n')
+	if start < 0 {
+		return buf
+	}
+	start++
+	// Find the end of the max'th header and the end of the headers
+	cut, headers := -1, 0
+	end := start
+	for end < len(buf) {
+		next := bytes.IndexByte(buf[end:], '\n')
+		if next < 0 {
+			next = len(buf)
+		} else {
+			next += end + 1
+		}
+		if len(bytes.TrimRight(buf[end:next], "\r\n")) == 0 {
+			break
+		}
+		headers++
+		if headers == max {
+			cut = next
+		}
+		end = next
+	}
+	if headers <= max {
+		return buf
+	}
+	out := make([]byte, 0, cut+len(buf)-end+32)
+	out = append(out, buf[:cut]...)
+	out = append(out, fmt.Sprintf("... (%d more headers)\r\n", headers-max)...)
+	return append(out, buf[end:]...)
+}
+
+// firstLine returns the first line of the dump in buf without the
+// line ending
+func firstLine(buf []byte) []byte {
+	if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+		buf = buf[:i]
+	}
+	return bytes.TrimRight(buf, "\r")
+}
+
+// jwtHeader is the part of a JWT header which we show
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+}
+
+// formatJWT returns token with the payload and signature masked if
+// it looks like a JWT, showing only the alg and typ from its header.
+//
+// ok is false if token doesn't look like a JWT.
+func formatJWT(token []byte) (out []byte, ok bool) {
+	parts := bytes.Split(token, []byte("."))
+	if len(parts) != 3 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return nil, false
+	}
+	var header []byte
+	for i, part := range parts {
+		decoded, err := base64.RawURLEncoding.DecodeString(string(part))
+		if err != nil {
+			return nil, false
+		}
+		if i == 0 {
+			header = decoded
+		}
+	}
+	var h jwtHeader
+	if err := json.Unmarshal(header, &h); err != nil || h.Alg == "" {
+		return nil, false
+	}
+	out = append(out, "{alg:"...)
+	out = append(out, h.Alg...)
+	if h.Typ != "" {
+		out = append(out, ",typ:"...)
+		out = append(out, h.Typ...)
+	}
+	out = append(out, "}.XXXX.XXXX"...)
+	return out, true
+}
+
+// maskJWTs masks the payload and signature of any space separated
+// JWTs in value
+func maskJWTs(value []byte) []byte {
+	words := bytes.Split(value, []byte(" "))
+	for k, word := range words {
+		if masked, ok := formatJWT(word); ok {
+			words[k] = masked
+		}
+	}
+	return bytes.Join(words, []byte(" "))
+}
+
+// cleanJWT masks the payload and signature of any JWTs in the authBuf
+// headers within the first 4k
+func cleanJWT(buf, authBuf []byte) []byte {
+	return rewriteHeaders(buf, authBuf, maskJWTs)
+}
+
+// cleanJWTs masks the JWTs in all the possible Auth headers
+func (t *Transport) cleanJWTs(buf []byte) []byte {
+	return t.cleanJWTsAudited(buf, nil)
+}
+
+// cleanJWTsAudited is cleanJWTs recording the headers with JWTs masked
+// in audit as eg "Authorization(jwt)"
+func (t *Transport) cleanJWTsAudited(buf []byte, audit *redactionAudit) []byte {
+	for _, authBuf := range t.opt.Auth {
+		name := headerName(authBuf)
+		buf = rewriteHeaders(buf, authBuf, func(value []byte) []byte {
+			masked := maskJWTs(value)
+			if !bytes.Equal(masked, value) {
+				audit.add(name + "(jwt)")
+			}
+			return masked
+		})
+	}
+	return buf
+}
+
+// tlsVersions maps TLS versions to names
+var tlsVersions = map[uint16]string{
+	tls.VersionTLS10: "1.0",
+	tls.VersionTLS11: "1.1",
+	tls.VersionTLS12: "1.2",
+	tls.VersionTLS13: "1.3",
+}
+
+// formatTLS returns a one line description of the TLS connection state
+func formatTLS(state *tls.ConnectionState) string {
+	version, ok := tlsVersions[state.Version]
+	if !ok {
+		version = fmt.Sprintf("0x%04x", state.Version)
+	}
+	return fmt.Sprintf("TLS: version=%s cipher=%s server=%s alpn=%s resumed=%v",
+		version, tls.CipherSuiteName(state.CipherSuite), state.ServerName, state.NegotiatedProtocol, state.DidResume)
+}
+
+// formatCert returns a one line summary of the i-th certificate in
+// the chain
+func formatCert(i int, cert *x509.Certificate) string {
+	var sans []string
+	sans = append(sans, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	return fmt.Sprintf("cert[%d]: subject=%q issuer=%q sans=[%s] not_after=%s",
+		i, cert.Subject.String(), cert.Issuer.String(), strings.Join(sans, " "), cert.NotAfter.UTC().Format(time.RFC3339))
+}
+
+// connInfo records details about the connection used for a request
+type connInfo struct {
+	local        net.Addr
+	remote       net.Addr
+	conn         net.Conn
+	reused       bool          // whether the connection had been used before
+	idleTime     time.Duration // how long it was idle in the pool if reused
+	gotFirstByte time.Time     // when the first byte of the response arrived
+
+	proxyResolved bool     // set once the Proxy of the *http.Transport has been called if it is recording
+	proxy         *url.URL // the proxy it chose or nil for none
+	proxyErr      error    // the error it returned
+}
+
+// connInfoKey is the context key for the connInfo of a request
+type connInfoKey struct{}
+
+// withConnTrace returns a copy of req which fills in info when it
+// gets a connection. If now is set it is used to record when the first
+// byte of the response arrived too.
+func withConnTrace(req *http.Request, info *connInfo, now func() time.Time) *http.Request {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(connInfo httptrace.GotConnInfo) {
+			info.local = connInfo.Conn.LocalAddr()
+			info.remote = connInfo.Conn.RemoteAddr()
+			info.conn = connInfo.Conn
+			info.reused = connInfo.Reused
+			info.idleTime = connInfo.IdleTime
+			if conn, ok := connInfo.Conn.(*wireConn); ok {
+				conn.reset()
+			}
+		},
+	}
+	if now != nil {
+		trace.GotFirstResponseByte = func() {
+			info.gotFirstByte = now()
+		}
+	}
+	ctx := context.WithValue(req.Context(), connInfoKey{}, info)
+	return req.WithContext(httptrace.WithClientTrace(ctx, trace))
+}
+
+// attemptKey is the context key for WithAttempt
+type attemptKey struct{}
+
+// WithAttempt returns a copy of ctx which marks requests made with it
+// as attempt number attempt of a logical request, eg by a retry loop.
+//
+// The attempt number is shown in the titles of the request and
+// response blocks.
+func WithAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, attemptKey{}, attempt)
+}
+
+// AttemptFromContext returns the attempt number set by WithAttempt or
+// 0 if none was set.
+func AttemptFromContext(ctx context.Context) int {
+	attempt, _ := ctx.Value(attemptKey{}).(int)
+	return attempt
+}
+
+// attempt returns the attempt number for req or 0 if unknown
+func (t *Transport) attempt(req *http.Request) int {
+	if t.opt.AttemptFunc != nil {
+		return t.opt.AttemptFunc(req)
+	}
+	return AttemptFromContext(req.Context())
+}
+
+// flagsKey is the context key for WithFlags
+type flagsKey struct{}
+
+// WithFlags returns a copy of ctx which adds flags to the Flags of the
+// Transport for requests made with it.
+//
+// This can be used to dump a single request in full, eg one picked
+// out by its trace id, from a Transport which otherwise logs nothing.
+// DumpWire only works if it is set in the Options as well.
+func WithFlags(ctx context.Context, flags DumpFlags) context.Context {
+	return context.WithValue(ctx, flagsKey{}, flags)
+}
+
+// FlagsFromContext returns the flags set by WithFlags or 0 if none
+// were set.
+func FlagsFromContext(ctx context.Context) DumpFlags {
+	flags, _ := ctx.Value(flagsKey{}).(DumpFlags)
+	return flags
+}
+
+// withFlags returns a copy of t which logs with flags instead of our
+// Flags.
+//
+// The copy shares our output and Stats.
+func (t *Transport) withFlags(flags DumpFlags) *Transport {
+	c := &Transport{
+		stats:     t.stats,
+		Transport: t.Transport,
+		next:      t.next,
+		opt:       t.opt,
+		out:       t.out,
+		sinks:     t.sinks,
+		dumpSem:   t.dumpSem,
+		budget:    t.budget,
+		endpoints: t.endpoints,
+		now:       t.now,
+		seq:       t.seq,
+		window:    t.window,
+		aliases:   t.aliases,
+	}
+	c.opt.Flags = flags
+	c.redactors = append([]Redactor{authRedactor{t: c}, setCookieRedactor{t: c}, urlRedactor{t: c}, funcRedactor{t: c}, lineRedactor{t: c}}, t.opt.Redactors...)
+	return c
+}
+
+// transaction is the state of one round trip while it is logged
+type transaction struct {
+	req       *http.Request
+	ref       string // identifies the transaction in the logs
+	reqTitle  string
+	respTitle string
+	isConnect bool // CONNECT requests tunnel so their bodies mustn't be dumped
+	attempt   int  // attempt number of a logical request or 0 if unknown
+	resp      *http.Response
+	err       error
+	start     time.Time // when the round trip started
+	duration  time.Duration
+	conn      *connInfo // separate so the connection trace in the request doesn't keep the transaction alive
+
+	reqBody     []byte   // the request body once read by txRequestBody
+	reqBodyErr  error    // the error reading the request body
+	reqBodyRead bool     // set if the request body has been read
+	tee         *teeBody // if set the request body is captured as it is sent
+	noBodies    bool     // set if the bodies mustn't be dumped because of MaxConcurrentDumps
+
+	budget     *bodyBudget // the TotalBodyBudget to hold the bodies in or nil
+	reserved   int64       // the bytes reserved from budget
+	reqBudget  budgetState // whether the request body is in the budget
+	respBudget budgetState // whether the response body is in the budget
+
+	deferred map[*Transport]*deferredLogger // the logs of the outputs held until ShouldLog has been called
+
+	respBody         []byte // the response body read by txResponseBody if BodyDumpTimeout is set
+	respBodyErr      error  // the error reading the response body
+	respBodyRead     bool   // set if the response body has been read
+	respBodyTimedOut bool   // set if reading the response body timed out so respBody is partial
+
+	peekMax int64 // the largest MaxBodyDumpContentLength of the outputs
+	peeked  bool  // set once the start of a response body of unknown length has been read by bodyTooBig
+	peekLen int64 // the number of bytes of it read
+	peekErr error // the error reading it
+}
+
+// ttfb returns the time from the start of the round trip to the first
+// byte of the response. ok is false if it isn't known.
+func (tx *transaction) ttfb() (ttfb time.Duration, ok bool) {
+	if tx.conn.gotFirstByte.IsZero() {
+		return 0, false
+	}
+	return tx.conn.gotFirstByte.Sub(tx.start), true
+}
+
+// txRequestBody returns the request body of tx, reading it with
+// requestBody the first time it is called.
+//
+// If the request body is being captured as it is sent then it returns
+// what has been captured so far.
+func (t *Transport) txRequestBody(tx *transaction) ([]byte, error) {
+	if tx.tee != nil {
+		buf, _, _ := tx.tee.captured()
+		return buf, nil
+	}
+	if !tx.reqBodyRead {
+		tx.reqBody, tx.reqBodyErr = t.requestBody(tx.req)
+		tx.reqBodyRead = true
+	}
+	return tx.reqBody, tx.reqBodyErr
+}
+
+// newTransaction returns a transaction to log the round trip of req
+func (t *Transport) newTransaction(req *http.Request) *transaction {
+	tx := &transaction{
+		req:       req,
+		ref:       fmt.Sprintf("%p", req),
+		reqTitle:  "HTTP REQUEST",
+		respTitle: "HTTP RESPONSE",
+		isConnect: req.Method == http.MethodConnect,
+		attempt:   t.attempt(req),
+		conn:      new(connInfo),
+	}
+	if t.seq != nil {
+		tx.ref = t.opt.IDPrefix + strconv.FormatInt(atomic.AddInt64(t.seq, 1), 10)
+	}
+	if tx.isConnect {
+		tx.reqTitle, tx.respTitle = "HTTP CONNECT TUNNEL REQUEST", "HTTP CONNECT TUNNEL RESPONSE"
+	}
+	if t.opt.ReqTitle != "" {
+		tx.reqTitle = t.opt.ReqTitle
+	}
+	if t.opt.RespTitle != "" {
+		tx.respTitle = t.opt.RespTitle
+	}
+	if t.opt.OperationFunc != nil {
+		if operation := t.opt.OperationFunc(req); operation != "" {
+			tx.reqTitle += " " + operation
+			tx.respTitle += " " + operation
+		}
+	}
+	return tx
+}
+
+// id identifies the transaction in the logs
+func (tx *transaction) id() string {
+	if tx.attempt > 0 {
+		return fmt.Sprintf("req %s, attempt %d", tx.ref, tx.attempt)
+	}
+	return "req " + tx.ref
+}
+
+// logRequest logs the request block
+func (t *Transport) logRequest(tx *transaction) {
+	req := tx.req
+	t.logf(req, "%s", t.separator(req, DirectionRequest))
+	t.logf(req, "%s (%s)", tx.reqTitle, tx.id())
+	if t.opt.Caller {
+		t.logf(req, "from %s", caller(t.opt.CallerSkip))
+	}
+	if t.opt.Flags&DumpStruct != 0 {
+		t.logf(req, "%s", formatRequestStruct(req))
+	}
+	dumpBody := t.opt.Flags&(DumpBodies|DumpRequests) != 0 && !tx.isConnect
+	skipped := ""
+	if dumpBody && tx.noBodies {
+		skipped, dumpBody = dumpLimitNote, false
+	} else if dumpBody && !t.requestBodyInBudget(tx) {
+		skipped, dumpBody = budgetNote, false
+	}
+	buf, err := t.dumpRequest(tx, dumpBody)
+	if err != nil {
+		t.logfLevel(req, levelWarn, "Dump request failed: %v - showing the headers only", err)
+		buf, dumpBody = fallbackRequest(req), false
+	}
+	if skipped != "" {
+		buf = append(buf, skipped+"\n"...)
+	} else if dumpBody {
+		// There is no body to format
+		if note := t.requestBodyNote(tx); note != "" {
+			buf, dumpBody = append(buf, note+"\n"...), false
+		}
+	}
+	// Sniff the type of the body if it isn't set to choose how to show
+	// it, without changing the request
+	contentType := req.Header.Get("Content-Type")
+	if dumpBody {
+		contentType = dumpContentType(buf, contentType)
+	}
+	buf = t.redact(req, buf, DirectionRequest, contentType)
+	if t.opt.ShowInjected {
+		buf = annotateInjected(buf, req)
+	}
+	if dumpBody && len(t.opt.IncludeJSONFields) > 0 {
+		buf = includeJSONFields(buf, t.opt.IncludeJSONFields)
+	}
+	if dumpBody && t.opt.FormatBodies {
+		buf = t.formatBody(buf, req, DirectionRequest, contentType)
+	}
+	if dumpBody && t.opt.DecodeBase64Bodies {
+		buf = decodeBase64Body(buf)
+	}
+	if dumpBody && t.opt.RedactPII {
+		buf = redactPII(buf, t.opt.PIIPatterns)
+	}
+	// Captured bodies are truncated as they are captured
+	if dumpBody && tx.tee == nil {
+		buf = truncateBody(buf, t.opt.MaxReqBodySize)
+	}
+	if dumpBody && t.opt.DumpQuotedBodies {
+		buf = quoteBody(buf)
+	}
+	if t.opt.FoldHeaders {
+		buf = foldHeaders(buf)
+	}
+	if t.opt.CollapseRepeatedHeaders {
+		buf = collapseHeaders(buf)
+	}
+	buf = limitHeaders(buf, t.opt.MaxHeaders)
+	if t.opt.AlignHeaders {
+		buf = alignHeaders(buf)
+	}
+	if t.opt.Flags&dumpDetailFlags == 0 {
+		buf = firstLine(buf)
+	}
+	t.logDump(req, buf, DirectionRequest)
+	t.logf(req, "%s", t.separator(req, DirectionRequest))
+}
+
+// logResponse logs the response block
+func (t *Transport) logResponse(tx *transaction) {
+	req, resp := tx.req, tx.resp
+	t.logf(req, "%s", t.separator(req, DirectionResponse))
+	title := fmt.Sprintf("%s (%s)", tx.respTitle, tx.id())
+	if isWebSocketUpgrade(resp) {
+		title += " [websocket upgrade, frames not dumped]"
+	}
+	if note := encodingNote(tx); note != "" {
+		title += " " + note
+	}
+	if t.opt.ShowProto && resp != nil && resp.Proto != "" {
+		title += " [protocol " + resp.Proto + "]"
+	}
+	t.logf(req, "%s", title)
+	if t.opt.Flags&DumpTiming != 0 {
+		if ttfb, ok := tx.ttfb(); ok {
+			t.logf(req, "timing: round trip %v ttfb=%v", tx.duration, ttfb)
+		} else {
+			t.logf(req, "timing: round trip %v", tx.duration)
+		}
+	}
+	if t.opt.Flags&DumpConn != 0 {
+		if tx.conn.remote != nil {
+			t.logf(req, "connection: local=%v remote=%v reused=%v idle=%v%s", tx.conn.local, tx.conn.remote, tx.conn.reused, tx.conn.idleTime, t.routeNote(tx))
+		} else if note := t.routeNote(tx); note != "" {
+			t.logf(req, "connection:%s", note)
+		}
+	}
+	if tx.err != nil {
+		t.logfLevel(req, levelError, "HTTP request failed: %s", describeError(tx.err, tx.duration))
+	} else {
+		if t.opt.Flags&DumpStruct != 0 {
+			t.logf(req, "%s", formatResponseStruct(resp))
+		}
+		if t.opt.Flags&DumpTLS != 0 && resp.TLS != nil {
+			t.logf(req, "%s", formatTLS(resp.TLS))
+		}
+		if t.opt.DumpCertChain && resp.TLS != nil {
+			for i, cert := range resp.TLS.PeerCertificates {
+				t.logf(req, "%s", formatCert(i, cert))
+			}
+		}
+		if t.opt.HighlightRateLimit {
+			if line := rateLimitLine(resp.Header, t.now()); line != "" {
+				t.logf(req, "%s", line)
+			}
+		}
+		// The body of a 101 response is the upgraded connection so
+		// reading it would break the protocol
+		dumpBody := t.opt.Flags&(DumpBodies|DumpResponses) != 0 && !tx.isConnect && resp.StatusCode != http.StatusSwitchingProtocols
+		omitted := ""
+		if dumpBody && tx.noBodies {
+			omitted, dumpBody = dumpLimitNote, false
+		} else if dumpBody && isNDJSON(resp) {
+			omitted, dumpBody = ndjsonNote, false
+		} else if dumpBody {
+			omitted = t.bodyTooBig(tx)
+			dumpBody = omitted == ""
+		}
+		if dumpBody && !t.responseBodyInBudget(tx) {
+			omitted, dumpBody = budgetNote, false
+		}
+		// Read the body with a timeout first if required, dumping
+		// what was read if it timed out
+		timedOut := false
+		if dumpBody && t.opt.BodyDumpTimeout > 0 {
+			_, _ = t.txResponseBody(tx)
+			timedOut = tx.respBodyTimedOut
+		}
+		var buf []byte
+		var derr error
+		if timedOut {
+			buf, derr = dumpResponseHeaders(resp)
+			buf = append(buf, tx.respBody...)
+			if len(tx.respBody) > 0 && buf[len(buf)-1] != '\n' {
+				buf = append(buf, '\n')
+			}
+			omitted = fmt.Sprintf("[body dump timed out after %v]", t.opt.BodyDumpTimeout)
+		} else if dumpBody {
+			buf, derr = httputil.DumpResponse(resp, true)
+		} else {
+			buf, derr = dumpResponseHeaders(resp)
+		}
+		if derr != nil {
+			t.logfLevel(req, levelWarn, "Dump response failed: %v - showing the headers only", derr)
+			buf, dumpBody = fallbackResponse(resp), false
+		}
+		if omitted != "" {
+			buf = append(buf, omitted+"\n"...)
+		}
+		if dumpBody && t.opt.RegzipBodies && resp.Uncompressed {
+			buf = appendGzippedSize(buf)
+		}
+		contentType := resp.Header.Get("Content-Type")
+		if dumpBody {
+			contentType = dumpContentType(buf, contentType)
+		}
+		buf = t.redact(req, buf, DirectionResponse, contentType)
+		if dumpBody && len(t.opt.IncludeJSONFields) > 0 {
+			buf = includeJSONFields(buf, t.opt.IncludeJSONFields)
+		}
+		if dumpBody && t.opt.FormatBodies {
+			buf = t.formatBody(buf, req, DirectionResponse, contentType)
+		}
+		if dumpBody && t.opt.DecodeBase64Bodies {
+			buf = decodeBase64Body(buf)
+		}
+		if dumpBody && t.opt.RedactPII {
+			buf = redactPII(buf, t.opt.PIIPatterns)
+		}
+		if dumpBody && t.opt.DumpQuotedBodies {
+			buf = quoteBody(buf)
+		}
+		if t.opt.FoldHeaders {
+			buf = foldHeaders(buf)
+		}
+		if t.opt.CollapseRepeatedHeaders {
+			buf = collapseHeaders(buf)
+		}
+		buf = limitHeaders(buf, t.opt.MaxHeaders)
+		if t.opt.AlignHeaders {
+			buf = alignHeaders(buf)
+		}
+		if t.opt.Flags&dumpDetailFlags == 0 {
+			buf = firstLine(buf)
+		}
+		t.logDump(req, buf, DirectionResponse)
+		if line := grpcStatusLine(resp); line != "" {
+			t.logf(req, "%s", line)
+		}
+	}
+	t.logf(req, "%s", t.separator(req, DirectionResponse))
+}
+
+// isRedirect returns true if resp is a redirect the client may follow
+func isRedirect(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return resp.Header.Get("Location") != ""
+	}
+	return false
+}
+
+// logRedirectChain logs the redirects followed to make the request of
+// tx if it has the final response, walking back the chain with
+// req.Response
+func (t *Transport) logRedirectChain(tx *transaction) {
+	req := tx.req
+	if tx.err != nil || req.Response == nil || isRedirect(tx.resp) {
+		return
+	}
+	var hops []*http.Request
+	for r := req; r != nil; {
+		hops = append([]*http.Request{r}, hops...)
+		if r.Response == nil {
+			break
+		}
+		r = r.Response.Request
+	}
+	var b strings.Builder
+	b.WriteString("redirect chain:")
+	for i, hop := range hops {
+		where := t.scrubRequestURI(hop.URL)
+		if hop.URL.Host != hops[0].URL.Host {
+			where = t.scrubURL(hop.URL)
+		}
+		resp := tx.resp
+		if i+1 < len(hops) {
+			resp = hops[i+1].Response
+		}
+		if i > 0 {
+			b.WriteString(" ->")
+		}
+		fmt.Fprintf(&b, " %s %s -> %d", hop.Method, where, resp.StatusCode)
+	}
+	t.logf(req, "%s (%s)", b.String(), tx.id())
+}
+
+// logDump logs the dump in buf of the request or response of req as
+// selected by dir
+func (t *Transport) logDump(req *http.Request, buf []byte, dir Direction) {
+	t.logf(req, "%s", string(buf))
+}
+
+// logBlock logs the request or response block of tx, as selected by
+// dir, with log. If PostProcess is set the lines of the block are
+// collected and passed through it first, then logged as one line at
+// the most severe level of them.
+func (t *Transport) logBlock(tx *transaction, dir Direction, log func(t *Transport, tx *transaction)) {
+	if t.opt.PostProcess == nil {
+		log(t, tx)
+		return
+	}
+	b := &blockLogger{}
+	c := t.withFlags(t.opt.Flags)
+	c.out = nil
+	c.opt.Writer = nil
+	c.opt.LogfCtx = nil
+	c.opt.LeveledLogger = b
+	log(c, tx)
+	block := t.opt.PostProcess(bytes.Join(b.lines, []byte("\n")), dir)
+	if block == nil {
+		return
+	}
+	t.logfLevel(tx.req, b.lvl, "%s", block)
+}
+
+// blockLogger collects the lines of a block for PostProcess
+type blockLogger struct {
+	lines [][]byte
+	lvl   level // the most severe level logged
+}
+
+// logf records the line at lvl
+func (b *blockLogger) logf(lvl level, format string, v ...interface{}) {
+	b.lines = append(b.lines, []byte(fmt.Sprintf(format, v...)))
+	if lvl > b.lvl {
+		b.lvl = lvl
+	}
+}
+
+// Debugf implements Logger
+func (b *blockLogger) Debugf(format string, v ...interface{}) {
+	b.logf(levelDebug, format, v...)
+}
+
+// Warnf implements Logger
+func (b *blockLogger) Warnf(format string, v ...interface{}) {
+	b.logf(levelWarn, format, v...)
+}
+
+// Errorf implements Logger
+func (b *blockLogger) Errorf(format string, v ...interface{}) {
+	b.logf(levelError, format, v...)
+}
+
+// streamsNDJSON returns true if the response body of tx should be
+// logged line by line as it is read as it is newline delimited JSON
+func (t *Transport) streamsNDJSON(tx *transaction) bool {
+	return t.opt.Flags&dumpBlockFlags != 0 && t.opt.Flags&(DumpBodies|DumpResponses) != 0 &&
+		t.opt.Format == FormatRaw && !t.opt.NoResponse && !tx.noBodies &&
+		tx.resp.Body != nil && tx.resp.Body != http.NoBody && isNDJSON(tx.resp)
+}
+
+// dumpLimitNote is shown instead of the bodies of transactions over
+// the MaxConcurrentDumps limit
+const dumpLimitNote = "[body dump skipped: dump concurrency limit]"
+
+// dumpsBodies returns true if any of outputs dump bodies
+func dumpsBodies(outputs []*Transport) bool {
+	for _, out := range outputs {
+		if out.opt.Flags&(DumpBodies|DumpRequests|DumpResponses) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// bodyTooBig returns a note to show instead of the response body of tx
+// if it is longer than MaxBodyDumpContentLength or "" if it should be
+// dumped.
+//
+// If the length of the body is unknown then up to the largest limit of
+// the outputs is read to find out, once per transaction, and the body
+// is replaced so nothing is lost.
+func (t *Transport) bodyTooBig(tx *transaction) string {
+	resp := tx.resp
+	max := t.opt.MaxBodyDumpContentLength
+	if max <= 0 {
+		return ""
+	}
+	if resp.ContentLength > max {
+		return fmt.Sprintf("[body omitted: %d bytes]", resp.ContentLength)
+	}
+	if resp.ContentLength >= 0 || resp.Body == nil || resp.Body == http.NoBody {
+		return ""
+	}
+	if !tx.peeked {
+		limit := tx.peekMax
+		if limit < max {
+			limit = max
+		}
+		start, err := ioutil.ReadAll(io.LimitReader(resp.Body, limit+1))
+		resp.Body = readCloser{
+			Reader: io.MultiReader(bytes.NewReader(start), resp.Body),
+			Closer: resp.Body,
+		}
+		tx.peeked, tx.peekLen, tx.peekErr = true, int64(len(start)), err
+	}
+	if tx.peekErr == nil && tx.peekLen > max {
+		return fmt.Sprintf("[body omitted: more than %d bytes]", max)
+	}
+	return ""
+}
+
+// maxBodyDumpContentLength returns the largest MaxBodyDumpContentLength
+// of outputs
+func maxBodyDumpContentLength(outputs []*Transport) (max int64) {
+	for _, out := range outputs {
+		if out.opt.MaxBodyDumpContentLength > max {
+			max = out.opt.MaxBodyDumpContentLength
+		}
+	}
+	return max
+}
+
+// dumpsTiming returns true if any of outputs has DumpTiming set
+func dumpsTiming(outputs []*Transport) bool {
+	for _, out := range outputs {
+		if out.opt.Flags&DumpTiming != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// detectsBodyLeaks returns true if any of outputs has DetectBodyLeak
+// set
+func detectsBodyLeaks(outputs []*Transport) bool {
+	for _, out := range outputs {
+		if out.opt.DetectBodyLeak {
+			return true
+		}
+	}
+	return false
+}
+
+// isWebSocketUpgrade returns true if resp is a successful upgrade to
+// the websocket protocol
+func isWebSocketUpgrade(resp *http.Response) bool {
+	return resp != nil && resp.StatusCode == http.StatusSwitchingProtocols && strings.EqualFold(resp.Header.Get("Upgrade"), "websocket")
+}
+
+// formatRequestStruct returns the fields of req which the dumps don't
+// show directly on one line
+func formatRequestStruct(req *http.Request) string {
+	return fmt.Sprintf("request: Proto=%s Host=%q RemoteAddr=%q ContentLength=%d TransferEncoding=%v Close=%v GetBody=%v",
+		req.Proto, req.Host, req.RemoteAddr, req.ContentLength, req.TransferEncoding, req.Close, req.GetBody != nil)
+}
+
+// formatResponseStruct returns the fields of resp which the dumps
+// don't show directly on one line
+func formatResponseStruct(resp *http.Response) string {
+	return fmt.Sprintf("response: Proto=%s ContentLength=%d TransferEncoding=%v Uncompressed=%v Close=%v Trailer=%v",
+		resp.Proto, resp.ContentLength, resp.TransferEncoding, resp.Uncompressed, resp.Close, len(resp.Trailer) > 0)
+}
+
+// encodingNote returns a note about the compression of the response
+// of tx for its title or "" if there is nothing to say.
+//
+// If the caller didn't set Accept-Encoding then net/http asks for gzip
+// itself and decompresses the response, removing the Content-Encoding,
+// whereas if the caller did the body is left as it was sent, so the
+// dumps can't be read correctly without knowing which happened.
+func encodingNote(tx *transaction) string {
+	resp := tx.resp
+	if resp == nil {
+		return ""
+	}
+	if resp.Uncompressed {
+		return "[auto-decompressed]"
+	}
+	if encoding := resp.Header.Get("Content-Encoding"); encoding != "" && tx.req.Header.Get("Accept-Encoding") != "" {
+		return "[caller requested " + encoding + ", not decompressed]"
+	}
+	return ""
+}
+
+// appendGzippedSize adds a note with the size of the body in the dump
+// in buf when gzipped, to give an idea of its size on the wire.
+func appendGzippedSize(buf []byte) []byte {
+	i := bytes.Index(buf, []byte("\r\n\r\n"))
+	if i < 0 {
+		return buf
+	}
+	var size countingWriter
+	zw := gzip.NewWriter(&size)
+	_, _ = zw.Write(buf[i+4:])
+	_ = zw.Close()
+	if len(buf) > i+4 && buf[len(buf)-1] != '\n' {
+		buf = append(buf, '\n')
+	}
+	return append(buf, fmt.Sprintf("[body is %d bytes gzipped]\n", size)...)
+}
+
+// countingWriter is an io.Writer which counts the bytes written to it
+type countingWriter int64
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
+
+// describeError describes err from a round trip which took duration,
+// calling out cancellations and timeouts.
+func describeError(err error, duration time.Duration) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return fmt.Sprintf("request cancelled after %v: %v", duration.Round(time.Millisecond), err)
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return fmt.Sprintf("deadline exceeded after %v: %v", duration.Round(time.Millisecond), err)
+	}
+	return err.Error()
+}
+
+// logSummary logs a one line summary of the transaction
+func (t *Transport) logSummary(tx *transaction) {
+	req := tx.req
+	if tx.err != nil {
+		t.logfLevel(req, levelError, "%s %s -> failed: %v in %v%s (%s)", req.Method, t.scrubURL(req.URL), tx.err, tx.duration, t.addrLabel(tx), tx.id())
+		return
+	}
+	t.logfLevel(req, t.statusLevel(tx.resp), "%s %s -> %s in %v%s (%s)", req.Method, t.scrubURL(req.URL), t.redactLine(tx.resp.Status), tx.duration, t.addrLabel(tx), tx.id())
+}
+
+// targetAddr returns the host:port which u is for, using the default
+// port for the scheme if it has none, with any IPv6 address in
+// brackets, eg "[::1]:443".
+func targetAddr(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" || u.Scheme == "wss" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// remoteAddr returns the address tx was actually sent to, which is
+// the proxy if one was used, or "" if unknown
+func remoteAddr(tx *transaction) string {
+	if tx.conn.remote == nil {
+		return ""
+	}
+	return tx.conn.remote.String()
+}
+
+// addrLabel returns the target and remote address of tx to add to the
+// one line logs if ShowRemoteAddr is set
+func (t *Transport) addrLabel(tx *transaction) string {
+	if !t.opt.ShowRemoteAddr {
+		return ""
+	}
+	label := " target=" + targetAddr(tx.req.URL)
+	if remote := remoteAddr(tx); remote != "" {
+		label += " remote=" + remote
+	}
+	return label
+}
+
+// countHeaders returns the number of header lines in header
+func countHeaders(header http.Header) (n int) {
+	for _, values := range header {
+		n += len(values)
+	}
+	return n
+}
+
+// formatSize formats a body size in bytes for the compact logs
+func formatSize(size int64) string {
+	const units = "kMGTPE"
+	if size < 0 {
+		return "?"
+	}
+	if size < 1000 {
+		return fmt.Sprintf("%dB", size)
+	}
+	value := float64(size) / 1000
+	i := 0
+	for value >= 1000 && i < len(units)-1 {
+		value /= 1000
+		i++
+	}
+	return fmt.Sprintf("%.1f%cB", value, units[i])
+}
+
+// logCompactRequest logs the request on one line
+func (t *Transport) logCompactRequest(tx *transaction) {
+	req := tx.req
+	t.logf(req, "> %s %s {%d headers} {%s body} (%s)", req.Method, t.scrubRequestURI(req.URL), countHeaders(req.Header), formatSize(req.ContentLength), tx.id())
+}
+
+// logCompactResponse logs the response on one line
+func (t *Transport) logCompactResponse(tx *transaction) {
+	req, resp := tx.req, tx.resp
+	duration := tx.duration.Round(time.Millisecond)
+	if duration == 0 {
+		duration = tx.duration.Round(time.Microsecond)
+	}
+	if tx.err != nil {
+		t.logfLevel(req, levelError, "< failed: %v %v%s (%s)", tx.err, duration, t.addrLabel(tx), tx.id())
+		return
+	}
+	t.logfLevel(req, t.statusLevel(resp), "< %s {%d headers} %v {%s body}%s (%s)", t.redactLine(resp.Status), countHeaders(resp.Header), duration, formatSize(resp.ContentLength), t.addrLabel(tx), tx.id())
+}
+
+// logSeparated logs the one line log of tx with log, putting the
+// separator lines for dir around it if LineSeparators is set
+func (t *Transport) logSeparated(tx *transaction, dir Direction, log func(tx *transaction)) {
+	if !t.opt.LineSeparators {
+		log(tx)
+		return
+	}
+	t.logf(tx.req, "%s", t.separator(tx.req, dir))
+	log(tx)
+	t.logf(tx.req, "%s", t.separator(tx.req, dir))
+}
+
+// logBefore logs the transaction before the round trip according to
+// our Options.
+func (t *Transport) logBefore(tx *transaction) {
+	if t.opt.ShouldLog != nil {
+		t = t.deferLogs(tx)
+	}
+	// If the request body is being captured the request is logged
+	// after the round trip
+	if tx.tee == nil {
+		t.logRequestBlock(tx)
+	}
+	if t.opt.Flags&DumpCompact != 0 {
+		t.logSeparated(tx, DirectionRequest, t.logCompactRequest)
+	}
+}
+
+// logRequestBlock logs the request block or .http file request
+// according to our Options.
+func (t *Transport) logRequestBlock(tx *transaction) {
+	if t.opt.Flags&dumpBlockFlags == 0 || t.opt.NoRequest {
+		return
+	}
+	switch t.opt.Format {
+	case FormatHTTPFile:
+		// .http files only contain the requests
+		t.logHTTPFile(tx)
+	case FormatLogfmt, FormatPcapText:
+		// logged with the response
+	default:
+		t.logBlock(tx, DirectionRequest, (*Transport).logRequest)
+	}
+}
+
+// dumpsRequestBody returns true if the request bodies are dumped
+func (t *Transport) dumpsRequestBody() bool {
+	return t.opt.Flags&(DumpBodies|DumpRequests) != 0 && !t.opt.NoRequest && t.opt.Format != FormatLogfmt && t.opt.Format != FormatPcapText
+}
+
+// logsResponse returns true if logAfter logs the response block
+func (t *Transport) logsResponse() bool {
+	return t.opt.Flags&dumpBlockFlags != 0 && t.opt.Format == FormatRaw && !t.opt.NoResponse
+}
+
+// logAfter logs the transaction after the round trip according to
+// our Options.
+func (t *Transport) logAfter(tx *transaction) {
+	if !t.shouldLog(tx) {
+		return
+	}
+	if tx.tee != nil {
+		t.logRequestBlock(tx)
+	}
+	if t.opt.Flags&dumpBlockFlags != 0 {
+		switch {
+		case t.opt.Format == FormatLogfmt:
+			t.logLogfmt(tx)
+		case t.opt.Format == FormatPcapText:
+			t.logPcapText(tx)
+		case t.logsResponse():
+			t.logBlock(tx, DirectionResponse, (*Transport).logResponse)
+		}
+	}
+	if t.opt.Flags&DumpWire != 0 {
+		t.logWire(tx)
+	}
+	if t.opt.Flags&DumpCompact != 0 {
+		t.logSeparated(tx, DirectionResponse, t.logCompactResponse)
+	}
+	if t.opt.Flags&DumpSummary != 0 {
+		t.logSeparated(tx, DirectionResponse, t.logSummary)
+	}
+	if t.opt.RedirectSummary {
+		t.logRedirectChain(tx)
+	}
+	// Don't wrap the body of CONNECT or protocol switching responses
+	// as it is the connection
+	if tx.err == nil && !tx.isConnect && tx.resp.StatusCode != http.StatusSwitchingProtocols {
+		if t.opt.Flags&DumpSizes != 0 {
+			tx.resp.Body = newCountingBody(t, tx)
+		}
+		if t.streamsNDJSON(tx) {
+			tx.resp.Body = newNDJSONBody(t, tx)
+		}
+		if t.opt.BodyHash && tx.resp.Body != nil {
+			tx.resp.Body = newHashingBody(t, tx, tx.resp.Body, "HTTP RESPONSE BODY")
+		}
+		if t.opt.Flags&DumpTiming != 0 && t.logsResponse() && tx.resp.Body != nil {
+			tx.resp.Body = newTimingBody(t, tx)
+		}
+		// If the body wasn't dumped the gRPC status will be in the
+		// trailers which are only read at the end of it
+		if t.logsResponse() && isGRPC(tx.resp) && grpcStatusLine(tx.resp) == "" && tx.resp.Body != nil {
+			tx.resp.Body = newGRPCBody(t, tx)
+		}
+		// This must be the outermost wrapper as it is the one the
+		// caller drops
+		if t.opt.DetectBodyLeak && tx.resp.Body != nil && tx.resp.Body != http.NoBody {
+			tx.resp.Body = newLeakBody(t, tx)
+		}
+	}
+}
+
+// RoundTrip implements the RoundTripper interface.
+func (t *Transport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+	if Disabled() || !t.window.contains(t.now) {
+		return t.next.RoundTrip(req)
+	}
+	if host := t.hostTransport(req); host != nil {
+		return host.RoundTrip(req)
+	}
+	// Check the context before deciding what to log so a request can
+	// be dumped even if our Flags are 0
+	flags := FlagsFromContext(req.Context())
+	if !t.opt.AllowAuthDump {
+		flags &^= DumpAuth
+	}
+	if flags&^t.opt.Flags != 0 {
+		return t.withFlags(t.opt.Flags | flags).RoundTrip(req)
+	}
+	if t.endpoints != nil && !t.firstForEndpoint(req) {
+		return t.quiet().RoundTrip(req)
+	}
+	if t.opt.BodyFilter != nil && !t.bodySelected(req) {
+		return t.quiet().RoundTrip(req)
+	}
+	tx := t.newTransaction(req)
+	outputs := append([]*Transport{t}, t.sinks...)
+	tx.peekMax = maxBodyDumpContentLength(outputs)
+	if t.budget != nil {
+		tx.budget = t.budget
+		defer tx.releaseBudget()
+	}
+	// Don't wait for a dump slot as the round trip mustn't be delayed
+	if t.dumpSem != nil && dumpsBodies(outputs) {
+		select {
+		case t.dumpSem <- struct{}{}:
+			defer func() { <-t.dumpSem }()
+		default:
+			tx.noBodies = true
+		}
+	}
+	if (t.opt.TeeRequestBody && !replayable(req) || expectsContinue(req)) && !tx.isConnect && !tx.noBodies {
+		for _, out := range outputs {
+			if out.dumpsRequestBody() {
+				tx.tee = newTeeBody(req.Body, t.opt.MaxReqBodySize)
+				break
+			}
+		}
+	}
+	for _, out := range outputs {
+		out.logBefore(tx)
+	}
+	// Do round trip tracing the connection for the logs and Stats
+	atomic.AddInt64(&t.stats.Requests, 1)
+	var now func() time.Time
+	if dumpsTiming(outputs) {
+		now = t.now
+	}
+	outReq := withConnTrace(req, tx.conn, now)
+	// The tee goes on the outgoing copy as req mustn't be modified
+	if tx.tee != nil {
+		outReq.Body = tx.tee
+	}
+	if outReq.Body != nil && outReq.Body != http.NoBody && !tx.isConnect {
+		for _, out := range outputs {
+			if out.opt.BodyHash {
+				outReq.Body = newHashingBody(tx.logger(out), tx, outReq.Body, "HTTP REQUEST BODY")
+			}
+		}
+	}
+	tx.start = t.now()
+	if t.opt.OnEvent != nil {
+		t.opt.OnEvent(t.requestEvent(tx))
+	}
+	resp, err = t.next.RoundTrip(outReq)
+	tx.duration = t.now().Sub(tx.start)
+	if resp != nil && resp.Request == outReq {
+		resp.Request = req
+	}
+	tx.resp, tx.err = resp, err
+	if tx.conn.conn != nil {
+		if tx.conn.reused {
+			atomic.AddInt64(&t.stats.ReusedConns, 1)
+		} else {
+			atomic.AddInt64(&t.stats.NewConns, 1)
+		}
+	}
+	if t.opt.OnEvent != nil {
+		t.opt.OnEvent(t.responseEvent(tx))
+	}
+	// The underlying Transport keeps the response until its body is
+	// done with, so give the caller a copy to put the body wrappers
+	// in or a leaked body would never be garbage collected
+	if resp != nil && detectsBodyLeaks(outputs) {
+		respCopy := *resp
+		tx.resp = &respCopy
+	}
+	for _, out := range outputs {
+		out.logAfter(tx)
+	}
+	return tx.resp, err
 }
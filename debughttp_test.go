@@ -2,12 +2,27 @@ package debughttp
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/cookiejar"
 	"net/http/httptest"
+	"net/url"
+	"path"
+	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -69,6 +84,57 @@ func TestCleanAuth(t *testing.T) {
 	}
 }
 
+func TestCleanAuthCanonical(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		auth string
+		want string
+	}{
+		{"x-auth-token: AAAAAAAAA\n", "X-Auth-Token: ", "x-auth-token: XXXX\n"},
+		{"X-AUTH-TOKEN: AAAAAAAAA\n", "X-Auth-Token: ", "X-AUTH-TOKEN: XXXX\n"},
+		{"X-Auth-Token: AAAAAAAAA\n", "x-auth-token: ", "X-Auth-Token: XXXX\n"},
+		{"X-Auth-Token: AAAAAAAAA\n", "x-auth-token", "X-Auth-Token: XXXX\n"},
+		{"X-Auth-Token:AAAAAAAAA\n", "X-Auth-Token", "X-Auth-Token:XXXX\n"},
+		{"Proxy-Authorization: AAAAAAAAA\n", "Authorization: ", "Proxy-Authorization: AAAAAAAAA\n"},
+		{"GET / HTTP/1.1\r\nauthorization: AAAAAAAAA\r\nAuthorization: BBBBBBBBB\r\n\r\n", "Authorization: ", "GET / HTTP/1.1\r\nauthorization: XXXX\r\nAuthorization: XXXX\r\n\r\n"},
+		{"GET / HTTP/1.1\r\n\r\nAuthorization: AAAAAAAAA in the body", "Authorization: ", "GET / HTTP/1.1\r\n\r\nAuthorization: AAAAAAAAA in the body"},
+	} {
+		got := string(cleanAuth([]byte(test.in), []byte(test.auth)))
+		assert.Equal(t, test.want, got, test.in)
+	}
+}
+
+func TestCleanAuthNoTrailingNewline(t *testing.T) {
+	const req = "GET / HTTP/1.1\r\nHost: example.com\r\n"
+	for _, test := range []struct {
+		in   string
+		want string
+	}{
+		{req + "Authorization: SECRET", req + "Authorization: XXXX"},
+		{req + "Authorization: SECRET\r", req + "Authorization: XXXX\r"},
+		{req + "Authorization: SECRET\r\nPotato: Help", req + "Authorization: XXXX\r\nPotato: Help"},
+		{req + "Authorization: SECRET\r\n\r\nBody with no newline", req + "Authorization: XXXX\r\n\r\nBody with no newline"},
+		{req + "Authorization: SECRET\r\n\r\nAuthorization: BODY", req + "Authorization: XXXX\r\n\r\nAuthorization: BODY"},
+		{"Authorization: SECRET\n\nBody", "Authorization: XXXX\n\nBody"},
+		{"Authorization: SECRET\nAuthorization: SECRET", "Authorization: XXXX\nAuthorization: XXXX"},
+	} {
+		got := string(cleanAuth([]byte(test.in), Auth[0]))
+		assert.Equal(t, test.want, got, test.in)
+	}
+
+	// All the Auth headers with the last one at the end of the buffer
+	transport := NewDefault(nil)
+	in := req + "X-Auth-Token: SECRET\r\nProxy-Authorization: SECRET\r\nAuthorization: SECRET"
+	want := req + "X-Auth-Token: XXXX\r\nProxy-Authorization: XXXX\r\nAuthorization: XXXX"
+	assert.Equal(t, want, string(transport.cleanAuths([]byte(in))))
+
+	// The other rewriters keep the rest of the buffer too
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwt := b64([]byte(`{"alg":"RS256","typ":"JWT"}`)) + "." + b64([]byte(`{}`)) + "." + b64([]byte("signature"))
+	assert.Equal(t, req+"Authorization: Bearer {alg:RS256,typ:JWT}.XXXX.XXXX", string(cleanJWT([]byte(req+"Authorization: Bearer "+jwt), Auth[0])))
+	assert.Equal(t, req+"Authorization: [REDACTED 6 chars]", string(rewriteHeaders([]byte(req+"Authorization: SECRET"), Auth[0], lengthValue)))
+}
+
 func TestCleanAuths(t *testing.T) {
 	transport := NewDefault(nil)
 	for _, test := range []struct {
@@ -80,10 +146,71 @@ func TestCleanAuths(t *testing.T) {
 		{"Authorization: AAAAAAAAA\nPotato: Help\n", "Authorization: XXXX\nPotato: Help\n"},
 		{"X-Auth-Token: AAAAAAAAA\nPotato: Help\n", "X-Auth-Token: XXXX\nPotato: Help\n"},
 		{"X-Auth-Token: AAAAAAAAA\nAuthorization: AAAAAAAAA\nPotato: Help\n", "X-Auth-Token: XXXX\nAuthorization: XXXX\nPotato: Help\n"},
+		{"Proxy-Authorization: AAAAAAAAA\nPotato: Help\n", "Proxy-Authorization: XXXX\nPotato: Help\n"},
+	} {
+		got := string(transport.cleanAuths([]byte(test.in)))
+		assert.Equal(t, test.want, got, test.in)
+	}
+}
+
+func TestRedactShowLength(t *testing.T) {
+	transport := NewDefault(&Options{RedactShowLength: true})
+	token := strings.Repeat("A", 184)
+	for _, test := range []struct {
+		in   string
+		want string
+	}{
+		{"Authorization: Bearer " + token + "\r\nPotato: Help\r\n", "Authorization: [REDACTED 191 chars]\r\nPotato: Help\r\n"},
+		{"X-Auth-Token: " + token + "\nPotato: Help\n", "X-Auth-Token: [REDACTED 184 chars]\nPotato: Help\n"},
+		{"X-Auth-Token: AB\n", "X-Auth-Token: [REDACTED 2 chars]\n"},
+		{"X-Auth-Token: \n", "X-Auth-Token: [REDACTED 0 chars]\n"},
 	} {
 		got := string(transport.cleanAuths([]byte(test.in)))
 		assert.Equal(t, test.want, got, test.in)
 	}
+
+	// The length reported is that of the original value
+	value := "Bearer " + token
+	header := transport.redactHeader(http.Header{"Authorization": {value}})
+	assert.Equal(t, fmt.Sprintf("[REDACTED %d chars]", len(value)), header.Get("Authorization"))
+}
+
+func TestMarkAuthPresent(t *testing.T) {
+	transport := NewDefault(&Options{MarkAuthPresent: true})
+	token := strings.Repeat("A", 40)
+	for _, test := range []struct {
+		in   string
+		want string
+	}{
+		{"Authorization: " + token + "\r\nPotato: Help\r\n", "Authorization: [present, 40 chars]\r\nPotato: Help\r\n"},
+		{"X-Auth-Token: A\n", "X-Auth-Token: [present, 1 char]\n"},
+		{"X-Auth-Token: AB\n", "X-Auth-Token: [present, 2 chars]\n"},
+		{"X-Auth-Token: \n", "X-Auth-Token: [absent]\n"},
+		{"X-Auth-Token:\r\n", "X-Auth-Token:[absent]\r\n"},
+		{"Potato: Help\n", "Potato: Help\n"},
+	} {
+		got := string(transport.cleanAuths([]byte(test.in)))
+		assert.Equal(t, test.want, got, test.in)
+	}
+
+	header := transport.redactHeader(http.Header{"Authorization": {token}, "X-Auth-Token": {""}})
+	assert.Equal(t, "[present, 40 chars]", header.Get("Authorization"))
+	assert.Equal(t, "[absent]", header.Get("X-Auth-Token"))
+
+	// A missing Authorization header is marked in the dumps
+	for _, test := range []struct {
+		in   string
+		want string
+	}{
+		{"GET / HTTP/1.1\r\nHost: x\r\n\r\n", "GET / HTTP/1.1\r\nHost: x\r\nAuthorization: [absent]\r\n\r\n"},
+		{"GET / HTTP/1.1\r\nx-auth-token: A\r\n\r\n", "GET / HTTP/1.1\r\nx-auth-token: [present, 1 char]\r\n\r\n"},
+		{"GET / HTTP/1.1\r\nAuthorization: AB\r\n\r\nbody", "GET / HTTP/1.1\r\nAuthorization: [present, 2 chars]\r\n\r\nbody"},
+	} {
+		got := string(transport.redact(nil, []byte(test.in), DirectionRequest, ""))
+		assert.Equal(t, test.want, got, test.in)
+	}
+	got := string(transport.redact(nil, []byte("HTTP/1.1 200 OK\r\n\r\n"), DirectionResponse, ""))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n\r\n", got, "not in responses")
 }
 
 func TestTransport(t *testing.T) {
@@ -159,8 +286,9 @@ func TestTransport(t *testing.T) {
 	} {
 		t.Run(test.name, func(t *testing.T) {
 			client := NewClient(&Options{
-				Flags: test.flags,
-				Logf:  logf,
+				Flags:         test.flags,
+				Logf:          logf,
+				AllowAuthDump: true,
 			})
 			lines = nil
 
@@ -210,3 +338,2035 @@ func TestTransport(t *testing.T) {
 		})
 	}
 }
+
+// readCounter is an io.ReadCloser which records whether it was read
+type readCounter struct {
+	io.Reader
+	reads int
+}
+
+func (r *readCounter) Read(p []byte) (int, error) {
+	r.reads++
+	return r.Reader.Read(p)
+}
+
+func (r *readCounter) Close() error { return nil }
+
+// readSeekCloser is an io.ReadSeeker with a Close method
+type readSeekCloser struct {
+	*strings.Reader
+}
+
+func (readSeekCloser) Close() error { return nil }
+
+func TestDumpRequest(t *testing.T) {
+	const requestBody = "Request text"
+	var lines []string
+	transport := NewDefault(&Options{
+		Flags: DumpBodies,
+		Logf: func(format string, v ...interface{}) {
+			lines = append(lines, fmt.Sprintf(format, v...))
+		},
+	})
+
+	t.Run("GetBody", func(t *testing.T) {
+		lines = nil
+		body := &readCounter{Reader: strings.NewReader(requestBody)}
+		req, err := http.NewRequest("PUT", "http://example.com/", body)
+		require.NoError(t, err)
+		req.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(strings.NewReader(requestBody)), nil
+		}
+		buf, err := transport.dumpRequest(transport.newTransaction(req), true)
+		require.NoError(t, err)
+		assert.Contains(t, string(buf), requestBody)
+		assert.Equal(t, 0, body.reads)
+		assert.Equal(t, body, req.Body)
+		assert.Equal(t, 0, len(lines))
+	})
+
+	t.Run("Seekable", func(t *testing.T) {
+		lines = nil
+		body := readSeekCloser{strings.NewReader(requestBody)}
+		req, err := http.NewRequest("PUT", "http://example.com/", body)
+		require.NoError(t, err)
+		buf, err := transport.dumpRequest(transport.newTransaction(req), true)
+		require.NoError(t, err)
+		assert.Contains(t, string(buf), requestBody)
+		assert.Equal(t, body, req.Body)
+		got, err := ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+		assert.Equal(t, requestBody, string(got))
+		assert.Equal(t, 0, len(lines))
+	})
+
+	t.Run("Stream", func(t *testing.T) {
+		lines = nil
+		body := &readCounter{Reader: strings.NewReader(requestBody)}
+		req, err := http.NewRequest("PUT", "http://example.com/", body)
+		require.NoError(t, err)
+		buf, err := transport.dumpRequest(transport.newTransaction(req), true)
+		require.NoError(t, err)
+		assert.Contains(t, string(buf), requestBody)
+		got, err := ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+		assert.Equal(t, requestBody, string(got))
+		require.Equal(t, 1, len(lines))
+		assert.Contains(t, lines[0], "Warning")
+	})
+}
+
+func TestCleanJWT(t *testing.T) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	header := b64([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	payload := b64([]byte(`{"sub":"1234567890","email":"user@example.com"}`))
+	signature := b64([]byte("signature"))
+	jwt := header + "." + payload + "." + signature
+	noTyp := b64([]byte(`{"alg":"HS256"}`)) + "." + payload + "." + signature
+	for _, test := range []struct {
+		in   string
+		want string
+	}{
+		{"", ""},
+		{"floo", "floo"},
+		{"Authorization: Bearer " + jwt, "Authorization: Bearer {alg:RS256,typ:JWT}.XXXX.XXXX"},
+		{"Authorization: Bearer " + jwt + "\r\nPotato: Help\n", "Authorization: Bearer {alg:RS256,typ:JWT}.XXXX.XXXX\r\nPotato: Help\n"},
+		{"Authorization: " + noTyp + "\n", "Authorization: {alg:HS256}.XXXX.XXXX\n"},
+		{"Authorization: Bearer not.a.jwt\n", "Authorization: Bearer not.a.jwt\n"},
+		{"Authorization: Bearer " + payload + "." + payload + "." + signature + "\n", "Authorization: Bearer " + payload + "." + payload + "." + signature + "\n"},
+		{"Authorization: Basic dXNlcjpwYXNz\n", "Authorization: Basic dXNlcjpwYXNz\n"},
+	} {
+		got := string(cleanJWT([]byte(test.in), Auth[0]))
+		assert.Equal(t, test.want, got, test.in)
+	}
+}
+
+func TestPerHost(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Response body")
+	}))
+	defer ts.Close()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Other body")
+	}))
+	defer other.Close()
+	tsURL, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+
+	var lines []string
+	logf := func(format string, v ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, v...))
+	}
+
+	client := NewClient(&Options{
+		Flags: 0,
+		Logf:  logf,
+		PerHost: map[string]Options{
+			tsURL.Host: {
+				Flags: DumpBodies,
+			},
+		},
+	})
+
+	get := func(URL string) {
+		req, err := http.NewRequest("GET", URL, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "POTATO")
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		_, err = ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+	}
+
+	// Request to the configured host is dumped with the inherited Auth
+	lines = nil
+	get(ts.URL)
+	require.Equal(t, 8, len(lines))
+	assert.Contains(t, lines[2], "\nAuthorization: XXXX\r\n")
+	assert.Contains(t, lines[6], "Response body")
+
+	// Request to the other host uses the base Options
+	lines = nil
+	get(other.URL)
+	assert.Equal(t, 0, len(lines))
+}
+
+func TestPerHostOwnLogf(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	tsURL, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+
+	var recorder levelRecorder
+	var ctxLines, hostLines []string
+	client := NewClient(&Options{
+		LeveledLogger: &recorder,
+		LogfCtx: func(ctx context.Context, format string, v ...interface{}) {
+			ctxLines = append(ctxLines, fmt.Sprintf(format, v...))
+		},
+		PerHost: map[string]Options{
+			tsURL.Host: {
+				Flags: DumpHeaders,
+				Logf: func(format string, v ...interface{}) {
+					hostLines = append(hostLines, fmt.Sprintf(format, v...))
+				},
+			},
+		},
+	})
+	resp, err := client.Get(ts.URL)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	// The host's own Logf gets everything
+	assert.Equal(t, 8, len(hostLines))
+	assert.Equal(t, 0, len(recorder.lines))
+	assert.Equal(t, 0, len(ctxLines))
+}
+
+func TestAuthDefaults(t *testing.T) {
+	const in = "Authorization: AAAAAAAAA\nX-Auth-Token: AAAAAAAAA\n"
+
+	// nil Auth gets the defaults
+	transport := New(&Options{Auth: nil}, nil)
+	assert.Equal(t, Auth, transport.opt.Auth)
+	assert.Equal(t, "Authorization: XXXX\nX-Auth-Token: XXXX\n", string(transport.cleanAuths([]byte(in))))
+
+	// empty Auth redacts nothing
+	transport = New(&Options{Auth: [][]byte{}}, nil)
+	assert.NotNil(t, transport.opt.Auth)
+	assert.Equal(t, 0, len(transport.opt.Auth))
+	assert.Equal(t, in, string(transport.cleanAuths([]byte(in))))
+
+	// explicit Auth replaces the defaults
+	transport = New(&Options{Auth: [][]byte{[]byte("X-Auth-Token: ")}}, nil)
+	assert.Equal(t, "Authorization: AAAAAAAAA\nX-Auth-Token: XXXX\n", string(transport.cleanAuths([]byte(in))))
+}
+
+func TestTruncateBody(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		max  int64
+		want string
+	}{
+		{"", 4, ""},
+		{"GET / HTTP/1.1\r\n\r\n", 4, "GET / HTTP/1.1\r\n\r\n"},
+		{"PUT / HTTP/1.1\r\n\r\nBody", 0, "PUT / HTTP/1.1\r\n\r\nBody"},
+		{"PUT / HTTP/1.1\r\n\r\nBody", 4, "PUT / HTTP/1.1\r\n\r\nBody"},
+		{"PUT / HTTP/1.1\r\n\r\nBody text", 4, "PUT / HTTP/1.1\r\n\r\nBody\n... [5 bytes truncated]\n"},
+	} {
+		got := string(truncateBody([]byte(test.in), test.max))
+		assert.Equal(t, test.want, got, test.in)
+	}
+}
+
+func TestRequestBodyNotRead(t *testing.T) {
+	const requestBody = "Request text which the server never reads"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+	}))
+	defer ts.Close()
+
+	var lines []string
+	logf := func(format string, v ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, v...))
+	}
+
+	for _, test := range []struct {
+		name           string
+		maxReqBodySize int64
+		want           string
+	}{
+		{name: "Full", want: requestBody},
+		{name: "Truncated", maxReqBodySize: 12, want: requestBody[:12] + "\n... [29 bytes truncated]\n"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			client := NewClient(&Options{
+				Flags:          DumpRequests,
+				Logf:           logf,
+				MaxReqBodySize: test.maxReqBodySize,
+			})
+			lines = nil
+
+			// Use a plain io.Reader so there is no GetBody
+			body := &readCounter{Reader: strings.NewReader(requestBody)}
+			req, err := http.NewRequest("POST", ts.URL, body)
+			require.NoError(t, err)
+			require.Nil(t, req.GetBody)
+			req.ContentLength = int64(len(requestBody))
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+			require.NoError(t, resp.Body.Close())
+
+			// Check the body was logged in the request block
+			require.Equal(t, 9, len(lines))
+			assert.Equal(t, SeparatorReq, lines[0])
+			assert.Contains(t, lines[2], "Warning")
+			assert.True(t, strings.HasSuffix(lines[3], "\r\n\r\n"+test.want), lines[3])
+			assert.Equal(t, SeparatorReq, lines[4])
+		})
+	}
+}
+
+func TestCaller(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	var lines []string
+	logf := func(format string, v ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, v...))
+	}
+
+	for _, test := range []struct {
+		name       string
+		callerSkip int
+		want       string
+	}{
+		{name: "Direct", want: "from %s/debughttp_test.go:%d"},
+		{name: "Skip", callerSkip: 1, want: "from testing/testing.go:"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			client := NewClient(&Options{
+				Flags:      DumpHeaders,
+				Logf:       logf,
+				Caller:     true,
+				CallerSkip: test.callerSkip,
+			})
+			lines = nil
+			_, file, line, _ := runtime.Caller(0)
+			resp, err := client.Get(ts.URL)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+
+			require.Equal(t, 9, len(lines))
+			want := test.want
+			if strings.Contains(want, "%") {
+				want = fmt.Sprintf(want, path.Base(path.Dir(file)), line+1)
+			}
+			assert.Contains(t, lines[2], want)
+		})
+	}
+}
+
+func TestDirectionString(t *testing.T) {
+	assert.Equal(t, "request", DirectionRequest.String())
+	assert.Equal(t, "response", DirectionResponse.String())
+	assert.Equal(t, "Direction(17)", Direction(17).String())
+}
+
+func TestSeparatorFunc(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	var lines []string
+	client := NewClient(&Options{
+		Flags: DumpHeaders,
+		Logf: func(format string, v ...interface{}) {
+			lines = append(lines, fmt.Sprintf(format, v...))
+		},
+		SeparatorFunc: func(req *http.Request, dir Direction) string {
+			return fmt.Sprintf("--- %s %s ---", req.Method, dir)
+		},
+	})
+	resp, err := client.Get(ts.URL)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	require.Equal(t, 8, len(lines))
+	assert.Equal(t, "--- GET request ---", lines[0])
+	assert.Equal(t, "--- GET request ---", lines[3])
+	assert.Equal(t, "--- GET response ---", lines[4])
+	assert.Equal(t, "--- GET response ---", lines[7])
+}
+
+func TestRedirectSummary(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a":
+			http.Redirect(w, r, "/b?token=1", http.StatusFound)
+		case "/b":
+			http.Redirect(w, r, "/c", http.StatusMovedPermanently)
+		default:
+			_, _ = w.Write([]byte("done"))
+		}
+	}))
+	defer ts.Close()
+
+	client, capture := NewCaptureClient(&Options{
+		Flags:               DumpSummary,
+		RedirectSummary:     true,
+		RedactQueryPatterns: []*regexp.Regexp{regexp.MustCompile(`^token$`)},
+	})
+	resp, err := client.Get(ts.URL + "/a")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	// One summary per hop then the chain once at the end
+	lines := capture.Lines()
+	require.Equal(t, 4, len(lines))
+	assert.Contains(t, lines[0], "/a -> 302 Found")
+	assert.Contains(t, lines[1], "/b?token=xxxxx -> 301 Moved Permanently")
+	assert.Contains(t, lines[2], "/c -> 200 OK")
+	assert.True(t, strings.HasPrefix(lines[3], "redirect chain: GET /a -> 302 -> GET /b?token=xxxxx -> 301 -> GET /c -> 200 (req "), lines[3])
+
+	// Nothing extra without redirects
+	capture.Reset()
+	resp, err = client.Get(ts.URL + "/c")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, 1, len(capture.Lines()))
+}
+
+func TestRequestBodyNotes(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer ts.Close()
+
+	for _, test := range []struct {
+		name string
+		body func() io.Reader
+		set  func(req *http.Request) // change the request after it is made
+		want string
+	}{
+		{
+			name: "nil",
+			body: func() io.Reader { return nil },
+			want: noBodyNote,
+		},
+		{
+			name: "NoBody",
+			body: func() io.Reader { return http.NoBody },
+			want: noBodyNote,
+		},
+		{
+			// http.NewRequest turns this into http.NoBody
+			name: "EmptyBuffer",
+			body: func() io.Reader { return new(bytes.Buffer) },
+			want: noBodyNote,
+		},
+		{
+			name: "EmptyPresent",
+			body: func() io.Reader { return nil },
+			set: func(req *http.Request) {
+				req.Body = ioutil.NopCloser(new(bytes.Buffer))
+				req.GetBody = func() (io.ReadCloser, error) {
+					return ioutil.NopCloser(new(bytes.Buffer)), nil
+				}
+			},
+			want: emptyBodyNote,
+		},
+		{
+			name: "Body",
+			body: func() io.Reader { return strings.NewReader("body") },
+			want: "body",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			client, capture := NewCaptureClient(&Options{
+				Flags:            DumpBodies,
+				DumpQuotedBodies: true,
+			})
+			req, err := http.NewRequest("POST", ts.URL, test.body())
+			require.NoError(t, err)
+			if test.set != nil {
+				test.set(req)
+			}
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			lines := capture.Lines()
+			require.Equal(t, 8, len(lines))
+			if test.want == "body" {
+				assert.True(t, strings.HasSuffix(lines[2], "\r\n\r\n\"body\"\n"), lines[2])
+			} else {
+				assert.True(t, strings.HasSuffix(lines[2], "\r\n\r\n"+test.want+"\n"), lines[2])
+			}
+		})
+	}
+
+	// The notes aren't shown without the bodies
+	client, capture := NewCaptureClient(&Options{Flags: DumpHeaders})
+	resp, err := client.Get(ts.URL)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.NotContains(t, capture.String(), noBodyNote)
+}
+
+func TestPostProcess(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("response body"))
+	}))
+	defer ts.Close()
+
+	var dirs []Direction
+	client, capture := NewCaptureClient(&Options{
+		Flags: DumpBodies | DumpSummary,
+		PostProcess: func(block []byte, dir Direction) []byte {
+			dirs = append(dirs, dir)
+			assert.NotContains(t, string(block), "secret", "redacted first")
+			return bytes.ToUpper(block)
+		},
+	})
+	req, err := http.NewRequest("POST", ts.URL, strings.NewReader("request body"))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "secret")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	// Each block is processed with its separators and title and
+	// logged as one line
+	assert.Equal(t, []Direction{DirectionRequest, DirectionResponse}, dirs)
+	lines := capture.Lines()
+	require.Equal(t, 3, len(lines))
+	assert.True(t, strings.HasPrefix(lines[0], SeparatorReq+"\nHTTP REQUEST (REQ 0X"), lines[0])
+	assert.Contains(t, lines[0], "\nPOST / HTTP/1.1\r\n")
+	assert.Contains(t, lines[0], "\r\nAUTHORIZATION: XXXX\r\n")
+	assert.True(t, strings.HasSuffix(lines[0], "\r\n\r\nREQUEST BODY\n"+SeparatorReq), lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], SeparatorResp+"\nHTTP RESPONSE (REQ 0X"), lines[1])
+	assert.True(t, strings.HasSuffix(lines[1], "\r\n\r\nRESPONSE BODY\n"+SeparatorResp), lines[1])
+	// The summary isn't post processed
+	assert.True(t, strings.HasPrefix(lines[2], "POST http://"), lines[2])
+}
+
+func TestPostProcessDrop(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("response body"))
+	}))
+	defer ts.Close()
+
+	// Returning nil logs nothing at all for the block
+	calls := 0
+	client, capture := NewCaptureClient(&Options{
+		Flags: DumpBodies | DumpConn,
+		PostProcess: func(block []byte, dir Direction) []byte {
+			calls++
+			return nil
+		},
+	})
+	resp, err := client.Get(ts.URL)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, 0, len(capture.Lines()), capture.String())
+}
+
+func TestNoSeparatorsForLines(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	for _, test := range []struct {
+		flags DumpFlags
+		want  int
+	}{
+		{DumpSummary, 1},
+		{DumpCompact, 2},
+		{DumpSummary | DumpCompact, 3},
+		{DumpSummary | DumpLine, 9},
+	} {
+		var separators []string
+		client, capture := NewCaptureClient(&Options{
+			Flags: test.flags,
+			SeparatorFunc: func(req *http.Request, dir Direction) string {
+				separators = append(separators, dir.String())
+				return "---"
+			},
+		})
+		resp, err := client.Get(ts.URL)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		lines := capture.Lines()
+		assert.Equal(t, test.want, len(lines), test.flags)
+		if test.flags&dumpBlockFlags == 0 {
+			assert.NotContains(t, lines, "---")
+			assert.Empty(t, separators)
+		} else {
+			// The blocks keep their separators
+			assert.Equal(t, []string{"request", "request", "response", "response"}, separators)
+		}
+	}
+}
+
+func TestLineSeparators(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	client, capture := NewCaptureClient(&Options{
+		Flags:          DumpSummary | DumpCompact,
+		LineSeparators: true,
+	})
+	resp, err := client.Get(ts.URL)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	lines := capture.Lines()
+	require.Equal(t, 9, len(lines))
+	for _, i := range []int{0, 2} {
+		assert.Equal(t, SeparatorReq, lines[i])
+	}
+	for _, i := range []int{3, 5, 6, 8} {
+		assert.Equal(t, SeparatorResp, lines[i])
+	}
+	assert.True(t, strings.HasPrefix(lines[1], "> GET / "), lines[1])
+	assert.True(t, strings.HasPrefix(lines[4], "< 200 OK "), lines[4])
+	assert.True(t, strings.HasPrefix(lines[7], "GET http://"), lines[7])
+}
+
+func TestConnect(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodConnect, r.Method)
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		// Keep the "tunnel" open until the test has finished
+		<-done
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	var lines []string
+	client := NewClient(&Options{
+		Flags: DumpBodies,
+		Logf: func(format string, v ...interface{}) {
+			lines = append(lines, fmt.Sprintf(format, v...))
+		},
+	})
+	req, err := http.NewRequest(http.MethodConnect, ts.URL, nil)
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	require.Equal(t, 8, len(lines))
+	assert.Contains(t, lines[1], "HTTP CONNECT TUNNEL REQUEST")
+	assert.Contains(t, lines[2], "CONNECT "+ts.Listener.Addr().String()+" HTTP/1.1")
+	assert.Contains(t, lines[5], "HTTP CONNECT TUNNEL RESPONSE")
+	assert.Contains(t, lines[6], "200 OK")
+}
+
+func TestWebSocketUpgrade(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "websocket", r.Header.Get("Upgrade"))
+		conn, brw, err := w.(http.Hijacker).Hijack()
+		require.NoError(t, err)
+		defer func() { _ = conn.Close() }()
+		_, _ = brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		_ = brw.Flush()
+		// Echo one "frame" back to the client
+		line, err := brw.ReadString('\n')
+		if err == nil {
+			_, _ = brw.WriteString("echo " + line)
+			_ = brw.Flush()
+		}
+	}))
+	defer ts.Close()
+
+	var lines []string
+	client := NewClient(&Options{
+		Flags: DumpBodies | DumpSizes,
+		Logf: func(format string, v ...interface{}) {
+			lines = append(lines, fmt.Sprintf(format, v...))
+		},
+	})
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	require.Equal(t, 8, len(lines))
+	assert.Contains(t, lines[5], "HTTP RESPONSE")
+	assert.Contains(t, lines[5], "[websocket upgrade, frames not dumped]")
+	assert.Contains(t, lines[6], "101 Switching Protocols")
+	assert.Contains(t, lines[6], "Upgrade: websocket")
+
+	// The connection must still be usable
+	conn, ok := resp.Body.(io.ReadWriteCloser)
+	require.True(t, ok)
+	_, err = io.WriteString(conn, "hello\n")
+	require.NoError(t, err)
+	buf, err := ioutil.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "echo hello\n", string(buf))
+	require.NoError(t, conn.Close())
+	assert.Equal(t, 8, len(lines))
+}
+
+func TestVerbosityFlags(t *testing.T) {
+	for _, test := range []struct {
+		verbosity int
+		want      DumpFlags
+	}{
+		{-1, 0},
+		{0, 0},
+		{1, DumpSummary},
+		{2, DumpHeaders},
+		{3, DumpBodies},
+		{4, DumpBodies | DumpTiming | DumpTLS | DumpConn},
+		{5, DumpBodies | DumpTiming | DumpTLS | DumpConn},
+	} {
+		assert.Equal(t, test.want, verbosityFlags(test.verbosity), test.verbosity)
+	}
+
+	// Flags and Verbosity are ORed together
+	transport := New(&Options{Flags: DumpAuth, Verbosity: 2, AllowAuthDump: true}, nil)
+	assert.Equal(t, DumpAuth|DumpHeaders, transport.opt.Flags)
+}
+
+func TestVerbosity(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Response body")
+	}))
+	defer ts.Close()
+
+	var lines []string
+	logf := func(format string, v ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, v...))
+	}
+
+	get := func(verbosity int) {
+		transport := NewDefault(&Options{
+			Logf:      logf,
+			Verbosity: verbosity,
+		})
+		transport.TLSClientConfig = ts.Client().Transport.(*http.Transport).TLSClientConfig
+		client := &http.Client{Transport: transport}
+		lines = nil
+		resp, err := client.Get(ts.URL + "/path")
+		require.NoError(t, err)
+		_, err = ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+	}
+
+	t.Run("Summary", func(t *testing.T) {
+		get(1)
+		require.Equal(t, 1, len(lines))
+		assert.Contains(t, lines[0], "GET "+ts.URL+"/path -> 200 OK in ")
+	})
+
+	t.Run("Everything", func(t *testing.T) {
+		get(4)
+		require.Equal(t, 12, len(lines))
+		assert.Contains(t, lines[5], "HTTP RESPONSE")
+		assert.Contains(t, lines[6], "timing: round trip ")
+		assert.Contains(t, lines[6], " ttfb=")
+		assert.True(t, strings.HasPrefix(lines[7], "connection: local="), lines[7])
+		assert.Contains(t, lines[7], "remote="+ts.Listener.Addr().String())
+		assert.Contains(t, lines[8], "TLS: version=1.")
+		assert.Contains(t, lines[9], "Response body")
+		assert.True(t, strings.HasPrefix(lines[11], "HTTP RESPONSE TIMING ("), lines[11])
+		assert.Contains(t, lines[11], " total=")
+	})
+}
+
+// closeRecorder is an io.Closer which records when it was closed
+type closeRecorder struct {
+	name   string
+	closed *[]string
+	err    error
+}
+
+func (c closeRecorder) Close() error {
+	*c.closed = append(*c.closed, c.name)
+	return c.err
+}
+
+func TestClose(t *testing.T) {
+	// No-op when nothing needs closing
+	client := NewClient(nil)
+	assert.NoError(t, CloseClient(client))
+	assert.NoError(t, CloseClient(http.DefaultClient))
+
+	// Closers are closed in reverse order, once only
+	var closed []string
+	transport := NewDefault(nil)
+	errClose := errors.New("close failed")
+	transport.closers = append(transport.closers,
+		closeRecorder{name: "first", closed: &closed},
+		closeRecorder{name: "second", closed: &closed, err: errClose},
+	)
+	assert.Equal(t, errClose, transport.Close())
+	assert.Equal(t, []string{"second", "first"}, closed)
+	assert.NoError(t, transport.Close())
+	assert.Equal(t, []string{"second", "first"}, closed)
+
+	// Per host transports are closed too
+	closed = nil
+	transport = NewDefault(&Options{PerHost: map[string]Options{"example.com": {}}})
+	host := transport.perHost["example.com"]
+	host.closers = append(host.closers, closeRecorder{name: "host", closed: &closed})
+	assert.NoError(t, CloseClient(&http.Client{Transport: transport}))
+	assert.Equal(t, []string{"host"}, closed)
+}
+
+func TestFirstLine(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want string
+	}{
+		{"", ""},
+		{"GET / HTTP/1.1", "GET / HTTP/1.1"},
+		{"GET / HTTP/1.1\r\nHost: example.com\r\n\r\n", "GET / HTTP/1.1"},
+		{"HTTP/1.1 200 OK\nDate: now\n", "HTTP/1.1 200 OK"},
+	} {
+		assert.Equal(t, test.want, string(firstLine([]byte(test.in))), test.in)
+	}
+}
+
+func TestDumpLine(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Response body")
+	}))
+	defer ts.Close()
+
+	var lines []string
+	client := NewClient(&Options{
+		Flags: DumpLine,
+		Logf: func(format string, v ...interface{}) {
+			lines = append(lines, fmt.Sprintf(format, v...))
+		},
+	})
+	resp, err := client.Get(ts.URL + "/path?q=1")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	require.Equal(t, 8, len(lines))
+	assert.Equal(t, SeparatorReq, lines[0])
+	assert.Contains(t, lines[1], "HTTP REQUEST")
+	assert.Equal(t, "GET /path?q=1 HTTP/1.1", lines[2])
+	assert.Equal(t, SeparatorReq, lines[3])
+	assert.Equal(t, SeparatorResp, lines[4])
+	assert.Contains(t, lines[5], "HTTP RESPONSE")
+	assert.Equal(t, "HTTP/1.1 200 OK", lines[6])
+	assert.Equal(t, SeparatorResp, lines[7])
+}
+
+func TestLogfCtx(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	type traceKey struct{}
+	var lines []string
+	client := NewClient(&Options{
+		Flags: DumpHeaders,
+		Logf: func(format string, v ...interface{}) {
+			t.Error("Logf called when LogfCtx set")
+		},
+		LogfCtx: func(ctx context.Context, format string, v ...interface{}) {
+			trace, _ := ctx.Value(traceKey{}).(string)
+			lines = append(lines, trace+" "+fmt.Sprintf(format, v...))
+		},
+	})
+	ctx := context.WithValue(context.Background(), traceKey{}, "trace-17")
+	req, err := http.NewRequestWithContext(ctx, "GET", ts.URL, nil)
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	require.Equal(t, 8, len(lines))
+	for _, line := range lines {
+		assert.True(t, strings.HasPrefix(line, "trace-17 "), line)
+	}
+}
+
+func TestLimitHeaders(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		max  int
+		want string
+	}{
+		{"", 1, ""},
+		{"HTTP/1.1 200 OK", 1, "HTTP/1.1 200 OK"},
+		{"HTTP/1.1 200 OK\r\nA: 1\r\nB: 2\r\n\r\nbody", 0, "HTTP/1.1 200 OK\r\nA: 1\r\nB: 2\r\n\r\nbody"},
+		{"HTTP/1.1 200 OK\r\nA: 1\r\nB: 2\r\n\r\nbody", 2, "HTTP/1.1 200 OK\r\nA: 1\r\nB: 2\r\n\r\nbody"},
+		{"HTTP/1.1 200 OK\r\nA: 1\r\nB: 2\r\nC: 3\r\n\r\nbody\r\n\r\nmore", 1, "HTTP/1.1 200 OK\r\nA: 1\r\n... (2 more headers)\r\n\r\nbody\r\n\r\nmore"},
+		{"HTTP/1.1 200 OK\nA: 1\nB: 2\nC: 3\n", 2, "HTTP/1.1 200 OK\nA: 1\nB: 2\n... (1 more headers)\r\n"},
+	} {
+		got := string(limitHeaders([]byte(test.in), test.max))
+		assert.Equal(t, test.want, got, test.in)
+	}
+}
+
+func TestMaxHeaders(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 100; i++ {
+			w.Header().Add("Set-Cookie", fmt.Sprintf("cookie%d=%d", i, i))
+		}
+		fmt.Fprintln(w, "Response body")
+	}))
+	defer ts.Close()
+
+	var lines []string
+	client := NewClient(&Options{
+		Flags:      DumpBodies,
+		MaxHeaders: 3,
+		Logf: func(format string, v ...interface{}) {
+			lines = append(lines, fmt.Sprintf(format, v...))
+		},
+	})
+	resp, err := client.Get(ts.URL)
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	require.Equal(t, 8, len(lines))
+	dump := strings.Split(lines[6], "\r\n")
+	require.Equal(t, 7, len(dump), lines[6])
+	assert.Equal(t, "HTTP/1.1 200 OK", dump[0])
+	assert.Equal(t, "... (100 more headers)", dump[4])
+	assert.Equal(t, "", dump[5])
+	assert.Equal(t, "Response body\n", dump[6])
+}
+
+func TestAddAuth(t *testing.T) {
+	for _, test := range []struct {
+		names string
+		want  []string
+	}{
+		{"", []string{"Authorization: "}},
+		{" , ,", []string{"Authorization: "}},
+		{"X-Internal-Token", []string{"Authorization: ", "X-Internal-Token: "}},
+		{"x-internal-token, X-Session ,authorization,X-Session", []string{"Authorization: ", "X-Internal-Token: ", "X-Session: "}},
+	} {
+		auth := [][]byte{[]byte("Authorization: ")}
+		got := addAuth(auth, test.names)
+		var gotStrings []string
+		for _, authBuf := range got {
+			gotStrings = append(gotStrings, string(authBuf))
+		}
+		assert.Equal(t, test.want, gotStrings, test.names)
+		assert.Equal(t, 1, len(auth))
+	}
+}
+
+func TestRedactFromEnv(t *testing.T) {
+	const envVar = "DEBUGHTTP_TEST_REDACT"
+	t.Setenv(envVar, "X-Internal-Token,X-Session")
+	const in = "Authorization: AAAAAAAAA\nX-Internal-Token: AAAAAAAAA\nX-Session: AAAAAAAAA\n"
+
+	// Added to the defaults
+	transport := New(&Options{RedactFromEnv: envVar}, nil)
+	assert.Equal(t, "Authorization: XXXX\nX-Internal-Token: XXXX\nX-Session: XXXX\n", string(transport.cleanAuths([]byte(in))))
+	assert.Equal(t, len(Auth)+2, len(transport.opt.Auth))
+	assert.Equal(t, 3, len(Auth))
+
+	// Added even if Auth is empty
+	transport = New(&Options{Auth: [][]byte{}, RedactFromEnv: envVar}, nil)
+	assert.Equal(t, "Authorization: AAAAAAAAA\nX-Internal-Token: XXXX\nX-Session: XXXX\n", string(transport.cleanAuths([]byte(in))))
+
+	// Not read if not set
+	transport = New(&Options{}, nil)
+	assert.Equal(t, "Authorization: XXXX\nX-Internal-Token: AAAAAAAAA\nX-Session: AAAAAAAAA\n", string(transport.cleanAuths([]byte(in))))
+}
+
+func TestDumpCertChain(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	var lines []string
+	get := func(URL string) {
+		transport := NewDefault(&Options{
+			Flags:         DumpHeaders,
+			DumpCertChain: true,
+			Logf: func(format string, v ...interface{}) {
+				lines = append(lines, fmt.Sprintf(format, v...))
+			},
+		})
+		transport.TLSClientConfig = ts.Client().Transport.(*http.Transport).TLSClientConfig
+		client := &http.Client{Transport: transport}
+		lines = nil
+		resp, err := client.Get(URL)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+	}
+
+	// TLS shows the chain
+	get(ts.URL)
+	require.Equal(t, 9, len(lines))
+	cert := ts.Certificate()
+	sans := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	want := fmt.Sprintf(`cert[0]: subject="O=Acme Co" issuer="O=Acme Co" sans=[%s] not_after=%s`, strings.Join(sans, " "), cert.NotAfter.UTC().Format(time.RFC3339))
+	assert.Equal(t, want, lines[6])
+
+	// Plain HTTP doesn't
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer plain.Close()
+	get(plain.URL)
+	assert.Equal(t, 8, len(lines))
+}
+
+func TestAttempt(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	assert.Equal(t, 0, AttemptFromContext(context.Background()))
+	assert.Equal(t, 3, AttemptFromContext(WithAttempt(context.Background(), 3)))
+
+	var lines []string
+	logf := func(format string, v ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, v...))
+	}
+
+	for _, test := range []struct {
+		name        string
+		ctx         context.Context
+		header      string
+		attemptFunc func(req *http.Request) int
+		want        string
+	}{
+		{name: "None", ctx: context.Background(), want: ")"},
+		{name: "Context", ctx: WithAttempt(context.Background(), 3), want: ", attempt 3)"},
+		{
+			name:   "Header",
+			ctx:    context.Background(),
+			header: "2",
+			attemptFunc: func(req *http.Request) int {
+				attempt, _ := strconv.Atoi(req.Header.Get("X-Retry-Attempt"))
+				return attempt
+			},
+			want: ", attempt 2)",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			client := NewClient(&Options{
+				Flags:       DumpHeaders | DumpSummary,
+				Logf:        logf,
+				AttemptFunc: test.attemptFunc,
+			})
+			lines = nil
+			req, err := http.NewRequestWithContext(test.ctx, "GET", ts.URL, nil)
+			require.NoError(t, err)
+			if test.header != "" {
+				req.Header.Set("X-Retry-Attempt", test.header)
+			}
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+
+			require.Equal(t, 9, len(lines))
+			want := fmt.Sprintf("(req %p%s", req, test.want)
+			assert.Equal(t, "HTTP REQUEST "+want, lines[1])
+			assert.Equal(t, "HTTP RESPONSE "+want, lines[5])
+			assert.True(t, strings.HasSuffix(lines[8], want), lines[8])
+		})
+	}
+}
+
+func TestOperationFunc(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	var lines []string
+	client := NewClient(&Options{
+		Flags: DumpHeaders,
+		Logf: func(format string, v ...interface{}) {
+			lines = append(lines, fmt.Sprintf(format, v...))
+		},
+		OperationFunc: func(req *http.Request) string {
+			if req.Method == http.MethodGet && req.URL.Path != "/" {
+				return "GetObject"
+			}
+			return ""
+		},
+	})
+	for _, test := range []struct {
+		path string
+		want string
+	}{
+		{"/", ""},
+		{"/bucket/key", " GetObject"},
+	} {
+		lines = nil
+		req, err := http.NewRequest(http.MethodGet, ts.URL+test.path, nil)
+		require.NoError(t, err)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		require.Equal(t, 8, len(lines))
+		want := fmt.Sprintf("%s (req %p)", test.want, req)
+		assert.Equal(t, "HTTP REQUEST"+want, lines[1])
+		assert.Equal(t, "HTTP RESPONSE"+want, lines[5])
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	for _, test := range []struct {
+		in   int64
+		want string
+	}{
+		{-1, "?"},
+		{0, "0B"},
+		{12, "12B"},
+		{999, "999B"},
+		{1000, "1.0kB"},
+		{1100, "1.1kB"},
+		{2500000, "2.5MB"},
+		{3 * 1000 * 1000 * 1000, "3.0GB"},
+	} {
+		assert.Equal(t, test.want, formatSize(test.in), test.in)
+	}
+}
+
+func TestDumpCompact(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-One", "1")
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write(bytes.Repeat([]byte("x"), 1100))
+	}))
+	defer ts.Close()
+
+	var lines []string
+	client := NewClient(&Options{
+		Flags: DumpCompact,
+		Logf: func(format string, v ...interface{}) {
+			lines = append(lines, fmt.Sprintf(format, v...))
+		},
+	})
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/path?a=b", strings.NewReader("Request body"))
+	require.NoError(t, err)
+	req.Header.Set("X-One", "1")
+	req.Header.Add("X-Two", "2")
+	req.Header.Add("X-Two", "3")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	require.Equal(t, 2, len(lines))
+	id := fmt.Sprintf("(req %p)", req)
+	assert.Equal(t, "> PUT /path?a=b {3 headers} {12B body} "+id, lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], "< 200 OK {4 headers} "), lines[1])
+	assert.True(t, strings.HasSuffix(lines[1], "s {1.1kB body} "+id), lines[1])
+
+	// Failed requests
+	lines = nil
+	req, err = http.NewRequest(http.MethodGet, "http://127.0.0.1:1/", nil)
+	require.NoError(t, err)
+	_, err = client.Do(req)
+	require.Error(t, err)
+	require.Equal(t, 2, len(lines))
+	assert.Equal(t, "> GET / {0 headers} {0B body} "+fmt.Sprintf("(req %p)", req), lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], "< failed: "), lines[1])
+}
+
+func TestTitles(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	for _, test := range []struct {
+		name      string
+		reqTitle  string
+		respTitle string
+		wantReq   string
+		wantResp  string
+	}{
+		{name: "Default", wantReq: "HTTP REQUEST", wantResp: "HTTP RESPONSE"},
+		{name: "Custom", reqTitle: "UPSTREAM A REQUEST", respTitle: "UPSTREAM A RESPONSE", wantReq: "UPSTREAM A REQUEST", wantResp: "UPSTREAM A RESPONSE"},
+		{name: "RespOnly", respTitle: "REPLY", wantReq: "HTTP REQUEST", wantResp: "REPLY"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var lines []string
+			client := NewClient(&Options{
+				Flags:     DumpHeaders,
+				ReqTitle:  test.reqTitle,
+				RespTitle: test.respTitle,
+				Logf: func(format string, v ...interface{}) {
+					lines = append(lines, fmt.Sprintf(format, v...))
+				},
+			})
+			req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+			require.NoError(t, err)
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+
+			require.Equal(t, 8, len(lines))
+			assert.Equal(t, fmt.Sprintf("%s (req %p)", test.wantReq, req), lines[1])
+			assert.Equal(t, fmt.Sprintf("%s (req %p)", test.wantResp, req), lines[5])
+		})
+	}
+}
+
+func TestShowProto(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		http2 bool
+		want  string
+	}{
+		{"HTTP1", false, "HTTP/1.1"},
+		{"HTTP2", true, "HTTP/2.0"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			ts.EnableHTTP2 = test.http2
+			ts.StartTLS()
+			defer ts.Close()
+
+			var lines []string
+			client := &http.Client{Transport: New(&Options{
+				Flags:     DumpHeaders,
+				ShowProto: true,
+				Logf: func(format string, v ...interface{}) {
+					lines = append(lines, fmt.Sprintf(format, v...))
+				},
+			}, ts.Client().Transport.(*http.Transport))}
+			req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+			require.NoError(t, err)
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+
+			require.Equal(t, 8, len(lines))
+			assert.Equal(t, fmt.Sprintf("HTTP RESPONSE (req %p) [protocol %s]", req, test.want), lines[5])
+		})
+	}
+}
+
+func TestOptions(t *testing.T) {
+	transport := New(&Options{Flags: DumpHeaders, Verbosity: 3}, nil)
+	opt := transport.Options()
+
+	// Defaults are filled in
+	assert.Equal(t, DumpHeaders|DumpBodies, opt.Flags)
+	assert.NotNil(t, opt.Logf)
+	assert.Equal(t, Auth, opt.Auth)
+	assert.Equal(t, len(PIIPatterns), len(opt.PIIPatterns))
+	assert.Equal(t, len(BodyFormatters), len(opt.BodyFormatters))
+
+	// Changing the copy doesn't change the Transport
+	opt.Auth[0][0] = 'Z'
+	opt.Auth = append(opt.Auth[:1], []byte("X-Potato: "))
+	opt.PIIPatterns[0].Name = "potato"
+	delete(opt.BodyFormatters, "application/json")
+	assert.Equal(t, Auth, transport.Options().Auth)
+	assert.Equal(t, byte('A'), Auth[0][0])
+	assert.Equal(t, "email", transport.Options().PIIPatterns[0].Name)
+	assert.Contains(t, transport.Options().BodyFormatters, "application/json")
+}
+
+func TestStats(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Response body")
+	}))
+	defer ts.Close()
+
+	var lines []string
+	transport := NewDefault(&Options{
+		Flags: DumpHeaders | DumpConn,
+		Logf: func(format string, v ...interface{}) {
+			lines = append(lines, fmt.Sprintf(format, v...))
+		},
+	})
+	client := &http.Client{Transport: transport}
+	assert.Equal(t, Stats{}, transport.Stats())
+
+	for i := 0; i < 3; i++ {
+		lines = nil
+		resp, err := client.Get(ts.URL)
+		require.NoError(t, err)
+		_, err = ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, 9, len(lines))
+		assert.Contains(t, lines[6], fmt.Sprintf(" reused=%v idle=", i > 0))
+	}
+	assert.Equal(t, Stats{Requests: 3, NewConns: 1, ReusedConns: 2}, transport.Stats())
+}
+
+func TestIDPrefix(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+
+	// The PerHost Transport shares the sequence numbers
+	client, capture := NewCaptureClient(&Options{
+		Flags:    DumpSummary,
+		IDPrefix: "worker3-",
+		IDStart:  17,
+		PerHost: map[string]Options{
+			"other.example.com": {Flags: DumpSummary},
+			u.Host:              {Flags: DumpSummary},
+		},
+	})
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(ts.URL)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+	}
+	lines := capture.Lines()
+	require.Equal(t, 3, len(lines))
+	for i, line := range lines {
+		assert.True(t, strings.HasSuffix(line, fmt.Sprintf(" (req worker3-%d)", 17+i)), line)
+	}
+
+	// The default starts at 1
+	client, capture = NewCaptureClient(&Options{
+		Flags:    DumpSummary,
+		IDPrefix: "w-",
+	})
+	resp, err := client.Get(ts.URL)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	lines = capture.Lines()
+	require.Equal(t, 1, len(lines))
+	assert.True(t, strings.HasSuffix(lines[0], " (req w-1)"), lines[0])
+}
+
+func TestTransportConfig(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	var dials int32
+	transport := NewDefault(&Options{
+		Flags: DumpSummary,
+		Logf:  func(format string, v ...interface{}) {},
+		TransportConfig: func(t *http.Transport) {
+			t.MaxIdleConnsPerHost = 7
+			dialer := &net.Dialer{}
+			t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+				atomic.AddInt32(&dials, 1)
+				return dialer.DialContext(ctx, network, addr)
+			}
+		},
+	})
+	assert.Equal(t, 7, transport.MaxIdleConnsPerHost)
+	assert.NotEqual(t, 7, http.DefaultTransport.(*http.Transport).MaxIdleConnsPerHost)
+
+	client := &http.Client{Transport: transport}
+	resp, err := client.Get(ts.URL)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&dials))
+}
+
+func TestSetClock(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	var lines []string
+	transport := NewDefault(&Options{
+		Flags: DumpSummary,
+		Logf: func(format string, v ...interface{}) {
+			lines = append(lines, fmt.Sprintf(format, v...))
+		},
+		Sinks: []Sink{{
+			Flags:  DumpHeaders,
+			Format: FormatLogfmt,
+			Logf: func(format string, v ...interface{}) {
+				lines = append(lines, fmt.Sprintf(format, v...))
+			},
+		}},
+	})
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	transport.SetClock(func() time.Time {
+		now = now.Add(1500 * time.Millisecond)
+		return now
+	})
+	client := &http.Client{Transport: transport}
+	resp, err := client.Get(ts.URL)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, 2, len(lines))
+	assert.Contains(t, lines[0], " in 1.5s ")
+	assert.Contains(t, lines[1], "ts=2020-01-02T03:04:06.5Z ")
+	assert.Contains(t, lines[1], " dur=1.5s")
+
+	// nil resets the clock
+	transport.SetClock(nil)
+	lines = nil
+	resp, err = client.Get(ts.URL)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, 2, len(lines))
+	assert.NotContains(t, lines[0], " in 1.5s ")
+}
+
+func TestWithFlags(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Response body")
+	}))
+	defer ts.Close()
+
+	var lines []string
+	transport := NewDefault(&Options{
+		Flags: 0,
+		Logf: func(format string, v ...interface{}) {
+			lines = append(lines, fmt.Sprintf(format, v...))
+		},
+	})
+	client := &http.Client{Transport: transport}
+	get := func(ctx context.Context) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, ts.URL, strings.NewReader("Request body"))
+		require.NoError(t, err)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, "Response body", string(body))
+	}
+
+	assert.Equal(t, DumpFlags(0), FlagsFromContext(context.Background()))
+	get(context.Background())
+	assert.Equal(t, 0, len(lines))
+
+	ctx := WithFlags(context.Background(), DumpBodies)
+	assert.Equal(t, DumpBodies, FlagsFromContext(ctx))
+	get(ctx)
+	require.Equal(t, 8, len(lines))
+	assert.True(t, strings.HasSuffix(lines[2], "\r\n\r\nRequest body"), lines[2])
+	assert.True(t, strings.HasSuffix(lines[6], "\r\n\r\nResponse body"), lines[6])
+
+	lines = nil
+	get(context.Background())
+	assert.Equal(t, 0, len(lines))
+	assert.Equal(t, int64(3), transport.Stats().Requests)
+}
+
+func TestTargetAddr(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want string
+	}{
+		{"http://example.com/", "example.com:80"},
+		{"https://example.com/", "example.com:443"},
+		{"https://example.com:8443/", "example.com:8443"},
+		{"http://[::1]/", "[::1]:80"},
+		{"https://[::1]:8443/path", "[::1]:8443"},
+		{"wss://[fe80::1%25eth0]/", "[fe80::1%eth0]:443"},
+	} {
+		u, err := url.Parse(test.in)
+		require.NoError(t, err)
+		assert.Equal(t, test.want, targetAddr(u), test.in)
+	}
+}
+
+func TestShowRemoteAddr(t *testing.T) {
+	// A proxy which answers every request itself
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "[::1]:8443", r.Host)
+	}))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	require.NoError(t, err)
+
+	var lines []string
+	var events []Event
+	transport := NewDefault(&Options{
+		Flags:          DumpSummary | DumpCompact,
+		ShowRemoteAddr: true,
+		Logf: func(format string, v ...interface{}) {
+			lines = append(lines, fmt.Sprintf(format, v...))
+		},
+		OnEvent: func(ev Event) {
+			events = append(events, ev)
+		},
+	})
+	transport.Proxy = http.ProxyURL(proxyURL)
+	client := &http.Client{Transport: transport}
+	resp, err := client.Get("http://[::1]:8443/path")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	label := " target=[::1]:8443 remote=" + proxy.Listener.Addr().String() + " "
+	require.Equal(t, 3, len(lines))
+	assert.Contains(t, lines[1], label)
+	assert.True(t, strings.HasPrefix(lines[2], "GET http://[::1]:8443/path -> 200 OK in "), lines[2])
+	assert.Contains(t, lines[2], label)
+	require.Equal(t, 2, len(events))
+	assert.Equal(t, "[::1]:8443", events[0].Target)
+	assert.Equal(t, "", events[0].Remote)
+	assert.Equal(t, "[::1]:8443", events[1].Target)
+	assert.Equal(t, proxy.Listener.Addr().String(), events[1].Remote)
+
+	// Not shown unless asked for
+	lines = nil
+	transport.opt.ShowRemoteAddr = false
+	resp, err = client.Get("http://[::1]:8443/path")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, 3, len(lines))
+	assert.NotContains(t, lines[2], "remote=")
+}
+
+func TestNoResponse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Reply", "yes")
+		fmt.Fprint(w, "Response body")
+	}))
+	defer ts.Close()
+
+	var lines []string
+	client := NewClient(&Options{
+		Flags:      DumpBodies,
+		NoResponse: true,
+		Logf: func(format string, v ...interface{}) {
+			lines = append(lines, fmt.Sprintf(format, v...))
+		},
+	})
+	resp, err := client.Post(ts.URL, "text/plain", strings.NewReader("Request body"))
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, "Response body", string(body))
+	assert.Equal(t, "yes", resp.Header.Get("X-Reply"))
+
+	require.Equal(t, 4, len(lines))
+	assert.Equal(t, SeparatorReq, lines[0])
+	assert.Contains(t, lines[1], "HTTP REQUEST")
+	assert.True(t, strings.HasSuffix(lines[2], "\r\n\r\nRequest body"), lines[2])
+	assert.Equal(t, SeparatorReq, lines[3])
+	for _, line := range lines {
+		assert.NotEqual(t, SeparatorResp, line)
+		assert.NotContains(t, line, "HTTP RESPONSE")
+	}
+}
+
+func TestNoRequest(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "Request body", string(body))
+		fmt.Fprint(w, "Response body")
+	}))
+	defer ts.Close()
+
+	for _, tee := range []bool{false, true} {
+		t.Run(fmt.Sprintf("TeeRequestBody=%v", tee), func(t *testing.T) {
+			var lines []string
+			client := NewClient(&Options{
+				Flags:          DumpBodies,
+				NoRequest:      true,
+				TeeRequestBody: tee,
+				Logf: func(format string, v ...interface{}) {
+					lines = append(lines, fmt.Sprintf(format, v...))
+				},
+			})
+			// Not replayable so TeeRequestBody would capture it
+			req, err := http.NewRequest(http.MethodPost, ts.URL, ioutil.NopCloser(strings.NewReader("Request body")))
+			require.NoError(t, err)
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			body, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			assert.Equal(t, "Response body", string(body))
+
+			require.Equal(t, 4, len(lines))
+			assert.Equal(t, SeparatorResp, lines[0])
+			assert.Contains(t, lines[1], "HTTP RESPONSE")
+			assert.True(t, strings.HasSuffix(lines[2], "\r\n\r\nResponse body"), lines[2])
+			assert.Equal(t, SeparatorResp, lines[3])
+			for _, line := range lines {
+				assert.NotContains(t, line, "HTTP REQUEST")
+				assert.NotContains(t, line, "Request body")
+			}
+		})
+	}
+}
+
+func TestEncodingNote(t *testing.T) {
+	body := strings.Repeat("Response body ", 100)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			fmt.Fprint(w, body)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		fmt.Fprint(zw, body)
+		require.NoError(t, zw.Close())
+	}))
+	defer ts.Close()
+
+	for _, test := range []struct {
+		name           string
+		acceptEncoding string
+		regzip         bool
+		wantTitle      string
+		wantPlain      bool
+		wantGzipped    bool
+	}{
+		{name: "Auto", wantTitle: " [auto-decompressed]", wantPlain: true},
+		{name: "AutoRegzip", regzip: true, wantTitle: " [auto-decompressed]", wantPlain: true, wantGzipped: true},
+		{name: "Caller", acceptEncoding: "gzip", regzip: true, wantTitle: " [caller requested gzip, not decompressed]"},
+		{name: "Identity", acceptEncoding: "identity", wantPlain: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var lines []string
+			client := NewClient(&Options{
+				Flags:        DumpBodies,
+				RegzipBodies: test.regzip,
+				Logf: func(format string, v ...interface{}) {
+					lines = append(lines, fmt.Sprintf(format, v...))
+				},
+			})
+			req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+			require.NoError(t, err)
+			if test.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", test.acceptEncoding)
+			}
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			_, err = ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+
+			require.Equal(t, 8, len(lines))
+			assert.Equal(t, fmt.Sprintf("HTTP RESPONSE (req %p)", req)+test.wantTitle, lines[5])
+			assert.Equal(t, test.wantPlain, strings.Contains(lines[6], body), lines[6])
+			assert.Equal(t, test.wantGzipped, regexp.MustCompile(`\n\[body is \d+ bytes gzipped\]\n$`).MatchString(lines[6]), lines[6])
+		})
+	}
+}
+
+// levelRecorder is a Logger which records the lines prefixed with
+// their level
+type levelRecorder struct {
+	lines []string
+}
+
+func (r *levelRecorder) Debugf(format string, v ...interface{}) {
+	r.lines = append(r.lines, "DEBUG "+fmt.Sprintf(format, v...))
+}
+
+func (r *levelRecorder) Warnf(format string, v ...interface{}) {
+	r.lines = append(r.lines, "WARN "+fmt.Sprintf(format, v...))
+}
+
+func (r *levelRecorder) Errorf(format string, v ...interface{}) {
+	r.lines = append(r.lines, "ERROR "+fmt.Sprintf(format, v...))
+}
+
+func TestLeveledLogger(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	var logfLines []string
+	var recorder levelRecorder
+	client := NewClient(&Options{
+		Flags:         DumpHeaders | DumpSummary,
+		LeveledLogger: &recorder,
+		Logf: func(format string, v ...interface{}) {
+			logfLines = append(logfLines, fmt.Sprintf(format, v...))
+		},
+	})
+
+	resp, err := client.Get(ts.URL)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, 9, len(recorder.lines))
+	for _, line := range recorder.lines {
+		assert.True(t, strings.HasPrefix(line, "DEBUG "), line)
+	}
+
+	recorder.lines = nil
+	_, err = client.Get("http://127.0.0.1:1/")
+	require.Error(t, err)
+	require.Equal(t, 9, len(recorder.lines))
+	assert.True(t, strings.HasPrefix(recorder.lines[3], "DEBUG "), recorder.lines[3])
+	assert.True(t, strings.HasPrefix(recorder.lines[6], "ERROR HTTP request failed: "), recorder.lines[6])
+	assert.True(t, strings.HasPrefix(recorder.lines[8], "ERROR GET http://127.0.0.1:1/ -> failed: "), recorder.lines[8])
+
+	assert.Equal(t, 0, len(logfLines))
+}
+
+func TestErrorStatusFunc(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+		w.WriteHeader(code)
+	}))
+	defer ts.Close()
+
+	for _, test := range []struct {
+		name            string
+		errorStatusFunc func(code int) bool
+		want            map[int]string
+	}{
+		{
+			name: "Default",
+			want: map[int]string{200: "DEBUG", 302: "DEBUG", 404: "WARN", 500: "WARN"},
+		},
+		{
+			name: "Custom",
+			errorStatusFunc: func(code int) bool {
+				return code >= 300 && code != http.StatusNotFound
+			},
+			want: map[int]string{200: "DEBUG", 302: "WARN", 404: "DEBUG", 500: "WARN"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var recorder levelRecorder
+			client := NewClient(&Options{
+				Flags:           DumpSummary | DumpCompact,
+				LeveledLogger:   &recorder,
+				ErrorStatusFunc: test.errorStatusFunc,
+			})
+			client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			}
+			for code, want := range test.want {
+				recorder.lines = nil
+				resp, err := client.Get(ts.URL + "/" + strconv.Itoa(code))
+				require.NoError(t, err)
+				require.NoError(t, resp.Body.Close())
+				require.Equal(t, 3, len(recorder.lines))
+				assert.True(t, strings.HasPrefix(recorder.lines[0], "DEBUG > GET "), recorder.lines[0])
+				assert.True(t, strings.HasPrefix(recorder.lines[1], want+" < "+strconv.Itoa(code)+" "), recorder.lines[1])
+				assert.True(t, strings.HasPrefix(recorder.lines[2], want+" GET "), recorder.lines[2])
+			}
+		})
+	}
+}
+
+func TestMaxConcurrentDumps(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var releaseOnce sync.Once
+	unblock := func() { releaseOnce.Do(func() { close(release) }) }
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = ioutil.ReadAll(r.Body)
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+		fmt.Fprint(w, "Response body")
+	}))
+	defer ts.Close()
+	defer unblock()
+
+	client, capture := NewCaptureClient(&Options{
+		Flags:              DumpBodies,
+		MaxConcurrentDumps: 1,
+	})
+	post := func(path string) {
+		resp, err := client.Post(ts.URL+path, "text/plain", strings.NewReader("Request body"))
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, "Response body", string(body))
+	}
+
+	// The slow request holds the only dump slot so the fast one is
+	// dumped without its bodies but isn't delayed
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		post("/slow")
+	}()
+	<-started
+	post("/fast")
+	lines := capture.Lines()
+	require.Equal(t, 12, len(lines))
+	assert.True(t, strings.HasSuffix(lines[6], "\r\n\r\n"+dumpLimitNote+"\n"), lines[6])
+	assert.True(t, strings.HasSuffix(lines[10], "\r\n\r\n"+dumpLimitNote+"\n"), lines[10])
+	unblock()
+	<-done
+	lines = capture.Lines()
+	require.Equal(t, 16, len(lines))
+	assert.True(t, strings.HasSuffix(lines[2], "\r\n\r\nRequest body"), lines[2])
+	assert.True(t, strings.HasSuffix(lines[14], "\r\n\r\nResponse body"), lines[14])
+
+	// The slot is released afterwards
+	capture.Reset()
+	post("/fast")
+	lines = capture.Lines()
+	require.Equal(t, 8, len(lines))
+	assert.True(t, strings.HasSuffix(lines[2], "\r\n\r\nRequest body"), lines[2])
+	assert.True(t, strings.HasSuffix(lines[6], "\r\n\r\nResponse body"), lines[6])
+}
+
+func BenchmarkMaxConcurrentDumps(b *testing.B) {
+	body := strings.Repeat("x", 64*1024)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(ioutil.Discard, r.Body)
+		fmt.Fprint(w, body)
+	}))
+	defer ts.Close()
+
+	for _, limit := range []int{0, 1, 4} {
+		b.Run(fmt.Sprintf("Limit=%d", limit), func(b *testing.B) {
+			transport := NewDefault(&Options{
+				Flags:              DumpBodies,
+				MaxConcurrentDumps: limit,
+				Logf:               func(format string, v ...interface{}) {},
+			})
+			transport.MaxIdleConnsPerHost = 64
+			client := &http.Client{Transport: transport}
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					resp, err := client.Post(ts.URL, "text/plain", strings.NewReader(body))
+					if err != nil {
+						b.Fatal(err)
+					}
+					_, _ = io.Copy(ioutil.Discard, resp.Body)
+					_ = resp.Body.Close()
+				}
+			})
+		})
+	}
+}
+
+func TestDumpStruct(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Connection", "close")
+		fmt.Fprint(w, "Response body")
+	}))
+	defer ts.Close()
+
+	var lines []string
+	client := NewClient(&Options{
+		Flags: DumpHeaders | DumpStruct,
+		Logf: func(format string, v ...interface{}) {
+			lines = append(lines, fmt.Sprintf(format, v...))
+		},
+	})
+	req, err := http.NewRequest(http.MethodPost, ts.URL, strings.NewReader("Request body"))
+	require.NoError(t, err)
+	req.Host = "example.com"
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	require.Equal(t, 10, len(lines))
+	assert.Equal(t, `request: Proto=HTTP/1.1 Host="example.com" RemoteAddr="" ContentLength=12 TransferEncoding=[] Close=false GetBody=true`, lines[2])
+	assert.Equal(t, `response: Proto=HTTP/1.1 ContentLength=13 TransferEncoding=[] Uncompressed=false Close=true Trailer=false`, lines[7])
+}
+
+// roundTripperFunc is an http.RoundTripper which isn't an
+// *http.Transport
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// bearerTransport stands in for oauth2.Transport, fetching a token
+// with client the first time it is used and adding it to the requests
+type bearerTransport struct {
+	tokenURL string
+	client   *http.Client
+	base     http.RoundTripper
+	token    string
+}
+
+// RoundTrip implements the RoundTripper interface.
+func (b *bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if b.token == "" {
+		resp, err := b.client.PostForm(b.tokenURL, url.Values{"grant_type": {"refresh_token"}})
+		if err != nil {
+			return nil, err
+		}
+		token, err := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		b.token = string(token)
+	}
+	reqCopy := req.Clone(req.Context())
+	reqCopy.Header.Set("Authorization", "Bearer "+b.token)
+	return b.base.RoundTrip(reqCopy)
+}
+
+func TestOAuth2Layering(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			fmt.Fprint(w, "secret-token")
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer ts.Close()
+
+	get := func(client *http.Client) {
+		resp, err := client.Get(ts.URL + "/api")
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	t.Run("Beneath", func(t *testing.T) {
+		// The same client refreshes the token and is the base
+		logged, capture := NewCaptureClient(&Options{Flags: DumpHeaders | DumpSummary})
+		client := &http.Client{Transport: &bearerTransport{
+			tokenURL: ts.URL + "/token",
+			client:   logged,
+			base:     logged.Transport,
+		}}
+		get(client)
+		out := capture.String()
+		assert.NotContains(t, out, "secret-token")
+		assert.Contains(t, out, "POST "+ts.URL+"/token -> 200 OK")
+		assert.Contains(t, out, "GET /api HTTP/1.1\r\n")
+		assert.Contains(t, out, "\r\nAuthorization: XXXX\r\n")
+	})
+
+	t.Run("Above", func(t *testing.T) {
+		// Wrapping the oauth2 client shows the requests as made
+		var capture Capture
+		client := WrapClient(&Options{Flags: DumpHeaders, Logf: capture.Logf}, &http.Client{Transport: &bearerTransport{
+			tokenURL: ts.URL + "/token",
+			client:   http.DefaultClient,
+			base:     http.DefaultTransport,
+		}})
+		get(client)
+		out := capture.String()
+		assert.NotContains(t, out, "/token")
+		assert.Contains(t, out, "GET /api HTTP/1.1\r\n")
+		assert.NotContains(t, out, "Authorization")
+		assert.Contains(t, out, "HTTP/1.1 200 OK\r\n")
+	})
+}
+
+func TestWrapClient(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Response body")
+	}))
+	defer ts.Close()
+
+	var lines []string
+	opt := &Options{
+		Flags: DumpHeaders,
+		Logf: func(format string, v ...interface{}) {
+			lines = append(lines, fmt.Sprintf(format, v...))
+		},
+	}
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	checkRedirect := func(req *http.Request, via []*http.Request) error { return nil }
+	roundTrips := 0
+	rt := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		roundTrips++
+		return http.DefaultTransport.RoundTrip(req)
+	})
+
+	for _, test := range []struct {
+		name   string
+		client *http.Client
+		want   http.RoundTripper
+	}{
+		{name: "Nil"},
+		{name: "NilTransport", client: &http.Client{Jar: jar}, want: http.DefaultTransport},
+		{name: "Transport", client: &http.Client{Transport: ts.Client().Transport}, want: ts.Client().Transport},
+		{
+			name:   "RoundTripper",
+			client: &http.Client{Transport: rt, Jar: jar, Timeout: time.Minute, CheckRedirect: checkRedirect},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			lines = nil
+			roundTrips = 0
+			client := WrapClient(opt, test.client)
+			transport, ok := client.Transport.(*Transport)
+			require.True(t, ok)
+			if test.client != nil {
+				assert.Equal(t, test.client.Jar, client.Jar)
+				assert.Equal(t, test.client.Timeout, client.Timeout)
+				assert.Equal(t, test.client.CheckRedirect == nil, client.CheckRedirect == nil)
+				// The original client is unchanged
+				_, changed := test.client.Transport.(*Transport)
+				assert.False(t, changed)
+			}
+			if test.want != nil {
+				assert.Equal(t, test.want, transport.next)
+				assert.Equal(t, test.want, transport.Transport)
+			}
+
+			resp, err := client.Get(ts.URL)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			assert.Equal(t, 8, len(lines))
+			if test.name == "RoundTripper" {
+				assert.Equal(t, 1, roundTrips)
+				assert.Nil(t, transport.Transport)
+				// Doesn't panic with no *http.Transport
+				client.CloseIdleConnections()
+			}
+		})
+	}
+}
+
+// timeoutError is a net.Error which has timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestDescribeError(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want string
+	}{
+		{errors.New("boom"), "boom"},
+		{context.Canceled, "request cancelled after 1.5s: context canceled"},
+		{fmt.Errorf("wrapped: %w", context.DeadlineExceeded), "deadline exceeded after 1.5s: wrapped: context deadline exceeded"},
+		{&url.Error{Op: "Get", URL: "http://example.com/", Err: timeoutError{}}, `deadline exceeded after 1.5s: Get "http://example.com/": i/o timeout`},
+	} {
+		assert.Equal(t, test.want, describeError(test.err, 1500*time.Millisecond+100*time.Microsecond), test.want)
+	}
+}
+
+func TestCancelledRequest(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	var lines []string
+	client := NewClient(&Options{
+		Flags: DumpHeaders,
+		Logf: func(format string, v ...interface{}) {
+			lines = append(lines, fmt.Sprintf(format, v...))
+		},
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		lines = nil
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, "GET", ts.URL, nil)
+		require.NoError(t, err)
+		_, err = client.Do(req)
+		require.Error(t, err)
+		require.Equal(t, 8, len(lines))
+		assert.Contains(t, lines[6], "HTTP request failed: deadline exceeded after ")
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		lines = nil
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			cancel()
+		}()
+		req, err := http.NewRequestWithContext(ctx, "GET", ts.URL, nil)
+		require.NoError(t, err)
+		_, err = client.Do(req)
+		require.Error(t, err)
+		require.Equal(t, 8, len(lines))
+		assert.Contains(t, lines[6], "HTTP request failed: request cancelled after ")
+	})
+}
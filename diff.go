@@ -0,0 +1,150 @@
+package debughttp
+
+import (
+	"fmt"
+	"net/textproto"
+	"sort"
+	"strings"
+)
+
+// defaultDiffIgnoreHeaders are the headers which Diff ignores by
+// default as they change from one call to the next
+var defaultDiffIgnoreHeaders = []string{
+	"Date",
+	"Expires",
+	"Last-Modified",
+	"X-Request-Id",
+	"X-Amz-Request-Id",
+	"X-Amz-Id-2",
+	"X-Correlation-Id",
+	"X-Trace-Id",
+	"Traceparent",
+}
+
+// Diff returns a readable description of the differences between two
+// Events, eg those passed to OnEvent for a working and a failing call,
+// or "" if there are none worth showing.
+//
+// The method, URL, status, error, headers and body are compared. The
+// headers named in ignore are ignored or, if there are none, the ones
+// which change from one call to the next such as Date, Expires and the
+// request and trace IDs. The ID, Time and Duration are ignored too as
+// they are different for every call. Headers are shown as removed
+// ("-"), added ("+") or changed ("~") and bodies are compared line by
+// line.
+func Diff(a, b Event, ignore ...string) string {
+	var out strings.Builder
+	field := func(name, x, y string) {
+		if x != y {
+			fmt.Fprintf(&out, "%s: %s -> %s\n", name, x, y)
+		}
+	}
+	field("direction", a.Direction.String(), b.Direction.String())
+	field("method", a.Method, b.Method)
+	field("url", a.URL, b.URL)
+	if a.Status != b.Status {
+		field("status", fmt.Sprint(a.Status), fmt.Sprint(b.Status))
+	}
+	field("error", errString(a.Err), errString(b.Err))
+	if len(ignore) == 0 {
+		ignore = defaultDiffIgnoreHeaders
+	}
+	diffHeaders(&out, a, b, ignore)
+	if string(a.Body) != string(b.Body) {
+		out.WriteString("body:\n")
+		diffLines(&out, string(a.Body), string(b.Body))
+	}
+	return out.String()
+}
+
+// errString returns err as a string or "" if it is nil
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// diffHeaders writes the differences between the headers of a and b
+// which aren't named in ignoreNames to out
+func diffHeaders(out *strings.Builder, a, b Event, ignoreNames []string) {
+	ignore := make(map[string]bool, len(ignoreNames))
+	for _, name := range ignoreNames {
+		ignore[textproto.CanonicalMIMEHeaderKey(name)] = true
+	}
+	names := make(map[string]struct{}, len(a.Headers)+len(b.Headers))
+	for name := range a.Headers {
+		names[textproto.CanonicalMIMEHeaderKey(name)] = struct{}{}
+	}
+	for name := range b.Headers {
+		names[textproto.CanonicalMIMEHeaderKey(name)] = struct{}{}
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		if !ignore[name] {
+			sorted = append(sorted, name)
+		}
+	}
+	sort.Strings(sorted)
+	for _, name := range sorted {
+		x, inA := a.Headers[name]
+		y, inB := b.Headers[name]
+		xs, ys := strings.Join(x, ", "), strings.Join(y, ", ")
+		switch {
+		case !inB:
+			fmt.Fprintf(out, "- %s: %s\n", name, xs)
+		case !inA:
+			fmt.Fprintf(out, "+ %s: %s\n", name, ys)
+		case xs != ys:
+			fmt.Fprintf(out, "~ %s: %q -> %q\n", name, xs, ys)
+		}
+	}
+}
+
+// diffLines writes a line by line diff of a and b to out, with removed
+// lines prefixed by "- ", added lines by "+ " and the lines in both
+// by "  ".
+func diffLines(out *strings.Builder, a, b string) {
+	x, y := splitLines(a), splitLines(b)
+	// lcs[i][j] is the length of the longest common subsequence of
+	// x[i:] and y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			fmt.Fprintf(out, "  %s\n", x[i])
+			i++
+			j++
+		case j == len(y) || i < len(x) && lcs[i+1][j] >= lcs[i][j+1]:
+			fmt.Fprintf(out, "- %s\n", x[i])
+			i++
+		default:
+			fmt.Fprintf(out, "+ %s\n", y[j])
+			j++
+		}
+	}
+}
+
+// splitLines splits s into lines without their line endings
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	s = strings.TrimSuffix(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+	return strings.Split(s, "\n")
+}
@@ -0,0 +1,92 @@
+package debughttp
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	a := Event{
+		ID:        "1",
+		Direction: DirectionResponse,
+		Method:    "GET",
+		URL:       "https://example.com/ok",
+		Status:    200,
+		Headers: http.Header{
+			"Date":         {"Mon, 01 Jan 2024 00:00:00 GMT"},
+			"X-Request-Id": {"abc"},
+			"Content-Type": {"application/json"},
+			"X-Removed":    {"gone"},
+			"Vary":         {"Accept", "Origin"},
+		},
+		Body: []byte("{\n  \"status\": \"ok\",\n  \"count\": 1\n}\n"),
+	}
+
+	// Identical apart from the volatile fields
+	b := a
+	b.ID = "2"
+	b.Headers = a.Headers.Clone()
+	b.Headers.Set("Date", "Tue, 02 Jan 2024 00:00:00 GMT")
+	b.Headers.Set("X-Request-Id", "def")
+	assert.Equal(t, "", Diff(a, b))
+
+	b.URL = "https://example.com/fail"
+	b.Status = 500
+	b.Headers.Del("X-Removed")
+	b.Headers.Set("X-Added", "new")
+	b.Headers["Vary"] = []string{"Accept"}
+	b.Body = []byte("{\n  \"status\": \"failed\",\n  \"count\": 1\n}\n")
+	assert.Equal(t, `url: https://example.com/ok -> https://example.com/fail
+status: 200 -> 500
+~ Vary: "Accept, Origin" -> "Accept"
++ X-Added: new
+- X-Removed: gone
+body:
+  {
+-   "status": "ok",
++   "status": "failed",
+    "count": 1
+  }
+`, Diff(a, b))
+}
+
+func TestDiffRequestsAndErrors(t *testing.T) {
+	a := Event{Direction: DirectionRequest, Method: "GET", URL: "http://x/"}
+	b := Event{Direction: DirectionRequest, Method: "POST", URL: "http://x/", Body: []byte("one\ntwo")}
+	assert.Equal(t, "method: GET -> POST\nbody:\n+ one\n+ two\n", Diff(a, b))
+
+	a = Event{Direction: DirectionResponse, Err: errors.New("connection refused")}
+	b = Event{Direction: DirectionResponse, Status: 200}
+	assert.Equal(t, "status: 0 -> 200\nerror: connection refused -> \n", Diff(a, b))
+	assert.Equal(t, "direction: request -> response\n", Diff(Event{}, Event{Direction: DirectionResponse}))
+}
+
+func TestDiffIgnoreHeaders(t *testing.T) {
+	a := Event{Headers: http.Header{"Date": {"1"}, "X-Volatile": {"1"}}}
+	b := Event{Headers: http.Header{"Date": {"2"}, "X-Volatile": {"2"}}}
+	assert.Equal(t, "~ X-Volatile: \"1\" -> \"2\"\n", Diff(a, b))
+	assert.Equal(t, "~ Date: \"1\" -> \"2\"\n", Diff(a, b, "x-volatile"))
+	// The default isn't changed
+	assert.Equal(t, "~ X-Volatile: \"1\" -> \"2\"\n", Diff(a, b))
+}
+
+func TestDiffLines(t *testing.T) {
+	for _, test := range []struct {
+		a, b string
+		want string
+	}{
+		{"", "", ""},
+		{"a", "a", "  a\n"},
+		{"a\r\nb\r\n", "a\nc\n", "  a\n- b\n+ c\n"},
+		{"a\nb\nc", "b\nc\nd", "- a\n  b\n  c\n+ d\n"},
+		{"x", "", "- x\n"},
+	} {
+		var out strings.Builder
+		diffLines(&out, test.a, test.b)
+		assert.Equal(t, test.want, out.String(), test.a+" | "+test.b)
+	}
+}
@@ -0,0 +1,104 @@
+package debughttp
+
+import (
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// DisableEnv is the environment variable which, if set to a true
+// value such as "1" when the program starts, disables all the
+// Transports made by this package.
+const DisableEnv = "DEBUGHTTP_DISABLE"
+
+// disabled is 1 if all Transports should pass the requests straight
+// through - use atomic
+var disabled int32
+
+func init() {
+	SetDisabled(envDisabled())
+}
+
+// envDisabled returns true if DisableEnv is set to a true value
+func envDisabled() bool {
+	disable, err := strconv.ParseBool(os.Getenv(DisableEnv))
+	return err == nil && disable
+}
+
+// SetDisabled disables or enables all the Transports made by this
+// package, whenever they were made.
+//
+// A disabled Transport passes the requests straight through to the
+// underlying transport without logging them or calling OnEvent,
+// whatever its Options say. This takes precedence over the Options and
+// the last call to SetDisabled takes precedence over DisableEnv.
+func SetDisabled(disable bool) {
+	var value int32
+	if disable {
+		value = 1
+	}
+	atomic.StoreInt32(&disabled, value)
+}
+
+// Disabled returns true if the Transports are disabled by
+// SetDisabled or DisableEnv.
+func Disabled() bool {
+	return atomic.LoadInt32(&disabled) != 0
+}
+
+// activeWindow is the time window a Transport logs in
+type activeWindow struct {
+	from  int64 // the start in UnixNano or 0 for none - use atomic
+	until int64 // the end in UnixNano or 0 for none - use atomic
+}
+
+// unixNano returns tm in UnixNano or 0 if it is zero
+func unixNano(tm time.Time) int64 {
+	if tm.IsZero() {
+		return 0
+	}
+	return tm.UnixNano()
+}
+
+// newActiveWindow makes an activeWindow from from until until, either
+// of which can be zero for no limit
+func newActiveWindow(from, until time.Time) *activeWindow {
+	w := new(activeWindow)
+	w.set(from, until)
+	return w
+}
+
+// set changes the window
+func (w *activeWindow) set(from, until time.Time) {
+	atomic.StoreInt64(&w.from, unixNano(from))
+	atomic.StoreInt64(&w.until, unixNano(until))
+}
+
+// contains returns true if the time returned by now is in the window.
+// now is only called if the window has a limit.
+func (w *activeWindow) contains(now func() time.Time) bool {
+	from, until := atomic.LoadInt64(&w.from), atomic.LoadInt64(&w.until)
+	if from == 0 && until == 0 {
+		return true
+	}
+	n := now().UnixNano()
+	return (from == 0 || n >= from) && (until == 0 || n < until)
+}
+
+// SetActiveWindow changes the time window the Transport logs in, which
+// starts as ActiveFrom to ActiveUntil in its Options. Either can be
+// zero for no limit.
+//
+// Outside the window the Transport passes the requests straight
+// through as if it was disabled with SetDisabled, which takes
+// precedence. For example, to capture the next 5 minutes from an
+// admin endpoint
+//
+//	now := time.Now()
+//	transport.SetActiveWindow(now, now.Add(5*time.Minute))
+//
+// It is safe to call while the Transport is in use.
+func (t *Transport) SetActiveWindow(from, until time.Time) {
+	t.window.set(from, until)
+}
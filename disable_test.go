@@ -0,0 +1,115 @@
+package debughttp
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvDisabled(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		want  bool
+	}{
+		{"", false},
+		{"0", false},
+		{"false", false},
+		{"potato", false},
+		{"1", true},
+		{"true", true},
+		{"TRUE", true},
+	} {
+		t.Setenv(DisableEnv, test.value)
+		assert.Equal(t, test.want, envDisabled(), test.value)
+	}
+}
+
+func TestSetDisabled(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Response body")
+	}))
+	defer ts.Close()
+
+	var lines []string
+	events := 0
+	client := NewClient(&Options{
+		Flags: DumpBodies | DumpSummary | DumpSizes,
+		Logf: func(format string, v ...interface{}) {
+			lines = append(lines, fmt.Sprintf(format, v...))
+		},
+		OnEvent: func(Event) {
+			events++
+		},
+	})
+	get := func() {
+		resp, err := client.Get(ts.URL)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+	}
+
+	require.False(t, Disabled())
+	SetDisabled(true)
+	defer SetDisabled(false)
+	assert.True(t, Disabled())
+	get()
+	assert.Equal(t, 0, len(lines))
+	assert.Equal(t, 0, events)
+
+	SetDisabled(false)
+	assert.False(t, Disabled())
+	get()
+	assert.NotEqual(t, 0, len(lines))
+	assert.Equal(t, 2, events)
+}
+
+func TestActiveWindow(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	var lines []string
+	transport := NewDefault(&Options{
+		Flags: DumpSummary,
+		Logf: func(format string, v ...interface{}) {
+			lines = append(lines, fmt.Sprintf(format, v...))
+		},
+		ActiveFrom:  start.Add(time.Minute),
+		ActiveUntil: start.Add(2 * time.Minute),
+	})
+	now := start
+	transport.SetClock(func() time.Time {
+		return now
+	})
+	client := &http.Client{Transport: transport}
+	get := func() int {
+		lines = nil
+		resp, err := client.Get(ts.URL)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return len(lines)
+	}
+
+	assert.Equal(t, 0, get(), "before")
+	now = start.Add(time.Minute)
+	assert.Equal(t, 1, get(), "at start")
+	now = start.Add(2*time.Minute - time.Nanosecond)
+	assert.Equal(t, 1, get(), "just before end")
+	now = start.Add(2 * time.Minute)
+	assert.Equal(t, 0, get(), "at end")
+
+	SetDisabled(true)
+	transport.SetActiveWindow(time.Time{}, now.Add(5*time.Minute))
+	assert.Equal(t, 0, get(), "disabled")
+	SetDisabled(false)
+	assert.Equal(t, 1, get(), "reopened")
+
+	now = now.Add(5 * time.Minute)
+	assert.Equal(t, 0, get(), "expired")
+	transport.SetActiveWindow(time.Time{}, time.Time{})
+	assert.Equal(t, 1, get(), "unbounded")
+}
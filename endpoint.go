@@ -0,0 +1,75 @@
+package debughttp
+
+import (
+	"net/http"
+	"sync"
+)
+
+// endpointKey is the default EndpointFunc - the method and the path
+// without the query, eg "GET /v1/objects"
+func endpointKey(req *http.Request) string {
+	return req.Method + " " + req.URL.Path
+}
+
+// endpointSet counts the round trips to each endpoint for
+// OncePerEndpoint
+type endpointSet struct {
+	mu   sync.Mutex
+	seen map[string]int64
+}
+
+// newEndpointSet makes an empty endpointSet
+func newEndpointSet() *endpointSet {
+	return &endpointSet{
+		seen: make(map[string]int64),
+	}
+}
+
+// add counts a round trip to endpoint returning the number of times
+// it has been seen including this one
+func (s *endpointSet) add(endpoint string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seen[endpoint]++
+	return s.seen[endpoint]
+}
+
+// isPowerOfTen returns true if n is 10, 100, 1000, etc
+func isPowerOfTen(n int64) bool {
+	if n < 10 {
+		return false
+	}
+	for n%10 == 0 {
+		n /= 10
+	}
+	return n == 1
+}
+
+// firstForEndpoint returns true if req is the first round trip to
+// its endpoint so should be dumped. Repeats log a summary line the
+// 10th, 100th, 1000th, etc time the endpoint is seen.
+func (t *Transport) firstForEndpoint(req *http.Request) bool {
+	endpointFunc := t.opt.EndpointFunc
+	if endpointFunc == nil {
+		endpointFunc = endpointKey
+	}
+	endpoint := endpointFunc(req)
+	n := t.endpoints.add(endpoint)
+	if isPowerOfTen(n) {
+		t.logf(req, "... (endpoint %s seen %d times)", endpoint, n)
+	}
+	return n == 1
+}
+
+// quiet returns a copy of t which doesn't log anything but still
+// counts the round trip in the Stats and calls OnEvent
+func (t *Transport) quiet() *Transport {
+	c := t.withFlags(0)
+	c.sinks = nil
+	c.endpoints = nil
+	c.opt.BodyFilter = nil
+	c.opt.BodyHash = false
+	c.opt.RedirectSummary = false
+	c.opt.DetectBodyLeak = false
+	return c
+}
@@ -0,0 +1,108 @@
+package debughttp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsPowerOfTen(t *testing.T) {
+	for n, want := range map[int64]bool{
+		0: false, 1: false, 5: false, 10: true, 11: false, 20: false,
+		100: true, 110: false, 1000: true,
+	} {
+		assert.Equal(t, want, isPowerOfTen(n), n)
+	}
+}
+
+func TestOncePerEndpoint(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("OK"))
+	}))
+	defer ts.Close()
+
+	var events []Event
+	client, capture := NewCaptureClient(&Options{
+		Flags:           DumpHeaders,
+		OncePerEndpoint: true,
+		OnEvent: func(ev Event) {
+			events = append(events, ev)
+		},
+	})
+	get := func(path string) {
+		resp, err := client.Get(ts.URL + path)
+		require.NoError(t, err)
+		_, _ = ioutil.ReadAll(resp.Body)
+		require.NoError(t, resp.Body.Close())
+	}
+
+	// The query isn't part of the endpoint by default
+	get("/poll?n=1")
+	assert.Equal(t, 8, len(capture.Lines()))
+	for i := 2; i <= 9; i++ {
+		get("/poll?n=2")
+	}
+	assert.Equal(t, 8, len(capture.Lines()))
+	get("/poll")
+	lines := capture.Lines()
+	require.Equal(t, 9, len(lines))
+	assert.Equal(t, "... (endpoint GET /poll seen 10 times)", lines[8])
+
+	// A new endpoint is dumped
+	get("/other")
+	assert.Equal(t, 17, len(capture.Lines()))
+
+	// Repeats are still counted and passed to OnEvent
+	assert.Equal(t, int64(11), client.Transport.(*Transport).Stats().Requests)
+	assert.Equal(t, 22, len(events))
+
+	// EndpointFunc can change the key
+	client, capture = NewCaptureClient(&Options{
+		Flags:           DumpHeaders,
+		OncePerEndpoint: true,
+		EndpointFunc: func(req *http.Request) string {
+			return req.URL.RequestURI()
+		},
+	})
+	get("/poll?n=1")
+	get("/poll?n=2")
+	get("/poll?n=2")
+	assert.Equal(t, 16, len(capture.Lines()))
+}
+
+func TestOncePerEndpointQuiet(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/poll", http.StatusFound)
+			return
+		}
+		_, _ = w.Write([]byte("OK"))
+	}))
+	defer ts.Close()
+
+	client, capture := NewCaptureClient(&Options{
+		Flags:           DumpSummary,
+		OncePerEndpoint: true,
+		BodyHash:        true,
+		RedirectSummary: true,
+		DetectBodyLeak:  true,
+	})
+	get := func() {
+		resp, err := client.Get(ts.URL + "/redirect")
+		require.NoError(t, err)
+		_, _ = ioutil.ReadAll(resp.Body)
+		require.NoError(t, resp.Body.Close())
+	}
+
+	get()
+	n := len(capture.Lines())
+	require.NotEqual(t, 0, n)
+
+	// A suppressed repeat logs nothing at all
+	get()
+	assert.Equal(t, n, len(capture.Lines()), capture.Lines())
+}
@@ -0,0 +1,62 @@
+package debughttp
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// The kinds of error returned by classifyError and shown in
+// Event.ErrKind
+const (
+	ErrKindDNS       = "dns"       // the host name couldn't be resolved
+	ErrKindConnect   = "connect"   // the connection couldn't be made
+	ErrKindTLS       = "tls"       // the TLS handshake or certificate verification failed
+	ErrKindTimeout   = "timeout"   // a deadline or timeout was exceeded
+	ErrKindCancelled = "cancelled" // the request's context was cancelled
+	ErrKindRefused   = "refused"   // the connection was refused
+	ErrKindEOF       = "eof"       // the connection was closed unexpectedly
+	ErrKindOther     = "other"     // none of the above
+)
+
+// classifyError returns the kind of the error from a round trip, one
+// of the ErrKind constants, or "" if err is nil.
+func classifyError(err error) string {
+	if err == nil {
+		return ""
+	}
+	var (
+		netErr       net.Error
+		dnsErr       *net.DNSError
+		opErr        *net.OpError
+		recordErr    tls.RecordHeaderError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+	)
+	switch {
+	case errors.Is(err, context.Canceled):
+		return ErrKindCancelled
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return ErrKindTimeout
+	case errors.As(err, &dnsErr):
+		return ErrKindDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrKindRefused
+	case errors.As(err, &recordErr), errors.As(err, &authorityErr), errors.As(err, &hostnameErr), errors.As(err, &invalidErr):
+		return ErrKindTLS
+	case strings.Contains(err.Error(), "tls: "):
+		// Most TLS errors are only distinguishable by their text
+		return ErrKindTLS
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return ErrKindConnect
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return ErrKindEOF
+	}
+	return ErrKindOther
+}
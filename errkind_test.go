@@ -0,0 +1,83 @@
+package debughttp
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyError(t *testing.T) {
+	dial := func(err error) error {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: err}
+	}
+	for _, test := range []struct {
+		err  error
+		want string
+	}{
+		{nil, ""},
+		{errors.New("boom"), ErrKindOther},
+		{context.Canceled, ErrKindCancelled},
+		{&url.Error{Op: "Get", URL: "http://x/", Err: context.Canceled}, ErrKindCancelled},
+		{context.DeadlineExceeded, ErrKindTimeout},
+		{dial(os.NewSyscallError("connect", syscall.ETIMEDOUT)), ErrKindTimeout},
+		{&net.DNSError{Err: "no such host", Name: "x.invalid", IsNotFound: true}, ErrKindDNS},
+		{dial(&net.DNSError{Err: "no such host", Name: "x.invalid"}), ErrKindDNS},
+		{dial(os.NewSyscallError("connect", syscall.ECONNREFUSED)), ErrKindRefused},
+		{dial(os.NewSyscallError("connect", syscall.EHOSTUNREACH)), ErrKindConnect},
+		{tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}, ErrKindTLS},
+		{fmt.Errorf("wrapped: %w", x509.UnknownAuthorityError{}), ErrKindTLS},
+		{x509.HostnameError{Certificate: &x509.Certificate{}, Host: "x"}, ErrKindTLS},
+		{errors.New("remote error: tls: handshake failure"), ErrKindTLS},
+		{io.EOF, ErrKindEOF},
+		{&url.Error{Op: "Get", URL: "http://x/", Err: io.ErrUnexpectedEOF}, ErrKindEOF},
+	} {
+		assert.Equal(t, test.want, classifyError(test.err), fmt.Sprint(test.err))
+	}
+}
+
+func TestErrKindEvent(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, test := range []struct {
+		name string
+		ctx  context.Context
+		url  string
+		want string
+	}{
+		{name: "Refused", ctx: context.Background(), url: "http://127.0.0.1:1/", want: ErrKindRefused},
+		{name: "TLS", ctx: context.Background(), url: ts.URL, want: ErrKindTLS},
+		{name: "Cancelled", ctx: ctx, url: ts.URL, want: ErrKindCancelled},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var events []Event
+			client := NewClient(&Options{
+				OnEvent: func(ev Event) {
+					events = append(events, ev)
+				},
+			})
+			req, err := http.NewRequestWithContext(test.ctx, http.MethodGet, test.url, nil)
+			require.NoError(t, err)
+			_, err = client.Do(req)
+			require.Error(t, err)
+			require.Equal(t, 2, len(events))
+			assert.Equal(t, "", events[0].ErrKind)
+			assert.Equal(t, test.want, events[1].ErrKind, events[1].Err.Error())
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package debughttp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/textproto"
+	"time"
+)
+
+// Event describes a request or a response for Options.OnEvent.
+//
+// Redaction is applied before the Event is made so Headers and Body
+// are redacted in the same way as the dumps, Options.Redactors
+// included.
+type Event struct {
+	Time      time.Time     // when the request was sent or the response received
+	ID        string        // identifies the transaction - the same for a request and its response
+	Direction Direction     // whether this is the request or the response
+	Method    string        // the request method
+	URL       string        // the request URL with any password redacted
+	Target    string        // the host:port the request is for with any IPv6 address in brackets, eg "[::1]:443"
+	Remote    string        // for responses, the address actually connected to, which is the proxy if one was used - "" if unknown
+	Status    int           // the response status code - 0 for requests and failed round trips
+	Headers   http.Header   // the headers with the Auth headers redacted
+	Body      []byte        // the body if the Flags say it should be dumped, otherwise nil
+	Duration  time.Duration // for responses, how long the round trip took
+	Err       error         // for responses, the error if the round trip failed
+	ErrKind   string        // for responses, the kind of Err, one of the ErrKind constants, or "" if it succeeded
+}
+
+// redactHeader returns a copy of header with the Auth headers and the
+// credentials in any URLs redacted according to the Options and
+// RedactFunc applied
+func (t *Transport) redactHeader(header http.Header) http.Header {
+	header = header.Clone()
+	t.redactAuthHeader(header)
+	if redactFunc := t.opt.RedactFunc; redactFunc != nil {
+		for key, values := range header {
+			for i, value := range values {
+				if newValue, ok := redactFunc(textproto.CanonicalMIMEHeaderKey(key), value); ok {
+					values[i] = newValue
+				}
+			}
+		}
+	}
+	return header
+}
+
+// redactResponseHeader is redactHeader for response headers which
+// also redacts the Set-Cookie values if required
+func (t *Transport) redactResponseHeader(header http.Header) http.Header {
+	header = t.redactHeader(header)
+	if t.redactSetCookie() {
+		mask := t.maskFunc()
+		for i, value := range header["Set-Cookie"] {
+			header["Set-Cookie"][i] = string(maskSetCookie([]byte(value), mask))
+		}
+	}
+	return header
+}
+
+// redactAuthHeader redacts the Auth headers and the credentials in
+// any URLs in header according to the Options
+func (t *Transport) redactAuthHeader(header http.Header) {
+	if t.opt.Flags&DumpAuth == 0 {
+		for _, name := range urlHeaders {
+			values := header[string(name)]
+			for i, value := range values {
+				values[i] = t.scrubURLString(value)
+			}
+		}
+	}
+	t.redactAuthValues(header)
+}
+
+// redactAuthValues redacts the values of the Auth headers in header
+// according to the Options
+func (t *Transport) redactAuthValues(header http.Header) {
+	if t.opt.Flags&DumpAuth != 0 && !t.opt.RedactJWT {
+		return
+	}
+	rewrite := t.maskFunc()
+	if t.opt.Flags&DumpAuth != 0 {
+		rewrite = maskJWTs
+	}
+	names := make(map[string]struct{}, len(t.opt.Auth))
+	for _, authBuf := range t.opt.Auth {
+		names[headerName(authBuf)] = struct{}{}
+	}
+	for key, values := range header {
+		if _, found := names[textproto.CanonicalMIMEHeaderKey(key)]; !found {
+			continue
+		}
+		redacted := make([]string, len(values))
+		for i, value := range values {
+			redacted[i] = string(rewrite([]byte(value)))
+		}
+		header[key] = redacted
+	}
+}
+
+// redactBody applies the body redactions in the Options to body
+func (t *Transport) redactBody(body []byte) []byte {
+	if t.opt.RedactPII {
+		body = redactPIIBody(body, t.opt.PIIPatterns)
+	}
+	return body
+}
+
+// redactEvent returns the headers and body for an Event redacted by
+// the same Redactors as the dumps. It makes a dump with startLine out
+// of them, redacts that and splits it up again. Only the headers in
+// header are kept so any notes the Redactors add aren't returned as
+// headers.
+func (t *Transport) redactEvent(startLine string, header http.Header, body []byte, dir Direction) (http.Header, []byte) {
+	var buf bytes.Buffer
+	buf.WriteString(startLine)
+	buf.WriteString("\r\n")
+	_ = header.Write(&buf)
+	buf.WriteString("\r\n")
+	buf.Write(body)
+	contentType := header.Get("Content-Type")
+	if body != nil {
+		contentType = dumpContentType(buf.Bytes(), contentType)
+	}
+	d, ok := splitDump(t.runRedactors(buf.Bytes(), dir, contentType))
+	redacted := make(http.Header, len(header))
+	if !ok {
+		return redacted, nil
+	}
+	for _, line := range d.headers {
+		name, value, ok := splitHeader(line)
+		if _, found := header[name]; !ok || !found {
+			continue
+		}
+		redacted[name] = append(redacted[name], string(value))
+	}
+	if body == nil {
+		return redacted, nil
+	}
+	rest := d.rest
+	if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+		rest = rest[i+1:]
+	}
+	return redacted, t.redactBody(rest)
+}
+
+// txResponseBody reads the response body of tx replacing it with a
+// buffered copy so it can still be read by the caller.
+//
+// If BodyDumpTimeout is set then the body is read once only and the
+// read gives up after the timeout, setting tx.respBodyTimedOut, with
+// the rest of the body left for the caller.
+func (t *Transport) txResponseBody(tx *transaction) ([]byte, error) {
+	resp := tx.resp
+	if resp.Body == nil || resp.Body == http.NoBody {
+		return nil, nil
+	}
+	if t.opt.BodyDumpTimeout > 0 {
+		if !tx.respBodyRead {
+			tx.respBody, resp.Body, tx.respBodyTimedOut, tx.respBodyErr = readTimed(resp.Body, t.opt.BodyDumpTimeout)
+			tx.respBodyRead = true
+		}
+		return tx.respBody, tx.respBodyErr
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, err
+}
+
+// requestEvent makes an Event for the request of tx
+func (t *Transport) requestEvent(tx *transaction) Event {
+	req := tx.req
+	ev := Event{
+		Time:      tx.start,
+		ID:        tx.ref,
+		Direction: DirectionRequest,
+		Method:    req.Method,
+		URL:       t.scrubURL(req.URL),
+		Target:    targetAddr(req.URL),
+	}
+	var body []byte
+	if t.opt.Flags&(DumpBodies|DumpRequests) != 0 && !tx.isConnect && !tx.noBodies && t.requestBodyInBudget(tx) {
+		reqBody, err := t.txRequestBody(tx)
+		if err == nil {
+			body = reqBody
+		}
+	}
+	ev.Headers, ev.Body = t.redactEvent(req.Method+" "+req.URL.RequestURI()+" HTTP/1.1", req.Header, body, DirectionRequest)
+	return ev
+}
+
+// responseEvent makes an Event for the response of tx
+func (t *Transport) responseEvent(tx *transaction) Event {
+	req := tx.req
+	ev := Event{
+		Time:      tx.start.Add(tx.duration),
+		ID:        tx.ref,
+		Direction: DirectionResponse,
+		Method:    req.Method,
+		URL:       t.scrubURL(req.URL),
+		Target:    targetAddr(req.URL),
+		Remote:    remoteAddr(tx),
+		Duration:  tx.duration,
+		Err:       tx.err,
+		ErrKind:   classifyError(tx.err),
+	}
+	if tx.err != nil {
+		return ev
+	}
+	ev.Status = tx.resp.StatusCode
+	var body []byte
+	if t.opt.Flags&(DumpBodies|DumpResponses) != 0 && !tx.isConnect && !tx.noBodies && tx.resp.StatusCode != http.StatusSwitchingProtocols && !isNDJSON(tx.resp) && t.bodyTooBig(tx) == "" && t.responseBodyInBudget(tx) {
+		respBody, err := t.txResponseBody(tx)
+		if err == nil && !tx.respBodyTimedOut {
+			body = respBody
+		}
+	}
+	// The Redactors only redact the Auth headers of requests
+	header := tx.resp.Header.Clone()
+	t.redactAuthValues(header)
+	ev.Headers, ev.Body = t.redactEvent("HTTP/1.1 "+tx.resp.Status, header, body, DirectionResponse)
+	return ev
+}
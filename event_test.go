@@ -0,0 +1,143 @@
+package debughttp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOnEvent(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Auth-Token", "SECRET")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, "Reply to user@example.com")
+	}))
+	defer ts.Close()
+
+	for _, test := range []struct {
+		name     string
+		flags    DumpFlags
+		wantBody bool
+	}{
+		{name: "NoFlags"},
+		{name: "DumpBodies", flags: DumpBodies, wantBody: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var events []Event
+			client := NewClient(&Options{
+				Flags:     test.flags,
+				Logf:      func(format string, v ...interface{}) {},
+				RedactPII: true,
+				OnEvent: func(ev Event) {
+					events = append(events, ev)
+				},
+			})
+			req, err := http.NewRequest("POST", ts.URL+"/path", strings.NewReader("Request body"))
+			require.NoError(t, err)
+			req.Header.Set("Authorization", "POTATO")
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			body, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			assert.Equal(t, "Reply to user@example.com", string(body))
+
+			require.Equal(t, 2, len(events))
+			reqEv, respEv := events[0], events[1]
+
+			assert.Equal(t, fmt.Sprintf("%p", req), reqEv.ID)
+			assert.Equal(t, DirectionRequest, reqEv.Direction)
+			assert.Equal(t, "POST", reqEv.Method)
+			assert.Equal(t, ts.URL+"/path", reqEv.URL)
+			assert.Equal(t, 0, reqEv.Status)
+			assert.Equal(t, "XXXX", reqEv.Headers.Get("Authorization"))
+			assert.Equal(t, "POTATO", req.Header.Get("Authorization"))
+			assert.False(t, reqEv.Time.IsZero())
+
+			assert.Equal(t, reqEv.ID, respEv.ID)
+			assert.Equal(t, DirectionResponse, respEv.Direction)
+			assert.Equal(t, http.StatusCreated, respEv.Status)
+			assert.Equal(t, "XXXX", respEv.Headers.Get("X-Auth-Token"))
+			assert.Equal(t, "SECRET", resp.Header.Get("X-Auth-Token"))
+			assert.NoError(t, respEv.Err)
+			assert.True(t, respEv.Duration > 0)
+			assert.False(t, respEv.Time.Before(reqEv.Time))
+
+			if test.wantBody {
+				assert.Equal(t, "Request body", string(reqEv.Body))
+				assert.Equal(t, "Reply to [REDACTED email]", string(respEv.Body))
+			} else {
+				assert.Nil(t, reqEv.Body)
+				assert.Nil(t, respEv.Body)
+			}
+		})
+	}
+}
+
+func TestOnEventError(t *testing.T) {
+	var events []Event
+	client := NewClient(&Options{
+		OnEvent: func(ev Event) {
+			events = append(events, ev)
+		},
+	})
+	_, err := client.Get("http://127.0.0.1:1/")
+	require.Error(t, err)
+	require.Equal(t, 2, len(events))
+	assert.Error(t, events[1].Err)
+	assert.Equal(t, 0, events[1].Status)
+	assert.Nil(t, events[1].Headers)
+}
+
+func TestOnEventRedactors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Session", "session=hunter2")
+		w.Header().Set("Location", "/users/alice")
+		fmt.Fprint(w, "secret=hunter2")
+	}))
+	defer ts.Close()
+
+	var events []Event
+	var lines []string
+	client := NewClient(&Options{
+		Flags: DumpBodies,
+		Logf: func(format string, v ...interface{}) {
+			lines = append(lines, fmt.Sprintf(format, v...))
+		},
+		Redactors: []Redactor{RedactorFunc(func(buf []byte, dir Direction, contentType string) []byte {
+			return []byte(strings.ReplaceAll(string(buf), "hunter2", "*****"))
+		})},
+		RedactLinePatterns: []*regexp.Regexp{regexp.MustCompile(`/users/([^/]+)`)},
+		OnEvent: func(ev Event) {
+			events = append(events, ev)
+		},
+	})
+	req, err := http.NewRequest("POST", ts.URL+"/path", strings.NewReader("secret=hunter2"))
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, "secret=hunter2", string(body))
+
+	dump := strings.Join(lines, "\n")
+	assert.NotContains(t, dump, "hunter2")
+	assert.NotContains(t, dump, "alice")
+
+	require.Equal(t, 2, len(events))
+	reqEv, respEv := events[0], events[1]
+	assert.Equal(t, "secret=*****", string(reqEv.Body))
+	assert.Equal(t, "secret=*****", string(respEv.Body))
+	assert.Equal(t, "session=*****", respEv.Headers.Get("X-Session"))
+	assert.Equal(t, "session=hunter2", resp.Header.Get("X-Session"))
+	assert.Equal(t, "/users/xxxxx", respEv.Headers.Get("Location"))
+	assert.Equal(t, "/users/alice", resp.Header.Get("Location"))
+}
@@ -2,6 +2,7 @@ package debughttp_test
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/rclone/debughttp"
 )
@@ -24,8 +25,9 @@ func ExampleNewClient() {
 	// Make a client with full options
 	// This dumps headers, request bodies and doesn't redact the auth
 	client = debughttp.NewClient(&debughttp.Options{
-		Flags: debughttp.DumpRequests | debughttp.DumpAuth,
-		Logf:  myLogf,
+		Flags:         debughttp.DumpRequests | debughttp.DumpAuth,
+		Logf:          myLogf,
+		AllowAuthDump: true,
 	})
 }
 
@@ -39,8 +41,9 @@ func ExampleNewDefault() {
 	// Make a transport with full options
 	// This dumps headers, request bodies and doesn't redact the auth
 	transport = debughttp.NewDefault(&debughttp.Options{
-		Flags: debughttp.DumpRequests | debughttp.DumpAuth,
-		Logf:  myLogf,
+		Flags:         debughttp.DumpRequests | debughttp.DumpAuth,
+		Logf:          myLogf,
+		AllowAuthDump: true,
 	})
 }
 
@@ -54,7 +57,20 @@ func ExampleNew() {
 	// Make a transport with full options
 	// This dumps headers, request bodies and doesn't redact the auth
 	transport = debughttp.New(&debughttp.Options{
-		Flags: debughttp.DumpRequests | debughttp.DumpAuth,
-		Logf:  myLogf,
+		Flags:         debughttp.DumpRequests | debughttp.DumpAuth,
+		Logf:          myLogf,
+		AllowAuthDump: true,
 	}, existingTransport)
 }
+
+func ExampleNewCaptureClient() {
+	// Make a client which captures the dumps, eg to check in a test
+	captureClient, capture := debughttp.NewCaptureClient(&debughttp.DumpBodyOptions)
+	resp, err := captureClient.Get("https://example.com/")
+	if err == nil {
+		_ = resp.Body.Close()
+	}
+
+	// Check the dumps
+	_ = strings.Contains(capture.String(), "HTTP/1.1 200 OK")
+}
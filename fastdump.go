@@ -0,0 +1,229 @@
+package debughttp
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+)
+
+// The headers written separately from the rest by net/http
+var (
+	reqWriteExcludeHeader = map[string]bool{
+		"Host":              true,
+		"User-Agent":        true,
+		"Content-Length":    true,
+		"Transfer-Encoding": true,
+		"Trailer":           true,
+	}
+	respExcludeHeader = map[string]bool{
+		"Content-Length":    true,
+		"Transfer-Encoding": true,
+		"Trailer":           true,
+	}
+)
+
+// dumpRequestHeaders dumps the request line and headers of req in the
+// same format as httputil.DumpRequestOut(req, false).
+//
+// httputil.DumpRequestOut sends the request through an http.Transport
+// to a fake connection to do this which is slow, so the common cases
+// are formatted directly, falling back to it for the rest.
+func dumpRequestHeaders(req *http.Request) ([]byte, error) {
+	if buf, ok := fastDumpRequest(req); ok {
+		return buf, nil
+	}
+	return httputil.DumpRequestOut(req, false)
+}
+
+// dumpResponseHeaders dumps the status line and headers of resp in the
+// same format as httputil.DumpResponse(resp, false), formatting the
+// common cases directly like dumpRequestHeaders.
+func dumpResponseHeaders(resp *http.Response) ([]byte, error) {
+	if buf, ok := fastDumpResponse(resp); ok {
+		return buf, nil
+	}
+	return httputil.DumpResponse(resp, false)
+}
+
+// fastDumpRequest dumps the headers of req like dumpRequestHeaders. It
+// returns ok false if req isn't simple enough to be sure the result
+// is the same as httputil.DumpRequestOut.
+func fastDumpRequest(req *http.Request) (buf []byte, ok bool) {
+	if req.URL == nil || (req.URL.Scheme != "http" && req.URL.Scheme != "https") || req.Method == "CONNECT" ||
+		req.Close || len(req.TransferEncoding) > 0 || len(req.Trailer) > 0 || req.Header.Get("Expect") != "" {
+		return nil, false
+	}
+	hasBody := req.Body != nil && req.Body != http.NoBody
+	if (hasBody && req.ContentLength <= 0) || (!hasBody && req.ContentLength != 0) {
+		return nil, false
+	}
+	method := req.Method
+	if method == "" {
+		method = "GET"
+	} else if !validToken(method) {
+		return nil, false
+	}
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	if !simpleHost(host) {
+		return nil, false
+	}
+	requestURI := req.URL.RequestURI()
+	if containsCTL(requestURI) || !validHeader(req.Header) {
+		return nil, false
+	}
+	var b bytes.Buffer
+	b.Grow(256)
+	b.WriteString(method)
+	b.WriteByte(' ')
+	b.WriteString(requestURI)
+	b.WriteString(" HTTP/1.1\r\nHost: ")
+	b.WriteString(host)
+	b.WriteString("\r\n")
+	userAgent := "Go-http-client/1.1"
+	if _, found := req.Header["User-Agent"]; found {
+		userAgent = req.Header.Get("User-Agent")
+	}
+	if userAgent = strings.Trim(userAgent, " \t\r\n"); userAgent != "" {
+		b.WriteString("User-Agent: ")
+		b.WriteString(userAgent)
+		b.WriteString("\r\n")
+	}
+	if req.ContentLength > 0 || method == "POST" || method == "PUT" || method == "PATCH" {
+		b.WriteString("Content-Length: ")
+		b.WriteString(strconv.FormatInt(req.ContentLength, 10))
+		b.WriteString("\r\n")
+	}
+	_ = req.Header.WriteSubset(&b, reqWriteExcludeHeader)
+	// Added by http.Transport if the caller hasn't set it
+	if method != "HEAD" && req.Header.Get("Accept-Encoding") == "" && req.Header.Get("Range") == "" {
+		b.WriteString("Accept-Encoding: gzip\r\n")
+	}
+	b.WriteString("\r\n")
+	return b.Bytes(), true
+}
+
+// fastDumpResponse dumps the headers of resp like dumpResponseHeaders.
+// It returns ok false if resp isn't simple enough to be sure the
+// result is the same as httputil.DumpResponse.
+func fastDumpResponse(resp *http.Response) (buf []byte, ok bool) {
+	if resp.StatusCode < 100 || resp.ContentLength < 0 || resp.Close || len(resp.TransferEncoding) > 0 || len(resp.Trailer) > 0 {
+		return nil, false
+	}
+	text := resp.Status
+	if text == "" {
+		text = http.StatusText(resp.StatusCode)
+		if text == "" {
+			text = "status code " + strconv.Itoa(resp.StatusCode)
+		}
+	} else {
+		text = strings.TrimPrefix(text, strconv.Itoa(resp.StatusCode)+" ")
+	}
+	method := "GET"
+	if resp.Request != nil && resp.Request.Method != "" {
+		method = resp.Request.Method
+	}
+	var b bytes.Buffer
+	b.Grow(256)
+	b.WriteString("HTTP/")
+	b.WriteString(strconv.Itoa(resp.ProtoMajor))
+	b.WriteByte('.')
+	b.WriteString(strconv.Itoa(resp.ProtoMinor))
+	b.WriteByte(' ')
+	b.WriteString(strconv.Itoa(resp.StatusCode))
+	b.WriteByte(' ')
+	b.WriteString(text)
+	b.WriteString("\r\n")
+	contentLengthSent := resp.ContentLength > 0 || method == "POST" || method == "PUT" || method == "PATCH"
+	if contentLengthSent {
+		b.WriteString("Content-Length: ")
+		b.WriteString(strconv.FormatInt(resp.ContentLength, 10))
+		b.WriteString("\r\n")
+	}
+	_ = resp.Header.WriteSubset(&b, respExcludeHeader)
+	if resp.ContentLength == 0 && !contentLengthSent && bodyAllowedForStatus(resp.StatusCode) {
+		b.WriteString("Content-Length: 0\r\n")
+	}
+	b.WriteString("\r\n")
+	return b.Bytes(), true
+}
+
+// bodyAllowedForStatus returns true if a response with status may
+// have a body
+func bodyAllowedForStatus(status int) bool {
+	switch {
+	case status >= 100 && status <= 199:
+		return false
+	case status == http.StatusNoContent, status == http.StatusNotModified:
+		return false
+	}
+	return true
+}
+
+// simpleHost returns true if host only has the characters of a plain
+// host name, IP address and port so net/http sends it as is
+func simpleHost(host string) bool {
+	if host == "" {
+		return false
+	}
+	for i := 0; i < len(host); i++ {
+		c := host[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '.', c == '-', c == ':', c == '[', c == ']', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validToken returns true if s is a valid HTTP token as required for
+// methods and header names
+func validToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// containsCTL returns true if s contains an ASCII control character
+func containsCTL(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < ' ' || c == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+// validHeader returns true if all the names and values in header are
+// ones http.Transport will send rather than rejecting the request
+func validHeader(header http.Header) bool {
+	for name, values := range header {
+		if !validToken(name) {
+			return false
+		}
+		for _, value := range values {
+			for i := 0; i < len(value); i++ {
+				if c := value[i]; (c < ' ' && c != '\t') || c == 0x7f {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,209 @@
+package debughttp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newDumpTestRequest makes a request for the dump tests
+func newDumpTestRequest(t testing.TB, method, URL, body string, header http.Header) *http.Request {
+	var req *http.Request
+	var err error
+	if body == "" {
+		req, err = http.NewRequest(method, URL, nil)
+	} else {
+		req, err = http.NewRequest(method, URL, strings.NewReader(body))
+	}
+	require.NoError(t, err)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	return req
+}
+
+func TestFastDumpRequest(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		method string
+		URL    string
+		body   string
+		header http.Header
+		fast   bool
+	}{
+		{"Get", "GET", "http://example.com/path?a=b&c=d%20e", "", nil, true},
+		{"Https", "GET", "https://example.com:8443/", "", nil, true},
+		{"Headers", "GET", "http://example.com/", "", http.Header{
+			"X-Multi":       {"one", "two"},
+			"Authorization": {"secret"},
+			"lower-case":    {"x"},
+			"Host":          {"ignored.com"},
+		}, true},
+		{"UserAgent", "GET", "http://example.com/", "", http.Header{"User-Agent": {"  agent/1.0 "}}, true},
+		{"EmptyUserAgent", "GET", "http://example.com/", "", http.Header{"User-Agent": {""}}, true},
+		{"AcceptEncoding", "GET", "http://example.com/", "", http.Header{"Accept-Encoding": {"br"}}, true},
+		{"Range", "GET", "http://example.com/", "", http.Header{"Range": {"bytes=0-1"}}, true},
+		{"Head", "HEAD", "http://example.com/", "", nil, true},
+		{"PostEmpty", "POST", "http://example.com/", "", nil, true},
+		{"Post", "POST", "http://example.com/", "body", http.Header{"Content-Type": {"text/plain"}}, true},
+		{"Put", "PUT", "http://example.com/", "body", nil, true},
+		{"Delete", "DELETE", "http://example.com/x", "", nil, true},
+		{"IPv6", "GET", "http://[::1]:8080/", "", nil, true},
+		{"IPv6Zone", "GET", "http://[fe80::1%25eth0]:8080/", "", nil, false},
+		{"Expect", "PUT", "http://example.com/", "body", http.Header{"Expect": {"100-continue"}}, false},
+		{"BadHeader", "GET", "http://example.com/", "", http.Header{"X-Bad": {"a\x01b"}}, false},
+		{"Tab", "GET", "http://example.com/", "", http.Header{"X-Tab": {"a\tb"}}, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req := newDumpTestRequest(t, test.method, test.URL, test.body, test.header)
+			got, ok := fastDumpRequest(req)
+			assert.Equal(t, test.fast, ok)
+			if !ok {
+				return
+			}
+			want, err := httputil.DumpRequestOut(req, false)
+			require.NoError(t, err)
+			assert.Equal(t, string(want), string(got))
+			if test.body == "" {
+				want, err = httputil.DumpRequestOut(req, true)
+				require.NoError(t, err)
+				assert.Equal(t, string(want), string(got))
+			}
+		})
+	}
+}
+
+func TestFastDumpRequestFallback(t *testing.T) {
+	// Unknown length bodies aren't done by the fast path
+	req := newDumpTestRequest(t, "POST", "http://example.com/", "", nil)
+	req.Body = ioutil.NopCloser(strings.NewReader("body"))
+	_, ok := fastDumpRequest(req)
+	assert.False(t, ok)
+	got, err := dumpRequestHeaders(req)
+	require.NoError(t, err)
+	want, err := httputil.DumpRequestOut(req, false)
+	require.NoError(t, err)
+	assert.Equal(t, string(want), string(got))
+}
+
+func TestFastDumpResponse(t *testing.T) {
+	newResp := func(method string, status int, contentLength int64, header http.Header) *http.Response {
+		req, err := http.NewRequest(method, "http://example.com/", nil)
+		require.NoError(t, err)
+		body := make([]byte, 0, 16)
+		if contentLength > 0 {
+			body = make([]byte, contentLength)
+		}
+		return &http.Response{
+			Status:        http.StatusText(status),
+			StatusCode:    status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			ContentLength: contentLength,
+			Body:          ioutil.NopCloser(bytes.NewReader(body)),
+			Request:       req,
+		}
+	}
+	for _, test := range []struct {
+		name string
+		resp *http.Response
+		fast bool
+	}{
+		{"OK", newResp("GET", 200, 10, http.Header{"Content-Type": {"text/plain"}, "Content-Length": {"10"}}), true},
+		{"Empty", newResp("GET", 200, 0, http.Header{"X-A": {"b", "c"}}), true},
+		{"EmptyPost", newResp("POST", 201, 0, nil), true},
+		{"NoContent", newResp("DELETE", 204, 0, nil), true},
+		{"NotModified", newResp("GET", 304, 0, nil), true},
+		{"Redirect", newResp("GET", 302, 0, http.Header{"Location": {"/next"}}), true},
+		{"Unknown", newResp("GET", 299, 5, nil), true},
+		{"HTTP2", func() *http.Response {
+			resp := newResp("GET", 200, 5, nil)
+			resp.Proto, resp.ProtoMajor, resp.ProtoMinor = "HTTP/2.0", 2, 0
+			return resp
+		}(), true},
+		{"FullStatus", func() *http.Response {
+			resp := newResp("GET", 200, 5, nil)
+			resp.Status = "200 All Good"
+			return resp
+		}(), true},
+		{"UnknownLength", newResp("GET", 200, -1, nil), false},
+		{"Chunked", func() *http.Response {
+			resp := newResp("GET", 200, 5, nil)
+			resp.TransferEncoding = []string{"chunked"}
+			return resp
+		}(), false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := fastDumpResponse(test.resp)
+			assert.Equal(t, test.fast, ok)
+			want, err := httputil.DumpResponse(test.resp, false)
+			require.NoError(t, err)
+			if ok {
+				assert.Equal(t, string(want), string(got))
+			}
+			got, err = dumpResponseHeaders(test.resp)
+			require.NoError(t, err)
+			assert.Equal(t, string(want), string(got))
+		})
+	}
+}
+
+// benchmarkHeader is a typical set of request headers
+var benchmarkHeader = http.Header{
+	"Authorization": {"Bearer token"},
+	"Content-Type":  {"application/json"},
+	"X-Request-Id":  {"1234567890"},
+	"Accept":        {"application/json"},
+}
+
+func BenchmarkDumpRequestHeaders(b *testing.B) {
+	req := newDumpTestRequest(b, "GET", "https://example.com/path?a=b", "", benchmarkHeader)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = dumpRequestHeaders(req)
+	}
+}
+
+func BenchmarkDumpRequestOut(b *testing.B) {
+	req := newDumpTestRequest(b, "GET", "https://example.com/path?a=b", "", benchmarkHeader)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = httputil.DumpRequestOut(req, false)
+	}
+}
+
+// newBenchmarkResponse makes a response for the benchmarks
+func newBenchmarkResponse() *http.Response {
+	return &http.Response{
+		StatusCode:    200,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        benchmarkHeader,
+		ContentLength: 100,
+		Body:          http.NoBody,
+	}
+}
+
+func BenchmarkDumpResponseHeaders(b *testing.B) {
+	resp := newBenchmarkResponse()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = dumpResponseHeaders(resp)
+	}
+}
+
+func BenchmarkDumpResponse(b *testing.B) {
+	resp := newBenchmarkResponse()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = httputil.DumpResponse(resp, false)
+	}
+}
@@ -0,0 +1,382 @@
+package debughttp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// BodyFormatter reformats a body for display. It returns ok false if
+// it can't format the body in which case the body is shown as is.
+type BodyFormatter func(body []byte) (out []byte, ok bool)
+
+// BodyFormatters maps media types to the BodyFormatter used to show
+// bodies of that type if FormatBodies is set in Options.
+//
+// Media types with a "+json" suffix (eg "application/problem+json")
+// use the formatter for "application/json".
+var BodyFormatters = map[string]BodyFormatter{
+	"application/json":         formatJSON,
+	"application/octet-stream": formatHex,
+	"application/x-protobuf":   formatHex,
+	"application/protobuf":     formatHex,
+	"text/html":                formatHTML,
+}
+
+// formatJSON indents JSON bodies
+func formatJSON(body []byte) ([]byte, bool) {
+	var out bytes.Buffer
+	if err := json.Indent(&out, body, "", "  "); err != nil {
+		return nil, false
+	}
+	out.WriteByte('\n')
+	return out.Bytes(), true
+}
+
+// formatHex shows binary bodies as a hex dump
+func formatHex(body []byte) ([]byte, bool) {
+	return []byte(hex.Dump(body)), true
+}
+
+// htmlVoidElements are the HTML elements which have no closing tag
+var htmlVoidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true,
+	"hr": true, "img": true, "input": true, "link": true, "meta": true,
+	"param": true, "source": true, "track": true, "wbr": true,
+}
+
+// htmlRawElements are the HTML elements whose content is shown as is
+var htmlRawElements = map[string]bool{
+	"pre": true, "script": true, "style": true, "textarea": true,
+}
+
+// htmlTagName returns the lower case name of the element in tag, eg
+// "div" for "<div class=x>" or "</DIV>"
+func htmlTagName(tag string) string {
+	name := strings.TrimLeft(tag[1:], "/")
+	if i := strings.IndexAny(name, " \t\r\n/>"); i >= 0 {
+		name = name[:i]
+	}
+	return strings.ToLower(name)
+}
+
+// formatHTML indents HTML bodies one tag or run of text per line.
+//
+// It doesn't parse the HTML properly so it can be confused by missing
+// closing tags but it fails if the tags can't be matched up at all.
+func formatHTML(body []byte) ([]byte, bool) {
+	if !isText(body) {
+		return nil, false
+	}
+	var out bytes.Buffer
+	depth := 0
+	line := func(s string) {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			return
+		}
+		out.WriteString(strings.Repeat("  ", depth))
+		out.WriteString(s)
+		out.WriteByte('\n')
+	}
+	in := string(body)
+	for len(in) > 0 {
+		start := strings.IndexByte(in, '<')
+		if start < 0 {
+			line(in)
+			break
+		}
+		line(in[:start])
+		in = in[start:]
+		end := ">"
+		if strings.HasPrefix(in, "<!--") {
+			end = "-->"
+		}
+		i := strings.Index(in, end)
+		if i < 0 {
+			return nil, false
+		}
+		tag := in[:i+len(end)]
+		in = in[i+len(end):]
+		name := htmlTagName(tag)
+		switch {
+		case strings.HasPrefix(tag, "<!") || strings.HasPrefix(tag, "<?"):
+			line(tag)
+		case strings.HasPrefix(tag, "</"):
+			depth--
+			if depth < 0 {
+				return nil, false
+			}
+			line(tag)
+		case htmlRawElements[name]:
+			line(tag)
+			closing := strings.Index(strings.ToLower(in), "</"+name)
+			if closing < 0 {
+				return nil, false
+			}
+			depth++
+			line(in[:closing])
+			in = in[closing:]
+		case htmlVoidElements[name] || strings.HasSuffix(tag, "/>"):
+			line(tag)
+		default:
+			line(tag)
+			depth++
+		}
+	}
+	return out.Bytes(), true
+}
+
+// findBodyFormatter returns the formatter for contentType or nil
+func findBodyFormatter(formatters map[string]BodyFormatter, contentType string) BodyFormatter {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil
+	}
+	if formatter, ok := formatters[mediaType]; ok {
+		return formatter
+	}
+	if strings.HasSuffix(mediaType, "+json") {
+		return formatters["application/json"]
+	}
+	return nil
+}
+
+// sniffLen is the number of bytes of the body used to sniff its type
+const sniffLen = 512
+
+// sniffContentType guesses the type of body with
+// http.DetectContentType, recognising JSON too which it shows as text
+func sniffContentType(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	sniff := body
+	if len(sniff) > sniffLen {
+		sniff = sniff[:sniffLen]
+	}
+	contentType := http.DetectContentType(sniff)
+	if strings.HasPrefix(contentType, "text/plain") {
+		trimmed := bytes.TrimSpace(body)
+		if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid(trimmed) {
+			return "application/json"
+		}
+	}
+	return contentType
+}
+
+// dumpContentType returns contentType, the Content-Type header of the
+// dump in buf, or if that is empty the type sniffed from its body
+func dumpContentType(buf []byte, contentType string) string {
+	if contentType != "" {
+		return contentType
+	}
+	i := bytes.Index(buf, []byte("\r\n\r\n"))
+	if i < 0 {
+		return ""
+	}
+	return sniffContentType(buf[i+4:])
+}
+
+// formatBody reformats the body in the dump in buf according to
+// contentType. The dump is returned unchanged if there is no
+// formatter for contentType or the formatter fails.
+func formatBody(buf []byte, contentType string, formatters map[string]BodyFormatter) []byte {
+	return formatBodyWith(buf, findBodyFormatter(formatters, contentType))
+}
+
+// formatBodyWith reformats the body in the dump in buf with formatter.
+// The dump is returned unchanged if formatter is nil or fails.
+func formatBodyWith(buf []byte, formatter BodyFormatter) []byte {
+	if formatter == nil {
+		return buf
+	}
+	i := bytes.Index(buf, []byte("\r\n\r\n"))
+	if i < 0 {
+		return buf
+	}
+	i += 4
+	if i == len(buf) {
+		return buf
+	}
+	body, ok := formatter(buf[i:])
+	if !ok {
+		return buf
+	}
+	return append(buf[:i:i], body...)
+}
+
+// elidedJSONKey is the key added to a JSON object reduced by
+// IncludeJSONFields to show that some of its fields were left out
+const elidedJSONKey = "..."
+
+// filterJSON returns v reduced to the paths given or found false if
+// none of them are in v. The fields of objects in arrays are matched
+// against the same paths as the array.
+func filterJSON(v interface{}, paths [][]string) (out interface{}, found bool) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{})
+		for key, value := range v {
+			var rest [][]string
+			for _, path := range paths {
+				if path[0] != key {
+					continue
+				}
+				if len(path) == 1 {
+					out[key] = value
+					break
+				}
+				rest = append(rest, path[1:])
+			}
+			if _, ok := out[key]; ok || len(rest) == 0 {
+				continue
+			}
+			if value, ok := filterJSON(value, rest); ok {
+				out[key] = value
+			}
+		}
+		if len(out) == 0 {
+			return nil, false
+		}
+		if len(out) < len(v) {
+			out[elidedJSONKey] = elidedJSONKey
+		}
+		return out, true
+	case []interface{}:
+		out := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			if item, ok := filterJSON(item, paths); ok {
+				out = append(out, item)
+			}
+		}
+		return out, len(out) > 0
+	}
+	return nil, false
+}
+
+// includeJSONFields reduces the body in the dump in buf to the fields
+// given as dotted paths, eg "error.message", if it is a JSON object or
+// array. The dump is returned unchanged if the body isn't valid JSON.
+func includeJSONFields(buf []byte, fields []string) []byte {
+	i := bytes.Index(buf, []byte("\r\n\r\n"))
+	if i < 0 {
+		return buf
+	}
+	i += 4
+	return append(buf[:i:i], includeJSONFieldsBody(buf[i:], fields)...)
+}
+
+// includeJSONFieldsBody is includeJSONFields for a body on its own
+func includeJSONFieldsBody(body []byte, fields []string) []byte {
+	var v interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil || decoder.More() {
+		return body
+	}
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+	default:
+		return body
+	}
+	paths := make([][]string, len(fields))
+	for j, field := range fields {
+		paths[j] = strings.Split(field, ".")
+	}
+	v, ok := filterJSON(v, paths)
+	if !ok {
+		v = map[string]interface{}{elidedJSONKey: elidedJSONKey}
+	}
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return body
+	}
+	return out.Bytes()
+}
+
+// minBase64Len is the shortest body decodeBase64Body will decode to
+// avoid decoding short words which happen to be valid base64
+const minBase64Len = 16
+
+// base64Encodings are the encodings decodeBase64Body tries in order
+var base64Encodings = []*base64.Encoding{
+	base64.StdEncoding.Strict(),
+	base64.URLEncoding.Strict(),
+	base64.RawStdEncoding.Strict(),
+	base64.RawURLEncoding.Strict(),
+}
+
+// isText returns true if data looks like printable text
+func isText(data []byte) bool {
+	if !utf8.Valid(data) {
+		return false
+	}
+	for _, c := range data {
+		if c < ' ' && c != '\t' && c != '\r' && c != '\n' || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeBase64Body adds the decoded body to the dump in buf if the
+// whole body is valid base64. The decoded body is shown as text if
+// it is printable or as a hex dump otherwise.
+func decodeBase64Body(buf []byte) []byte {
+	i := bytes.Index(buf, []byte("\r\n\r\n"))
+	if i < 0 {
+		return buf
+	}
+	body := bytes.TrimSpace(buf[i+4:])
+	if len(body) < minBase64Len {
+		return buf
+	}
+	for _, encoding := range base64Encodings {
+		decoded, err := encoding.DecodeString(string(body))
+		if err != nil {
+			continue
+		}
+		var out bytes.Buffer
+		out.Write(buf)
+		if buf[len(buf)-1] != '\n' {
+			out.WriteByte('\n')
+		}
+		if isText(decoded) {
+			fmt.Fprintf(&out, "--- base64 decoded body (%d bytes, text) ---\n", len(decoded))
+			out.Write(decoded)
+			if decoded[len(decoded)-1] != '\n' {
+				out.WriteByte('\n')
+			}
+		} else {
+			fmt.Fprintf(&out, "--- base64 decoded body (%d bytes, binary) ---\n", len(decoded))
+			out.WriteString(hex.Dump(decoded))
+		}
+		return out.Bytes()
+	}
+	return buf
+}
+
+// quoteBody replaces the body in the dump in buf with it quoted as a
+// Go string literal.
+func quoteBody(buf []byte) []byte {
+	i := bytes.Index(buf, []byte("\r\n\r\n"))
+	if i < 0 {
+		return buf
+	}
+	i += 4
+	if i == len(buf) {
+		return buf
+	}
+	out := strconv.AppendQuote(buf[:i:i], string(buf[i:]))
+	return append(out, '\n')
+}
@@ -0,0 +1,242 @@
+package debughttp
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindBodyFormatter(t *testing.T) {
+	for _, test := range []struct {
+		contentType string
+		want        bool
+	}{
+		{"", false},
+		{"text/plain", false},
+		{"application/json", true},
+		{"application/json; charset=utf-8", true},
+		{"Application/JSON", true},
+		{"application/problem+json", true},
+		{"application/x-protobuf", true},
+		{"text/html; charset=utf-8", true},
+		{"not a / media type", false},
+	} {
+		got := findBodyFormatter(BodyFormatters, test.contentType) != nil
+		assert.Equal(t, test.want, got, test.contentType)
+	}
+}
+
+func TestFormatBody(t *testing.T) {
+	const header = "HTTP/1.1 200 OK\r\n\r\n"
+	for _, test := range []struct {
+		in          string
+		contentType string
+		want        string
+	}{
+		{header, "application/json", header},
+		{header + `{"a":1}`, "text/plain", header + `{"a":1}`},
+		{header + `{"a":1}`, "application/json", header + "{\n  \"a\": 1\n}\n"},
+		{header + `{"a":`, "application/json", header + `{"a":`},
+		{header + "\x00\x01", "application/octet-stream", header + "00000000  00 01                                             |..|\n"},
+	} {
+		got := string(formatBody([]byte(test.in), test.contentType, BodyFormatters))
+		assert.Equal(t, test.want, got, test.in)
+	}
+}
+
+func TestFormatHTML(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"", "", true},
+		{"just text", "just text\n", true},
+		{
+			in:   `<!DOCTYPE html><html><head><meta charset="utf-8"><title>Hi</title></head><body><p>Hello<br/>there</p><!-- a <b>comment</b> --><IMG src=x></body></html>`,
+			want: "<!DOCTYPE html>\n<html>\n  <head>\n    <meta charset=\"utf-8\">\n    <title>\n      Hi\n    </title>\n  </head>\n  <body>\n    <p>\n      Hello\n      <br/>\n      there\n    </p>\n    <!-- a <b>comment</b> -->\n    <IMG src=x>\n  </body>\n</html>\n",
+			ok:   true,
+		},
+		{
+			in:   "<div><script>if (a < b) { x = \"<p>\" }</script></div>",
+			want: "<div>\n  <script>\n    if (a < b) { x = \"<p>\" }\n  </script>\n</div>\n",
+			ok:   true,
+		},
+		{in: "<div>unterminated <b", ok: false},
+		{in: "</div>", ok: false},
+		{in: "<script>never closed", ok: false},
+		{in: "<p>\x00</p>", ok: false},
+	} {
+		got, ok := formatHTML([]byte(test.in))
+		assert.Equal(t, test.ok, ok, test.in)
+		if test.ok {
+			assert.Equal(t, test.want, string(got), test.in)
+		}
+	}
+}
+
+func TestFormatBodiesPerDirection(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		_, _ = w.Write([]byte{0x08, 0x96, 0x01})
+	}))
+	defer ts.Close()
+
+	var lines []string
+	client := NewClient(&Options{
+		Flags:        DumpBodies,
+		FormatBodies: true,
+		Logf: func(format string, v ...interface{}) {
+			lines = append(lines, fmt.Sprintf(format, v...))
+		},
+	})
+	req, err := http.NewRequest("POST", ts.URL, strings.NewReader(`{"a":1}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	require.Equal(t, 8, len(lines))
+	assert.True(t, strings.HasSuffix(lines[2], "\r\n\r\n{\n  \"a\": 1\n}\n"), lines[2])
+	assert.True(t, strings.HasSuffix(lines[6], "\r\n\r\n00000000  08 96 01                                          |...|\n"), lines[6])
+}
+
+func TestSniffContentType(t *testing.T) {
+	for _, test := range []struct {
+		body string
+		want string
+	}{
+		{"", ""},
+		{`{"a":1}`, "application/json"},
+		{" [1, 2]\n", "application/json"},
+		{`{"a":`, "text/plain; charset=utf-8"},
+		{"hello", "text/plain; charset=utf-8"},
+		{"<!DOCTYPE html><html></html>", "text/html; charset=utf-8"},
+		{"\x00\x01\x02", "application/octet-stream"},
+	} {
+		assert.Equal(t, test.want, sniffContentType([]byte(test.body)), test.body)
+	}
+	assert.Equal(t, "text/html", dumpContentType([]byte("HTTP/1.1 200 OK\r\n\r\n{}"), "text/html"))
+	assert.Equal(t, "application/json", dumpContentType([]byte("HTTP/1.1 200 OK\r\n\r\n{}"), ""))
+	assert.Equal(t, "", dumpContentType([]byte("HTTP/1.1 200 OK\r\n"), ""))
+}
+
+func TestSniffedFormatBodies(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Stop net/http setting the Content-Type
+		w.Header()["Content-Type"] = nil
+		_, _ = w.Write([]byte(`{"a":1}`))
+	}))
+	defer ts.Close()
+
+	client, capture := NewCaptureClient(&Options{
+		Flags:        DumpBodies,
+		FormatBodies: true,
+	})
+	resp, err := client.Get(ts.URL)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	// The response is unchanged
+	assert.Equal(t, `{"a":1}`, string(body))
+	assert.Empty(t, resp.Header.Get("Content-Type"))
+
+	lines := capture.Lines()
+	require.Equal(t, 8, len(lines))
+	assert.NotContains(t, lines[6], "Content-Type")
+	assert.True(t, strings.HasSuffix(lines[6], "\r\n\r\n{\n  \"a\": 1\n}\n"), lines[6])
+}
+
+func TestIncludeJSONFields(t *testing.T) {
+	const header = "HTTP/1.1 200 OK\r\n\r\n"
+	body := `{"status":"failed","error":{"code":42,"message":"bad <thing>","trace":"long"},"items":[{"id":1,"data":"x"},{"id":2},{"data":"y"}],"big":"ignore me"}`
+	for _, test := range []struct {
+		fields []string
+		in     string
+		want   string
+	}{
+		{[]string{"status"}, header, header},
+		{[]string{"status"}, header + "not json", header + "not json"},
+		{[]string{"status"}, header + `{"status":`, header + `{"status":`},
+		{[]string{"status"}, header + `"just a string"`, header + `"just a string"`},
+		{[]string{"status"}, header + `{"a":1} {"b":2}`, header + `{"a":1} {"b":2}`},
+		{[]string{"status"}, header + `{"status":"ok"}`, header + `{"status":"ok"}` + "\n"},
+		{[]string{"status", "error"}, header + body, header + `{"...":"...","error":{"code":42,"message":"bad <thing>","trace":"long"},"status":"failed"}` + "\n"},
+		{[]string{"status", "error.message"}, header + body, header + `{"...":"...","error":{"...":"...","message":"bad <thing>"},"status":"failed"}` + "\n"},
+		{[]string{"items.id"}, header + body, header + `{"...":"...","items":[{"...":"...","id":1},{"id":2}]}` + "\n"},
+		{[]string{"missing", "error.missing", "status.nested"}, header + body, header + `{"...":"..."}` + "\n"},
+		{[]string{"id"}, header + `[{"id":1.50,"x":2},{"y":3}]`, header + `[{"...":"...","id":1.50}]` + "\n"},
+	} {
+		got := string(includeJSONFields([]byte(test.in), test.fields))
+		assert.Equal(t, test.want, got, test.in)
+	}
+}
+
+func TestDecodeBase64Body(t *testing.T) {
+	const header = "HTTP/1.1 200 OK\r\n\r\n"
+	text := "Hello, this is a secret message"
+	binary := []byte{0x00, 0x01, 0x02, 0xff, 0xfe, 0xfd, 0x00, 0x01, 0x02, 0xff, 0xfe, 0xfd}
+	for _, test := range []struct {
+		in   string
+		want string
+	}{
+		{header, header},
+		{header + "Hello", header + "Hello"},
+		{header + "SGVsbG8=", header + "SGVsbG8="},
+		{header + "not base64 at all, it has spaces", header + "not base64 at all, it has spaces"},
+		{
+			header + base64.StdEncoding.EncodeToString([]byte(text)),
+			header + base64.StdEncoding.EncodeToString([]byte(text)) + "\n--- base64 decoded body (31 bytes, text) ---\n" + text + "\n",
+		},
+		{
+			header + base64.RawURLEncoding.EncodeToString([]byte(text)) + "\n",
+			header + base64.RawURLEncoding.EncodeToString([]byte(text)) + "\n--- base64 decoded body (31 bytes, text) ---\n" + text + "\n",
+		},
+		{
+			header + base64.StdEncoding.EncodeToString(binary),
+			header + base64.StdEncoding.EncodeToString(binary) + "\n--- base64 decoded body (12 bytes, binary) ---\n" + hex.Dump(binary),
+		},
+	} {
+		got := string(decodeBase64Body([]byte(test.in)))
+		assert.Equal(t, test.want, got, test.in)
+	}
+}
+
+func TestQuoteBody(t *testing.T) {
+	const header = "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\n\r\n"
+	for _, test := range []struct {
+		in   string
+		want string
+	}{
+		{"no header", "no header"},
+		{header, header},
+		{header + "hello", header + `"hello"` + "\n"},
+		{header + "line 1\nline 2\n", header + `"line 1\nline 2\n"` + "\n"},
+		{header + "say \"hi\"\t\\", header + `"say \"hi\"\t\\"` + "\n"},
+		{header + "\x00\x01\xff", header + `"\x00\x01\xff"` + "\n"},
+		{header + "héllo ☺", header + `"héllo ☺"` + "\n"},
+	} {
+		got := string(quoteBody([]byte(test.in)))
+		assert.Equal(t, test.want, got, test.in)
+		// The body must be a valid Go string literal of the original
+		if i := strings.Index(test.in, "\r\n\r\n"); i >= 0 && i+4 < len(test.in) {
+			unquoted, err := strconv.Unquote(strings.TrimSuffix(got[i+4:], "\n"))
+			require.NoError(t, err)
+			assert.Equal(t, test.in[i+4:], unquoted)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package debughttp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// grpcCodes are the names of the gRPC status codes indexed by code
+var grpcCodes = []string{
+	"OK",
+	"CANCELLED",
+	"UNKNOWN",
+	"INVALID_ARGUMENT",
+	"DEADLINE_EXCEEDED",
+	"NOT_FOUND",
+	"ALREADY_EXISTS",
+	"PERMISSION_DENIED",
+	"RESOURCE_EXHAUSTED",
+	"FAILED_PRECONDITION",
+	"ABORTED",
+	"OUT_OF_RANGE",
+	"UNIMPLEMENTED",
+	"INTERNAL",
+	"UNAVAILABLE",
+	"DATA_LOSS",
+	"UNAUTHENTICATED",
+}
+
+// isGRPC returns true if resp is a gRPC response
+func isGRPC(resp *http.Response) bool {
+	return strings.HasPrefix(resp.Header.Get("Content-Type"), "application/grpc")
+}
+
+// grpcStatusLine returns a line showing the gRPC status of resp, eg
+// `grpc-status: 5 NOT_FOUND grpc-message: "no such file"`, or "" if
+// it doesn't have one.
+//
+// The status is normally in the trailers so they are only available
+// once the body has been read, but a response without a body may put
+// it in the headers instead.
+func grpcStatusLine(resp *http.Response) string {
+	header := resp.Trailer
+	if header.Get("Grpc-Status") == "" {
+		header = resp.Header
+	}
+	status := strings.TrimSpace(header.Get("Grpc-Status"))
+	if status == "" {
+		return ""
+	}
+	line := "grpc-status: " + status
+	if code, err := strconv.Atoi(status); err == nil && code >= 0 && code < len(grpcCodes) {
+		line += " " + grpcCodes[code]
+	}
+	if message := header.Get("Grpc-Message"); message != "" {
+		// The message is percent encoded
+		if decoded, err := url.PathUnescape(message); err == nil {
+			message = decoded
+		}
+		line += fmt.Sprintf(" grpc-message: %q", message)
+	}
+	return line
+}
+
+// grpcBody wraps the body of a gRPC response whose trailers haven't
+// been read yet so the status can be logged when they have.
+type grpcBody struct {
+	io.ReadCloser
+	t    *Transport
+	tx   *transaction
+	once sync.Once
+}
+
+// newGRPCBody wraps the response body of tx in a grpcBody
+func newGRPCBody(t *Transport, tx *transaction) *grpcBody {
+	return &grpcBody{
+		ReadCloser: tx.resp.Body,
+		t:          t,
+		tx:         tx,
+	}
+}
+
+// Read reads from the body logging the gRPC status at EOF
+func (b *grpcBody) Read(p []byte) (n int, err error) {
+	n, err = b.ReadCloser.Read(p)
+	if errors.Is(err, io.EOF) {
+		b.once.Do(func() {
+			if line := grpcStatusLine(b.tx.resp); line != "" {
+				b.t.logf(b.tx.req, "%s (%s): %s", "HTTP RESPONSE TRAILERS", b.tx.id(), line)
+			}
+		})
+	}
+	return n, err
+}
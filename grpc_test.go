@@ -0,0 +1,70 @@
+package debughttp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGRPCStatusLine(t *testing.T) {
+	for _, test := range []struct {
+		header  http.Header
+		trailer http.Header
+		want    string
+	}{
+		{nil, nil, ""},
+		{nil, http.Header{"Grpc-Status": {"0"}}, "grpc-status: 0 OK"},
+		{nil, http.Header{"Grpc-Status": {"5"}, "Grpc-Message": {"no such file"}}, `grpc-status: 5 NOT_FOUND grpc-message: "no such file"`},
+		{nil, http.Header{"Grpc-Status": {"13"}, "Grpc-Message": {"bad%20thing%0Ahappened"}}, `grpc-status: 13 INTERNAL grpc-message: "bad thing\nhappened"`},
+		{nil, http.Header{"Grpc-Status": {"99"}}, "grpc-status: 99"},
+		{http.Header{"Grpc-Status": {"16"}}, nil, "grpc-status: 16 UNAUTHENTICATED"},
+		{http.Header{"Grpc-Status": {"16"}}, http.Header{"Grpc-Status": {"7"}}, "grpc-status: 7 PERMISSION_DENIED"},
+	} {
+		resp := &http.Response{Header: test.header, Trailer: test.trailer}
+		assert.Equal(t, test.want, grpcStatusLine(resp))
+	}
+}
+
+func TestGRPCTrailers(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		_, _ = w.Write([]byte("\x00\x00\x00\x00\x00"))
+		w.Header().Set("Grpc-Status", "5")
+		w.Header().Set("Grpc-Message", "file%20not%20found")
+	}))
+	defer ts.Close()
+
+	const want = `grpc-status: 5 NOT_FOUND grpc-message: "file not found"`
+	for _, test := range []struct {
+		name  string
+		flags DumpFlags
+	}{
+		{"Bodies", DumpBodies},
+		{"Headers", DumpHeaders},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			client, capture := NewCaptureClient(&Options{
+				Flags: test.flags,
+			})
+			resp, err := client.Get(ts.URL)
+			require.NoError(t, err)
+			_, err = ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			lines := capture.Lines()
+			found := 0
+			for _, line := range lines {
+				if strings.Contains(line, want) {
+					found++
+				}
+			}
+			assert.Equal(t, 1, found, capture.String())
+		})
+	}
+}
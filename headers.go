@@ -0,0 +1,256 @@
+package debughttp
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/textproto"
+)
+
+// dumpSections is a dump split into its parts
+type dumpSections struct {
+	start   []byte   // the request or status line including its line ending
+	headers [][]byte // the header lines without line endings
+	eol     []byte   // the line ending used
+	rest    []byte   // the blank line after the headers and the body, if any
+}
+
+// splitDump splits the dump in buf into sections. It returns ok false
+// if buf doesn't have a complete first line.
+func splitDump(buf []byte) (d dumpSections, ok bool) {
+	i := bytes.IndexByte(buf, '\n')
+	if i < 0 {
+		return d, false
+	}
+	d.start = buf[:i+1]
+	d.eol = []byte("\n")
+	if i > 0 && buf[i-1] == '\r' {
+		d.eol = []byte("\r\n")
+	}
+	pos := i + 1
+	for pos < len(buf) {
+		next := bytes.IndexByte(buf[pos:], '\n')
+		if next < 0 {
+			next = len(buf)
+		} else {
+			next += pos + 1
+		}
+		line := bytes.TrimRight(buf[pos:next], "\r\n")
+		if len(line) == 0 {
+			break
+		}
+		d.headers = append(d.headers, line)
+		pos = next
+	}
+	d.rest = buf[pos:]
+	return d, true
+}
+
+// join puts the sections back together into a dump
+func (d *dumpSections) join() []byte {
+	n := len(d.start) + len(d.rest)
+	for _, header := range d.headers {
+		n += len(header) + len(d.eol)
+	}
+	out := make([]byte, 0, n)
+	out = append(out, d.start...)
+	for _, header := range d.headers {
+		out = append(out, header...)
+		out = append(out, d.eol...)
+	}
+	return append(out, d.rest...)
+}
+
+// splitHeader splits a header line into its canonical name and its
+// value. ok is false if it isn't a header line.
+func splitHeader(line []byte) (name string, value []byte, ok bool) {
+	colon := bytes.IndexByte(line, ':')
+	if colon <= 0 {
+		return "", nil, false
+	}
+	return textproto.CanonicalMIMEHeaderKey(string(line[:colon])), bytes.TrimSpace(line[colon+1:]), true
+}
+
+// foldHeaders combines headers with the same name in the dump in buf
+// into one line with the values separated by ", ", in the position of
+// the first one.
+//
+// RFC 7230 allows this for headers which are defined as comma
+// separated lists, which is nearly all of them. The exception is
+// Set-Cookie (RFC 6265) whose values may contain commas, eg in the
+// Expires attribute, so folding them would be ambiguous. Set-Cookie
+// headers are never folded.
+func foldHeaders(buf []byte) []byte {
+	d, ok := splitDump(buf)
+	if !ok {
+		return buf
+	}
+	first := make(map[string]int, len(d.headers))
+	headers := make([][]byte, 0, len(d.headers))
+	for _, line := range d.headers {
+		name, value, ok := splitHeader(line)
+		if !ok || name == "Set-Cookie" {
+			headers = append(headers, line)
+			continue
+		}
+		if j, found := first[name]; found {
+			folded := headers[j][:len(headers[j]):len(headers[j])]
+			folded = append(folded, ", "...)
+			headers[j] = append(folded, value...)
+			continue
+		}
+		first[name] = len(headers)
+		headers = append(headers, line)
+	}
+	if len(headers) == len(d.headers) {
+		return buf
+	}
+	d.headers = headers
+	return d.join()
+}
+
+// collapseHeaders replaces each run of identical consecutive header
+// lines in the dump in buf with the first one followed by the number
+// of lines in the run, eg "Via: 1.1 cdn (x5)".
+//
+// Unlike foldHeaders this doesn't combine the values, it only makes
+// the dump shorter.
+func collapseHeaders(buf []byte) []byte {
+	d, ok := splitDump(buf)
+	if !ok {
+		return buf
+	}
+	headers := make([][]byte, 0, len(d.headers))
+	for i := 0; i < len(d.headers); {
+		line := d.headers[i]
+		n := 1
+		for i+n < len(d.headers) && bytes.Equal(d.headers[i+n], line) {
+			n++
+		}
+		if n > 1 {
+			line = append(line[:len(line):len(line)], fmt.Sprintf(" (x%d)", n)...)
+		}
+		headers = append(headers, line)
+		i += n
+	}
+	if len(headers) == len(d.headers) {
+		return buf
+	}
+	d.headers = headers
+	return d.join()
+}
+
+// alignHeaders pads the names of the headers in the dump in buf with
+// spaces after the colon so the values all start in the same column.
+// The request or status line and the body are left as they are.
+func alignHeaders(buf []byte) []byte {
+	d, ok := splitDump(buf)
+	if !ok {
+		return buf
+	}
+	width := 0
+	for _, line := range d.headers {
+		if colon := headerColon(line); colon > width {
+			width = colon
+		}
+	}
+	if width == 0 {
+		return buf
+	}
+	for i, line := range d.headers {
+		colon := headerColon(line)
+		if colon < 0 {
+			continue
+		}
+		value := bytes.TrimLeft(line[colon+1:], " \t")
+		aligned := make([]byte, 0, width+2+len(value))
+		aligned = append(aligned, line[:colon+1]...)
+		aligned = append(aligned, bytes.Repeat([]byte(" "), width-colon+1)...)
+		d.headers[i] = append(aligned, value...)
+	}
+	return d.join()
+}
+
+// headerColon returns the index of the colon after the name in the
+// header line or -1 if it isn't one, eg a folded continuation line
+func headerColon(line []byte) int {
+	if len(line) == 0 || line[0] == ' ' || line[0] == '\t' {
+		return -1
+	}
+	colon := bytes.IndexByte(line, ':')
+	if colon <= 0 {
+		return -1
+	}
+	return colon
+}
+
+// fallbackRequest returns the request line and headers of req in the
+// same format as httputil.DumpRequestOut for when it fails.
+func fallbackRequest(req *http.Request) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s HTTP/1.1\r\n", req.Method, req.URL.RequestURI())
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	fmt.Fprintf(&buf, "Host: %s\r\n", host)
+	_ = req.Header.Write(&buf)
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
+
+// fallbackResponse returns the status line and headers of resp in the
+// same format as httputil.DumpResponse for when it fails.
+func fallbackResponse(resp *http.Response) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s\r\n", resp.Proto, resp.Status)
+	_ = resp.Header.Write(&buf)
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
+
+// The annotations added to the request headers by ShowInjected
+const (
+	setByNetHTTPNote = "[set by net/http]"
+	setByCallerNote  = "[set by caller]"
+)
+
+// setByCaller returns true if the header called name (canonical) in
+// the dump of req was set by the caller rather than added by net/http
+func setByCaller(req *http.Request, name string) bool {
+	switch name {
+	case "Host":
+		// http.NewRequest sets req.Host from the URL
+		return req.Host != "" && req.Host != req.URL.Host
+	case "Content-Length", "Transfer-Encoding":
+		// net/http works these out from the body
+		return false
+	}
+	for key := range req.Header {
+		if textproto.CanonicalMIMEHeaderKey(key) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// annotateInjected adds a note to each header line in the request
+// dump in buf saying whether it was set by the caller or net/http
+func annotateInjected(buf []byte, req *http.Request) []byte {
+	d, ok := splitDump(buf)
+	if !ok {
+		return buf
+	}
+	for i, line := range d.headers {
+		name, _, ok := splitHeader(line)
+		if !ok {
+			continue
+		}
+		note := setByNetHTTPNote
+		if setByCaller(req, name) {
+			note = setByCallerNote
+		}
+		d.headers[i] = append(append(line[:len(line):len(line)], ' '), note...)
+	}
+	return d.join()
+}
@@ -0,0 +1,235 @@
+package debughttp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitDump(t *testing.T) {
+	for _, test := range []string{
+		"HTTP/1.1 200 OK\r\n",
+		"HTTP/1.1 200 OK\r\nA: 1\r\nB: 2\r\n",
+		"HTTP/1.1 200 OK\r\nA: 1\r\nB: 2\r\n\r\nbody\r\n\r\nmore",
+		"HTTP/1.1 200 OK\nA: 1\n\nbody",
+	} {
+		d, ok := splitDump([]byte(test))
+		require.True(t, ok)
+		assert.Equal(t, test, string(d.join()))
+	}
+	d, ok := splitDump([]byte("HTTP/1.1 200 OK\r\nA: 1\r\nB: 2\r\n\r\nbody"))
+	require.True(t, ok)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", string(d.start))
+	assert.Equal(t, [][]byte{[]byte("A: 1"), []byte("B: 2")}, d.headers)
+	assert.Equal(t, "\r\n", string(d.eol))
+	assert.Equal(t, "\r\nbody", string(d.rest))
+
+	_, ok = splitDump([]byte("HTTP/1.1 200 OK"))
+	assert.False(t, ok)
+}
+
+func TestFoldHeaders(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want string
+	}{
+		{"", ""},
+		{"HTTP/1.1 200 OK\r\nA: 1\r\nB: 2\r\n\r\n", "HTTP/1.1 200 OK\r\nA: 1\r\nB: 2\r\n\r\n"},
+		{"HTTP/1.1 200 OK\r\nVia: 1\r\nVia: 2\r\nX: 1\r\nvia: 3\r\n\r\nVia: body", "HTTP/1.1 200 OK\r\nVia: 1, 2, 3\r\nX: 1\r\n\r\nVia: body"},
+		{"HTTP/1.1 200 OK\r\nSet-Cookie: a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT\r\nSet-Cookie: b=2\r\n\r\n", "HTTP/1.1 200 OK\r\nSet-Cookie: a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT\r\nSet-Cookie: b=2\r\n\r\n"},
+	} {
+		got := string(foldHeaders([]byte(test.in)))
+		assert.Equal(t, test.want, got, test.in)
+	}
+}
+
+func TestFoldHeadersTransport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Via", "1.1 a")
+		w.Header().Add("Via", "1.1 b")
+		w.Header().Add("Set-Cookie", "a=1")
+		w.Header().Add("Set-Cookie", "b=2")
+	}))
+	defer ts.Close()
+
+	var lines []string
+	client := NewClient(&Options{
+		Flags:       DumpHeaders,
+		FoldHeaders: true,
+		Logf: func(format string, v ...interface{}) {
+			lines = append(lines, fmt.Sprintf(format, v...))
+		},
+	})
+	resp, err := client.Get(ts.URL)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	require.Equal(t, 8, len(lines))
+	assert.Contains(t, lines[6], "\r\nVia: 1.1 a, 1.1 b\r\n")
+	assert.Contains(t, lines[6], "\r\nSet-Cookie: a=X\r\nSet-Cookie: b=X\r\n")
+}
+
+func TestCollapseHeaders(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want string
+	}{
+		{"", ""},
+		{"HTTP/1.1 200 OK\r\nA: 1\r\nA: 2\r\n\r\n", "HTTP/1.1 200 OK\r\nA: 1\r\nA: 2\r\n\r\n"},
+		{"HTTP/1.1 200 OK\r\nVia: 1\r\nVia: 1\r\nVia: 1\r\nX: 1\r\nVia: 1\r\n\r\nVia: 1\r\nVia: 1\r\n", "HTTP/1.1 200 OK\r\nVia: 1 (x3)\r\nX: 1\r\nVia: 1\r\n\r\nVia: 1\r\nVia: 1\r\n"},
+		{"GET / HTTP/1.1\nA: 1\nA: 1\n\n", "GET / HTTP/1.1\nA: 1 (x2)\n\n"},
+	} {
+		got := string(collapseHeaders([]byte(test.in)))
+		assert.Equal(t, test.want, got, test.in)
+	}
+}
+
+func TestCollapseRepeatedHeadersTransport(t *testing.T) {
+	const body = "X-Cache: HIT\r\nX-Cache: HIT\r\n"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 5; i++ {
+			w.Header().Add("X-Cache", "HIT")
+		}
+		w.Header().Add("X-Cache", "MISS")
+		w.Header().Add("Via", "1.1 a")
+		w.Header().Add("Via", "1.1 a")
+		_, _ = w.Write([]byte(body))
+	}))
+	defer ts.Close()
+
+	client, capture := NewCaptureClient(&Options{
+		Flags:                   DumpBodies,
+		CollapseRepeatedHeaders: true,
+	})
+	resp, err := client.Get(ts.URL)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	lines := capture.Lines()
+	require.Equal(t, 8, len(lines))
+	assert.Contains(t, lines[6], "\r\nVia: 1.1 a (x2)\r\n")
+	assert.Contains(t, lines[6], "\r\nX-Cache: HIT (x5)\r\nX-Cache: MISS\r\n")
+	assert.True(t, strings.HasSuffix(lines[6], "\r\n\r\n"+body), lines[6])
+}
+
+func TestAlignHeaders(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want string
+	}{
+		{"", ""},
+		{"HTTP/1.1 200 OK\r\n\r\n", "HTTP/1.1 200 OK\r\n\r\n"},
+		{"HTTP/1.1 200 OK\r\nA: 1\r\nLonger-Name:2\r\nBb:  3\r\n\r\nA: body\r\n", "HTTP/1.1 200 OK\r\nA:           1\r\nLonger-Name: 2\r\nBb:          3\r\n\r\nA: body\r\n"},
+		{"GET / HTTP/1.1\nHost: x\nX-Folded: a\n b\n... (2 more headers)\n\n", "GET / HTTP/1.1\nHost:     x\nX-Folded: a\n b\n... (2 more headers)\n\n"},
+	} {
+		got := string(alignHeaders([]byte(test.in)))
+		assert.Equal(t, test.want, got, test.in)
+	}
+}
+
+func TestAlignHeadersTransport(t *testing.T) {
+	const body = "Body:  with\r\nColons: in it\r\n"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Long-Header-Name", "1")
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(body))
+	}))
+	defer ts.Close()
+
+	client, capture := NewCaptureClient(&Options{
+		Flags:        DumpBodies,
+		AlignHeaders: true,
+	})
+	req, err := http.NewRequest("GET", ts.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "secret")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	lines := capture.Lines()
+	require.Equal(t, 8, len(lines))
+	assert.Contains(t, lines[2], "\r\nAuthorization:   XXXX\r\n")
+	assert.Contains(t, lines[2], "\r\nAccept-Encoding: gzip\r\n")
+	assert.Contains(t, lines[6], "\r\nContent-Type:       text/plain\r\n")
+	assert.Contains(t, lines[6], "\r\nX-Long-Header-Name: 1\r\n")
+	assert.True(t, strings.HasSuffix(lines[6], "\r\n\r\n"+body), lines[6])
+}
+
+func TestShowInjected(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	client, capture := NewCaptureClient(&Options{
+		Flags:        DumpBodies,
+		ShowInjected: true,
+	})
+	req, err := http.NewRequest("POST", ts.URL, strings.NewReader("body"))
+	require.NoError(t, err)
+	req.Header.Set("User-Agent", "test/1.0")
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header["x-lower"] = []string{"1"}
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	lines := capture.Lines()
+	require.Equal(t, 8, len(lines))
+	dump := lines[2]
+	assert.Contains(t, dump, "POST / HTTP/1.1\r\n")
+	assert.Contains(t, dump, "\r\nHost: "+req.URL.Host+" [set by net/http]\r\n")
+	assert.Contains(t, dump, "\r\nUser-Agent: test/1.0 [set by caller]\r\n")
+	assert.Contains(t, dump, "\r\nAuthorization: XXXX [set by caller]\r\n")
+	assert.Contains(t, dump, "\r\nx-lower: 1 [set by caller]\r\n")
+	assert.Contains(t, dump, "\r\nContent-Length: 4 [set by net/http]\r\n")
+	assert.Contains(t, dump, "\r\nAccept-Encoding: gzip [set by net/http]\r\n")
+	assert.True(t, strings.HasSuffix(dump, "\r\n\r\nbody"), dump)
+
+	// Responses aren't annotated
+	assert.NotContains(t, lines[6], "[set by")
+}
+
+func TestFallbackDumps(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Promise more body than is sent to break the response dump
+		conn, brw, err := w.(http.Hijacker).Hijack()
+		require.NoError(t, err)
+		defer func() { _ = conn.Close() }()
+		_, _ = brw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 100\r\nX-Auth-Token: SECRET\r\nX-Potato: Help\r\n\r\nshort")
+		_ = brw.Flush()
+	}))
+	defer ts.Close()
+
+	var lines []string
+	client := NewClient(&Options{
+		Flags: DumpBodies,
+		Logf: func(format string, v ...interface{}) {
+			lines = append(lines, fmt.Sprintf(format, v...))
+		},
+	})
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/path?q=1", strings.NewReader("Request body"))
+	require.NoError(t, err)
+	// Break the request dump
+	req.GetBody = func() (io.ReadCloser, error) {
+		return nil, errors.New("GetBody failed")
+	}
+	req.Header.Set("Authorization", "SECRET")
+	req.Header.Set("X-Potato", "Help")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+
+	require.Equal(t, 10, len(lines))
+	assert.Equal(t, "Dump request failed: GetBody failed - showing the headers only", lines[2])
+	assert.Equal(t, "POST /path?q=1 HTTP/1.1\r\nHost: "+req.URL.Host+"\r\nAuthorization: XXXX\r\nX-Potato: Help\r\n\r\n", lines[3])
+	assert.True(t, strings.HasPrefix(lines[7], "Dump response failed: "), lines[7])
+	assert.True(t, strings.HasSuffix(lines[7], " - showing the headers only"), lines[7])
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 100\r\nX-Auth-Token: SECRET\r\nX-Potato: Help\r\n\r\n", lines[8])
+}
@@ -0,0 +1,62 @@
+package debughttp
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// writeHTTPFile writes req with body to buf in the .http file syntax
+// used by the VS Code and JetBrains REST clients so it can be pasted
+// into an editor and run again.
+//
+// Only the headers set by the caller are written, not the ones
+// net/http adds automatically. URL is written instead of req.URL so
+// any credentials in it can be redacted.
+func writeHTTPFile(buf *bytes.Buffer, req *http.Request, URL string, body []byte) {
+	buf.WriteString("### ")
+	buf.WriteString(req.Method)
+	buf.WriteString(" ")
+	buf.WriteString(URL)
+	buf.WriteString("\n")
+	buf.WriteString(req.Method)
+	buf.WriteString(" ")
+	buf.WriteString(URL)
+	buf.WriteString(" HTTP/1.1\n")
+	if req.Host != "" && req.Host != req.URL.Host {
+		buf.WriteString("Host: ")
+		buf.WriteString(req.Host)
+		buf.WriteString("\n")
+	}
+	var headers bytes.Buffer
+	_ = req.Header.Write(&headers)
+	buf.Write(bytes.ReplaceAll(headers.Bytes(), []byte("\r\n"), []byte("\n")))
+	if len(body) > 0 {
+		buf.WriteString("\n")
+		buf.Write(body)
+		if body[len(body)-1] != '\n' {
+			buf.WriteString("\n")
+		}
+	}
+}
+
+// logHTTPFile logs the request of tx in .http file syntax
+func (t *Transport) logHTTPFile(tx *transaction) {
+	req := tx.req
+	var body []byte
+	if t.opt.Flags&(DumpBodies|DumpRequests) != 0 && !tx.isConnect && !tx.noBodies && t.requestBodyInBudget(tx) {
+		var err error
+		body, err = t.txRequestBody(tx)
+		if err != nil {
+			t.logfLevel(req, levelWarn, "Dump request failed: %v", err)
+			return
+		}
+	}
+	var buf bytes.Buffer
+	URL := t.scrubURL(req.URL)
+	if t.opt.Flags&DumpAuth != 0 {
+		URL = req.URL.String()
+	}
+	writeHTTPFile(&buf, req, URL, body)
+	out := t.redact(req, buf.Bytes(), DirectionRequest, req.Header.Get("Content-Type"))
+	t.logf(req, "%s", out)
+}
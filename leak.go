@@ -0,0 +1,67 @@
+package debughttp
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"runtime"
+	"sync/atomic"
+)
+
+// leakBody wraps a response body to warn if it is garbage collected
+// without being closed or read to the end, which leaks the connection.
+type leakBody struct {
+	io.ReadCloser
+	state *leakState
+}
+
+// leakState is the part of a leakBody with the finalizer.
+//
+// It is separate as the other body wrappers refer to the transaction
+// which refers to the leakBody through the response, and the garbage
+// collector doesn't run the finalizers of objects in a cycle.
+type leakState struct {
+	t      *Transport
+	req    *http.Request
+	id     string
+	closed int32 // set to 1 when closed - use atomic
+	eof    int32 // set to 1 when EOF has been read - use atomic
+}
+
+// newLeakBody wraps the response body of tx in a leakBody
+func newLeakBody(t *Transport, tx *transaction) *leakBody {
+	state := &leakState{
+		t:   t,
+		req: tx.req,
+		id:  tx.id(),
+	}
+	runtime.SetFinalizer(state, (*leakState).finalize)
+	return &leakBody{
+		ReadCloser: tx.resp.Body,
+		state:      state,
+	}
+}
+
+// Read reads from the body noting when EOF is read
+func (b *leakBody) Read(p []byte) (n int, err error) {
+	n, err = b.ReadCloser.Read(p)
+	if errors.Is(err, io.EOF) {
+		atomic.StoreInt32(&b.state.eof, 1)
+	}
+	return n, err
+}
+
+// Close closes the body
+func (b *leakBody) Close() error {
+	atomic.StoreInt32(&b.state.closed, 1)
+	return b.ReadCloser.Close()
+}
+
+// finalize is called by the garbage collector. Reading a body to the
+// end releases the connection without Close so that isn't reported.
+func (s *leakState) finalize() {
+	if atomic.LoadInt32(&s.closed) != 0 || atomic.LoadInt32(&s.eof) != 0 {
+		return
+	}
+	s.t.logfLevel(s.req, levelWarn, "Warning: response body for %s was garbage collected without Close - possible connection leak", s.id)
+}
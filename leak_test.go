@@ -0,0 +1,75 @@
+package debughttp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// leakWarnings runs the garbage collector until capture has n body
+// leak warnings or timeout has passed, returning them
+func leakWarnings(capture *Capture, n int, timeout time.Duration) (warnings []string) {
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); {
+		runtime.GC()
+		warnings = warnings[:0]
+		for _, line := range capture.Lines() {
+			if strings.Contains(line, "garbage collected without Close") {
+				warnings = append(warnings, line)
+			}
+		}
+		if len(warnings) >= n {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return warnings
+}
+
+func TestDetectBodyLeak(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("Response body"))
+	}))
+	defer ts.Close()
+
+	// DumpSizes and BodyHash add wrappers which refer back to the
+	// response which mustn't stop the leak being detected
+	client, capture := NewCaptureClient(&Options{
+		Flags:          DumpSizes,
+		BodyHash:       true,
+		DetectBodyLeak: true,
+		IDPrefix:       "r",
+	})
+	get := func() *http.Response {
+		resp, err := client.Get(ts.URL)
+		require.NoError(t, err)
+		return resp
+	}
+
+	// Closed and read to the end bodies aren't leaks
+	resp := get()
+	require.NoError(t, resp.Body.Close())
+	resp = get()
+	_, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	// This one is leaked
+	func() {
+		resp := get()
+		assert.NotNil(t, resp.Body)
+	}()
+
+	warnings := leakWarnings(capture, 1, 5*time.Second)
+	require.Equal(t, 1, len(warnings), capture.String())
+	assert.Equal(t, "Warning: response body for req r3 was garbage collected without Close - possible connection leak", warnings[0])
+
+	// No more warnings turn up
+	resp = nil
+	assert.Equal(t, 1, len(leakWarnings(capture, 2, 100*time.Millisecond)))
+}
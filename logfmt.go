@@ -0,0 +1,88 @@
+package debughttp
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// logfmtValue returns s quoted if necessary to be a logfmt value
+func logfmtValue(s string) string {
+	for _, c := range s {
+		if c <= ' ' || c == '=' || c == '"' || c == '\\' || c == 0x7f {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
+
+// logfmtLine builds a line of logfmt key=value pairs
+type logfmtLine struct {
+	strings.Builder
+}
+
+// add adds key=value to the line, quoting value if necessary
+func (l *logfmtLine) add(key, value string) {
+	if l.Len() > 0 {
+		l.WriteByte(' ')
+	}
+	l.WriteString(key)
+	l.WriteByte('=')
+	l.WriteString(logfmtValue(value))
+}
+
+// addHeaders adds each header in header as prefix.Name=value
+func (l *logfmtLine) addHeaders(prefix string, header http.Header) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		l.add(prefix+"."+name, strings.Join(header[name], ", "))
+	}
+}
+
+// logLogfmt logs the transaction on one line in logfmt, eg
+//
+//	ts=2006-01-02T15:04:05.999Z id=0xc000123456 method=PUT url=https://example.com/ status=200 dur=342ms req_bytes=12 resp_bytes=1100 err=
+//
+// The byte counts are the Content-Length so are -1 if unknown. If
+// the Flags dump more than the request and status lines then the
+// redacted headers are added as req.Name=value and resp.Name=value.
+func (t *Transport) logLogfmt(tx *transaction) {
+	req := tx.req
+	var line logfmtLine
+	line.add("ts", tx.start.UTC().Format(time.RFC3339Nano))
+	line.add("id", tx.ref)
+	if tx.attempt > 0 {
+		line.add("attempt", strconv.Itoa(tx.attempt))
+	}
+	line.add("method", req.Method)
+	line.add("url", t.scrubURL(req.URL))
+	status, respBytes := 0, int64(-1)
+	if tx.resp != nil {
+		status, respBytes = tx.resp.StatusCode, tx.resp.ContentLength
+	}
+	line.add("status", strconv.Itoa(status))
+	duration := tx.duration.Round(time.Millisecond)
+	if duration == 0 {
+		duration = tx.duration.Round(time.Microsecond)
+	}
+	line.add("dur", duration.String())
+	line.add("req_bytes", strconv.FormatInt(req.ContentLength, 10))
+	line.add("resp_bytes", strconv.FormatInt(respBytes, 10))
+	line.add("err", errString(tx.err))
+	if tx.err != nil {
+		line.add("err_kind", classifyError(tx.err))
+	}
+	if t.opt.Flags&dumpDetailFlags != 0 {
+		line.addHeaders("req", t.redactHeader(req.Header))
+		if tx.resp != nil {
+			line.addHeaders("resp", t.redactResponseHeader(tx.resp.Header))
+		}
+	}
+	t.logf(req, "%s", line.String())
+}
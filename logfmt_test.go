@@ -0,0 +1,95 @@
+package debughttp
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogfmtValue(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want string
+	}{
+		{"", ""},
+		{"200", "200"},
+		{"https://example.com/path?a=b", `"https://example.com/path?a=b"`},
+		{"two words", `"two words"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`back\slash`, `"back\\slash"`},
+		{"line\nbreak", `"line\nbreak"`},
+		{"tab\there", `"tab\there"`},
+		{"ünïcode", "ünïcode"},
+	} {
+		assert.Equal(t, test.want, logfmtValue(test.in), test.in)
+	}
+
+	var line logfmtLine
+	line.add("a", "1")
+	line.add("b", "x=y")
+	line.add("c", "")
+	assert.Equal(t, `a=1 b="x=y" c=`, line.String())
+}
+
+func TestFormatLogfmt(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Note", `key=value and "quotes"`)
+		w.Header().Set("Set-Cookie", "session=SECRET; Path=/")
+		fmt.Fprint(w, "Response body")
+	}))
+	defer ts.Close()
+
+	for _, test := range []struct {
+		name  string
+		flags DumpFlags
+		want  string
+	}{
+		{
+			name:  "Line",
+			flags: DumpLine,
+			want:  `^ts=\S+Z id=0x[0-9a-f]+ method=PUT url=` + regexp.QuoteMeta(ts.URL) + `/path status=200 dur=\S+s req_bytes=12 resp_bytes=13 err=$`,
+		},
+		{
+			name:  "Headers",
+			flags: DumpBodies,
+			want: `^ts=\S+Z id=0x[0-9a-f]+ method=PUT url=` + regexp.QuoteMeta(ts.URL) + `/path status=200 dur=\S+s req_bytes=12 resp_bytes=13 err= ` +
+				`req.Authorization=XXXX req.X-Words="a b" ` +
+				`resp.Content-Length=13 resp.Content-Type="text/plain; charset=utf-8" resp.Date="[^"]+" resp.Set-Cookie="session=XXXX; Path=/" resp.X-Note="key=value and \\"quotes\\""$`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			client, capture := NewCaptureClient(&Options{
+				Flags:  test.flags,
+				Format: FormatLogfmt,
+			})
+			req, err := http.NewRequest(http.MethodPut, ts.URL+"/path", strings.NewReader("Request body"))
+			require.NoError(t, err)
+			req.Header.Set("Authorization", "SECRET")
+			req.Header.Set("X-Words", "a b")
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+
+			lines := capture.Lines()
+			require.Equal(t, 1, len(lines))
+			assert.Regexp(t, test.want, lines[0])
+		})
+	}
+
+	// Failed round trips
+	client, capture := NewCaptureClient(&Options{
+		Flags:  DumpLine,
+		Format: FormatLogfmt,
+	})
+	_, err := client.Get("http://127.0.0.1:1/")
+	require.Error(t, err)
+	lines := capture.Lines()
+	require.Equal(t, 1, len(lines))
+	assert.Regexp(t, `^ts=\S+ id=\S+ method=GET url=http://127.0.0.1:1/ status=0 dur=\S+ req_bytes=0 resp_bytes=-1 err="[^"]+refused" err_kind=refused$`, lines[0])
+}
@@ -0,0 +1,162 @@
+package debughttp
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+)
+
+// ndjsonMediaTypes are the media types of newline delimited JSON
+// bodies which are logged line by line as they are read
+var ndjsonMediaTypes = map[string]bool{
+	"application/x-ndjson":     true,
+	"application/ndjson":       true,
+	"application/jsonl":        true,
+	"application/x-jsonlines":  true,
+	"application/jsonlines":    true,
+	"application/stream+json":  true,
+	"application/x-json-lines": true,
+}
+
+// ndjsonNote is added to the response dump instead of an NDJSON body
+const ndjsonNote = "[NDJSON body logged line by line as it is read]"
+
+// ndjsonMaxLine is the most of a line which is buffered to be logged
+// if MaxBodyDumpContentLength isn't set
+const ndjsonMaxLine = 1 << 20
+
+// isNDJSON returns true if resp has a newline delimited JSON body
+func isNDJSON(resp *http.Response) bool {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return err == nil && ndjsonMediaTypes[mediaType]
+}
+
+// ndjsonBody wraps a newline delimited JSON response body logging each
+// line as the caller reads it so the stream isn't buffered.
+type ndjsonBody struct {
+	io.ReadCloser
+	t    *Transport
+	tx   *transaction
+	mu   sync.Mutex
+	buf  []byte // the start of a line which hasn't been logged yet
+	line int    // the number of the next line to log
+	skip bool   // set to drop the rest of a line which was truncated
+	done bool   // set once the end of the body has been logged
+}
+
+// newNDJSONBody wraps the response body of tx in an ndjsonBody
+func newNDJSONBody(t *Transport, tx *transaction) *ndjsonBody {
+	return &ndjsonBody{
+		ReadCloser: tx.resp.Body,
+		t:          t,
+		tx:         tx,
+		line:       1,
+	}
+}
+
+// Read reads from the body logging any complete lines
+func (b *ndjsonBody) Read(p []byte) (n int, err error) {
+	n, err = b.ReadCloser.Read(p)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, p[:n]...)
+	for {
+		i := bytes.IndexByte(b.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if !b.skip {
+			b.logLine(b.truncate(b.buf[:i]))
+		}
+		b.buf, b.skip = b.buf[i+1:], false
+	}
+	// Don't buffer a stream without newlines indefinitely
+	if len(b.buf) > b.maxLine() && !b.skip {
+		b.logLine(b.truncate(b.buf))
+		b.skip = true
+	}
+	if b.skip {
+		b.buf = b.buf[:0]
+	}
+	if err != nil {
+		b.finish("")
+	}
+	return n, err
+}
+
+// Close closes the body logging any incomplete line
+func (b *ndjsonBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.mu.Lock()
+	b.finish(" (incomplete)")
+	b.mu.Unlock()
+	return err
+}
+
+// finish logs any partial line left at the end of the body with note
+func (b *ndjsonBody) finish(note string) {
+	if b.done {
+		return
+	}
+	b.done = true
+	if !b.skip {
+		b.logLine(b.buf, note)
+	}
+	b.buf = nil
+}
+
+// maxLine returns the most of a line to buffer
+func (b *ndjsonBody) maxLine() int {
+	if max := b.t.opt.MaxBodyDumpContentLength; max > 0 && max < ndjsonMaxLine {
+		return int(max)
+	}
+	return ndjsonMaxLine
+}
+
+// truncate returns line cut to maxLine and the note to log it with
+func (b *ndjsonBody) truncate(line []byte) ([]byte, string) {
+	if max := b.maxLine(); len(line) > max {
+		return line[:max], " (truncated)"
+	}
+	return line, ""
+}
+
+// redactLine runs line through the Redactors as the body of a dump
+// of the response
+func (b *ndjsonBody) redactLine(line []byte) []byte {
+	resp := b.tx.resp
+	contentType := resp.Header.Get("Content-Type")
+	buf := make([]byte, 0, len(line)+64)
+	buf = append(buf, "HTTP/1.1 "+resp.Status+"\r\nContent-Type: "+contentType+"\r\n\r\n"...)
+	buf = append(buf, line...)
+	buf = b.t.redact(b.tx.req, buf, DirectionResponse, contentType)
+	i := bytes.Index(buf, []byte("\r\n\r\n"))
+	if i < 0 {
+		return nil
+	}
+	return buf[i+4:]
+}
+
+// logLine logs one line of the body, formatted and redacted according
+// to the Options, ignoring blank lines
+func (b *ndjsonBody) logLine(line []byte, note string) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return
+	}
+	t := b.t
+	line = b.redactLine(line)
+	if len(t.opt.IncludeJSONFields) > 0 {
+		line = includeJSONFieldsBody(line, t.opt.IncludeJSONFields)
+	}
+	if t.opt.RedactPII {
+		line = redactPIIBody(line, t.opt.PIIPatterns)
+	}
+	if formatted, ok := formatJSON(line); ok {
+		line = formatted
+	}
+	t.logf(b.tx.req, "%s (%s) line %d%s:\n%s", "HTTP RESPONSE NDJSON", b.tx.id(), b.line, note, bytes.TrimRight(line, "\n"))
+	b.line++
+}
@@ -0,0 +1,148 @@
+package debughttp
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsNDJSON(t *testing.T) {
+	for _, test := range []struct {
+		contentType string
+		want        bool
+	}{
+		{"", false},
+		{"application/json", false},
+		{"application/x-ndjson", true},
+		{"application/x-ndjson; charset=utf-8", true},
+		{"Application/JSONL", true},
+	} {
+		resp := &http.Response{Header: http.Header{"Content-Type": {test.contentType}}}
+		assert.Equal(t, test.want, isNDJSON(resp), test.contentType)
+	}
+}
+
+func TestNDJSONStreaming(t *testing.T) {
+	next := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		fmt.Fprint(w, `{"id":1,"email":"user@example.com","extra":"x"}`+"\n")
+		w.(http.Flusher).Flush()
+		<-next
+		fmt.Fprint(w, "\n"+`{"id":2,"email":"other@example.com"}`+"\n")
+		w.(http.Flusher).Flush()
+		<-next
+		fmt.Fprint(w, `{"id":3,`)
+	}))
+	defer ts.Close()
+	defer close(next)
+
+	var events []Event
+	client, capture := NewCaptureClient(&Options{
+		Flags:             DumpBodies,
+		RedactPII:         true,
+		IncludeJSONFields: []string{"id", "email"},
+		OnEvent: func(ev Event) {
+			events = append(events, ev)
+		},
+	})
+	resp, err := client.Get(ts.URL)
+	require.NoError(t, err)
+	lines := capture.Lines()
+	require.Equal(t, 8, len(lines))
+	assert.True(t, strings.HasSuffix(lines[6], "\r\n\r\n"+ndjsonNote+"\n"), lines[6])
+	require.Equal(t, 2, len(events))
+	assert.Nil(t, events[1].Body)
+
+	// Each line is logged as the caller reads it and the caller
+	// gets the body unchanged
+	r := bufio.NewReader(resp.Body)
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, `{"id":1,"email":"user@example.com","extra":"x"}`+"\n", line)
+	lines = capture.Lines()
+	require.Equal(t, 9, len(lines))
+	assert.Regexp(t, `^HTTP RESPONSE NDJSON \(req 0x[0-9a-f]+\) line 1:\n`, lines[8])
+	assert.True(t, strings.HasSuffix(lines[8], ":\n{\n  \"...\": \"...\",\n  \"email\": \"[REDACTED email]\",\n  \"id\": 1\n}"), lines[8])
+
+	next <- struct{}{}
+	line, err = r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "\n", line)
+	line, err = r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, `{"id":2,"email":"other@example.com"}`+"\n", line)
+	lines = capture.Lines()
+	require.Equal(t, 10, len(lines))
+	assert.Contains(t, lines[9], ") line 2:\n{\n  \"email\": \"[REDACTED email]\",\n  \"id\": 2\n}")
+
+	// A partial line is logged as is at the end
+	next <- struct{}{}
+	rest, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, `{"id":3,`, string(rest))
+	require.NoError(t, resp.Body.Close())
+	lines = capture.Lines()
+	require.Equal(t, 11, len(lines))
+	assert.True(t, strings.HasSuffix(lines[10], ") line 3:\n{\"id\":3,"), lines[10])
+}
+
+func TestNDJSONRedactors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		fmt.Fprint(w, `{"secret":"hunter2"}`+"\n")
+	}))
+	defer ts.Close()
+
+	client, capture := NewCaptureClient(&Options{
+		Flags: DumpBodies,
+		Redactors: []Redactor{RedactorFunc(func(buf []byte, dir Direction, contentType string) []byte {
+			if contentType != "application/x-ndjson" {
+				return buf
+			}
+			return []byte(strings.ReplaceAll(string(buf), "hunter2", "*****"))
+		})},
+	})
+	resp, err := client.Get(ts.URL)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, `{"secret":"hunter2"}`+"\n", string(body))
+
+	lines := capture.Lines()
+	require.Equal(t, 9, len(lines))
+	assert.True(t, strings.HasSuffix(lines[8], ") line 1:\n{\n  \"secret\": \"*****\"\n}"), lines[8])
+}
+
+func TestNDJSONLongLine(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		fmt.Fprint(w, strings.Repeat("x", 100)+"\n"+`{"id":2}`+"\n")
+	}))
+	defer ts.Close()
+
+	client, capture := NewCaptureClient(&Options{
+		Flags:                    DumpBodies,
+		MaxBodyDumpContentLength: 10,
+	})
+	resp, err := client.Get(ts.URL)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, 110, len(body))
+
+	// Only the start of the long line is logged and the rest dropped
+	lines := capture.Lines()
+	require.Equal(t, 10, len(lines))
+	assert.True(t, strings.HasSuffix(lines[8], ") line 1 (truncated):\nxxxxxxxxxx"), lines[8])
+	assert.True(t, strings.HasSuffix(lines[9], ") line 2:\n{\n  \"id\": 2\n}"), lines[9])
+}
@@ -0,0 +1,196 @@
+package debughttp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Tuning for NewNetWriter
+const (
+	netWriterMaxBuffered = 1 << 20          // maximum bytes waiting to be sent before messages are dropped
+	netWriterTimeout     = 10 * time.Second // timeout for dialing and for each write
+	netWriterMinBackoff  = 100 * time.Millisecond
+	netWriterMaxBackoff  = 10 * time.Second
+)
+
+// errNetWriterClosed is returned by writes to a closed NetWriter
+var errNetWriterClosed = errors.New("debughttp: write to closed net writer")
+
+// netWriter sends each Write as a message to a network address from
+// a background goroutine, reconnecting if it fails.
+type netWriter struct {
+	network   string
+	addr      string
+	dial      func(network, addr string, timeout time.Duration) (net.Conn, error) // used to redial
+	mu        sync.Mutex
+	queue     [][]byte // messages waiting to be sent
+	size      int      // bytes in queue
+	dropped   int64    // messages dropped because the queue was full
+	closed    bool
+	conn      net.Conn      // only used by run
+	wake      chan struct{} // signals run there is something to do
+	closing   chan struct{} // closed when Close is called
+	done      chan struct{} // closed when run has finished
+	closeOnce sync.Once
+}
+
+// NewNetWriter dials addr on network, eg "tcp" or "udp", returning
+// an io.WriteCloser to use as the Writer in Options to send the dumps
+// to a remote collector, one message per line.
+//
+// Writes never block the round trips. They are buffered (up to 1 MiB
+// after which they are dropped, with a note of how many sent later)
+// and sent in the background, redialing and retrying with backoff if
+// the connection fails.
+//
+// It is closed with the Transport which uses it as its Writer, which
+// tries to send anything still buffered, giving up on the rest if a
+// send fails.
+func NewNetWriter(network, addr string) (io.WriteCloser, error) {
+	conn, err := net.DialTimeout(network, addr, netWriterTimeout)
+	if err != nil {
+		return nil, err
+	}
+	w := &netWriter{
+		network: network,
+		addr:    addr,
+		dial:    net.DialTimeout,
+		conn:    conn,
+		wake:    make(chan struct{}, 1),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go w.run()
+	return w, nil
+}
+
+// Write queues a copy of p to be sent
+func (w *netWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, errNetWriterClosed
+	}
+	if w.size+len(p) > netWriterMaxBuffered {
+		w.dropped++
+		return len(p), nil
+	}
+	w.queue = append(w.queue, append([]byte(nil), p...))
+	w.size += len(p)
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+	return len(p), nil
+}
+
+// peek returns the next message to send or nil if there isn't one,
+// and whether the writer is closed
+func (w *netWriter) peek() (msg []byte, closed bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.dropped > 0 {
+		note := []byte(fmt.Sprintf("[debughttp: dropped %d messages]\n", w.dropped))
+		w.queue = append([][]byte{note}, w.queue...)
+		w.size += len(note)
+		w.dropped = 0
+	}
+	if len(w.queue) == 0 {
+		return nil, w.closed
+	}
+	return w.queue[0], w.closed
+}
+
+// pop removes the message returned by peek once it has been sent
+func (w *netWriter) pop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.size -= len(w.queue[0])
+	w.queue[0] = nil
+	w.queue = w.queue[1:]
+}
+
+// discard drops all the queued messages
+func (w *netWriter) discard() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.queue = nil
+	w.size = 0
+}
+
+// send sends msg, dialing first if not connected
+func (w *netWriter) send(msg []byte) error {
+	if w.conn == nil {
+		conn, err := w.dial(w.network, w.addr, netWriterTimeout)
+		if err != nil {
+			return err
+		}
+		w.conn = conn
+	}
+	_ = w.conn.SetWriteDeadline(time.Now().Add(netWriterTimeout))
+	if _, err := w.conn.Write(msg); err != nil {
+		_ = w.conn.Close()
+		w.conn = nil
+		return err
+	}
+	return nil
+}
+
+// run sends the queued messages until the writer is closed
+func (w *netWriter) run() {
+	defer close(w.done)
+	backoff := netWriterMinBackoff
+	for {
+		msg, closed := w.peek()
+		if msg == nil {
+			if closed {
+				break
+			}
+			<-w.wake
+			continue
+		}
+		if err := w.send(msg); err != nil {
+			// Once closed give up on the rest at the first failure
+			// so Close isn't held up by a timeout for each message
+			if closed {
+				w.discard()
+				continue
+			}
+			select {
+			case <-time.After(backoff):
+			case <-w.closing:
+			}
+			backoff *= 2
+			if backoff > netWriterMaxBackoff {
+				backoff = netWriterMaxBackoff
+			}
+			continue
+		}
+		backoff = netWriterMinBackoff
+		w.pop()
+	}
+	if w.conn != nil {
+		_ = w.conn.Close()
+	}
+}
+
+// Close tries to send anything buffered then closes the connection.
+// It is safe to call more than once.
+func (w *netWriter) Close() error {
+	w.closeOnce.Do(func() {
+		w.mu.Lock()
+		w.closed = true
+		w.mu.Unlock()
+		close(w.closing)
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	})
+	<-w.done
+	return nil
+}
@@ -0,0 +1,145 @@
+package debughttp
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// acceptLines accepts connections on l sending each line read to lines
+func acceptLines(l net.Listener, conns chan<- net.Conn, lines chan<- string) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		conns <- conn
+		go func() {
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				lines <- scanner.Text()
+			}
+		}()
+	}
+}
+
+func TestNetWriter(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = l.Close() }()
+	conns := make(chan net.Conn, 10)
+	lines := make(chan string, 1000)
+	go acceptLines(l, conns, lines)
+
+	w, err := NewNetWriter("tcp", l.Addr().String())
+	require.NoError(t, err)
+	conn := <-conns
+
+	_, err = w.Write([]byte("hello\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", <-lines)
+
+	// Break the connection and check it reconnects
+	require.NoError(t, conn.Close())
+	deadline := time.After(10 * time.Second)
+	reconnected := false
+	for !reconnected {
+		_, err = w.Write([]byte("retry\n"))
+		require.NoError(t, err)
+		select {
+		case <-conns:
+			reconnected = true
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("didn't reconnect")
+		}
+	}
+	assert.Equal(t, "retry", <-lines)
+
+	// Close flushes what is buffered
+	_, err = w.Write([]byte("last\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, w.Close())
+	for line := range lines {
+		if line == "last" {
+			break
+		}
+		assert.Equal(t, "retry", line)
+	}
+	_, err = w.Write([]byte("closed\n"))
+	assert.Equal(t, errNetWriterClosed, err)
+}
+
+func TestNetWriterDialError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+	_, err = NewNetWriter("tcp", addr)
+	assert.Error(t, err)
+}
+
+func TestNetWriterTransport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = l.Close() }()
+	conns := make(chan net.Conn, 10)
+	lines := make(chan string, 1000)
+	go acceptLines(l, conns, lines)
+
+	w, err := NewNetWriter("tcp", l.Addr().String())
+	require.NoError(t, err)
+	transport := NewDefault(&Options{
+		Flags:  DumpSummary,
+		Writer: w,
+	})
+	client := &http.Client{Transport: transport}
+	resp, err := client.Get(ts.URL)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	// Closing the Transport closes the writer
+	require.NoError(t, transport.Close())
+	_, err = w.Write([]byte("closed\n"))
+	assert.Equal(t, errNetWriterClosed, err)
+	line := <-lines
+	assert.True(t, strings.HasPrefix(line, "GET "+ts.URL+" -> 200 OK"), line)
+}
+
+func TestNetWriterCloseGivesUp(t *testing.T) {
+	dials := 0
+	w := &netWriter{
+		network: "tcp",
+		addr:    "collector:514",
+		dial: func(network, addr string, timeout time.Duration) (net.Conn, error) {
+			dials++
+			return nil, errors.New("dial timeout")
+		},
+		wake:    make(chan struct{}, 1),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	for i := 0; i < 10; i++ {
+		_, err := w.Write([]byte("message\n"))
+		require.NoError(t, err)
+	}
+	go w.run()
+	require.NoError(t, w.Close())
+
+	// One failed send after Close drops the rest of the queue
+	assert.True(t, dials <= 2, dials)
+	assert.Equal(t, 0, len(w.queue))
+	assert.Equal(t, 0, w.size)
+}
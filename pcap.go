@@ -0,0 +1,56 @@
+package debughttp
+
+import (
+	"strconv"
+	"time"
+)
+
+// pcapTimeFormat is the format of the timestamps of FormatPcapText
+const pcapTimeFormat = "15:04:05.000000"
+
+// logPcapText logs the transaction as two lines like the summary of a
+// packet capture, one for the request and one for the response, eg
+//
+//	03:04:05.000000 127.0.0.1:54321 → 93.184.216.34:443 HTTP/1.1 len=12 PUT /path (req 0xc000123456)
+//	03:04:05.342000 127.0.0.1:54321 ← 93.184.216.34:443 HTTP/1.1 len=1100 200 OK +342ms (req 0xc000123456)
+//
+// The arrows show the direction with the client always on the left,
+// and "✗" a failed round trip. The timestamps are when the request was
+// sent and the response headers arrived. The lengths are the
+// Content-Length so are "?" if unknown.
+func (t *Transport) logPcapText(tx *transaction) {
+	req := tx.req
+	local, remote := "client", targetAddr(req.URL)
+	if tx.conn.local != nil {
+		local = tx.conn.local.String()
+	}
+	if tx.conn.remote != nil {
+		remote = tx.conn.remote.String()
+	}
+	proto := "HTTP"
+	if tx.resp != nil && tx.resp.Proto != "" {
+		proto = tx.resp.Proto
+	}
+	method := req.Method
+	if method == "" {
+		method = "GET"
+	}
+	t.logf(req, "%s %s → %s %s len=%s %s %s (%s)", tx.start.Format(pcapTimeFormat), local, remote, proto,
+		pcapLen(req.ContentLength), method, t.scrubRequestURI(req.URL), tx.id())
+	end := tx.start.Add(tx.duration).Format(pcapTimeFormat)
+	duration := tx.duration.Round(time.Microsecond)
+	if tx.err != nil {
+		t.logfLevel(req, levelError, "%s %s ✗ %s %s failed: %v +%v (%s)", end, local, remote, proto, tx.err, duration, tx.id())
+		return
+	}
+	t.logfLevel(req, t.statusLevel(tx.resp), "%s %s ← %s %s len=%s %s +%v (%s)", end, local, remote, proto,
+		pcapLen(tx.resp.ContentLength), t.redactLine(tx.resp.Status), duration, tx.id())
+}
+
+// pcapLen formats a Content-Length for FormatPcapText
+func pcapLen(n int64) string {
+	if n < 0 {
+		return "?"
+	}
+	return strconv.FormatInt(n, 10)
+}
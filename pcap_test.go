@@ -0,0 +1,64 @@
+package debughttp
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPcapLen(t *testing.T) {
+	assert.Equal(t, "?", pcapLen(-1))
+	assert.Equal(t, "0", pcapLen(0))
+	assert.Equal(t, "1100", pcapLen(1100))
+}
+
+func TestFormatPcapText(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "13")
+		fmt.Fprint(w, "Response body")
+	}))
+	defer ts.Close()
+	addr := strings.TrimPrefix(ts.URL, "http://")
+
+	client, capture := NewCaptureClient(&Options{
+		Flags:    DumpBodies,
+		Format:   FormatPcapText,
+		IDPrefix: "r",
+	})
+	// Each reading of the clock is 250ms after the last
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	var mu sync.Mutex
+	client.Transport.(*Transport).SetClock(func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(250 * time.Millisecond)
+		return now
+	})
+
+	req, err := http.NewRequest("PUT", ts.URL+"/path?q=1", strings.NewReader("Request body"))
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	lines := capture.Lines()
+	require.Equal(t, 2, len(lines))
+	assert.Regexp(t, `^03:04:05\.250000 127\.0\.0\.1:\d+ → `+addr+` HTTP/1\.1 len=12 PUT /path\?q=1 \(req r1\)$`, lines[0])
+	assert.Regexp(t, `^03:04:05\.500000 127\.0\.0\.1:\d+ ← `+addr+` HTTP/1\.1 len=13 200 OK \+250ms \(req r1\)$`, lines[1])
+
+	// A failed round trip has a cross instead of the response arrow
+	capture.Reset()
+	_, err = client.Get("http://127.0.0.1:1/")
+	require.Error(t, err)
+	lines = capture.Lines()
+	require.Equal(t, 2, len(lines))
+	assert.Regexp(t, `^\S+ client → 127\.0\.0\.1:1 HTTP len=0 GET / \(req r2\)$`, lines[0])
+	assert.Regexp(t, `^\S+ client ✗ 127\.0\.0\.1:1 HTTP failed: .*connection refused.* \+250ms \(req r2\)$`, lines[1])
+}
@@ -0,0 +1,86 @@
+package debughttp
+
+import (
+	"bytes"
+	"regexp"
+)
+
+// PIIPattern describes some personally identifiable information to
+// redact from bodies if RedactPII is set in Options
+type PIIPattern struct {
+	Name   string                  // name of the pattern which is shown in the redaction, eg "email"
+	Regexp *regexp.Regexp          // regexp to match the PII
+	Valid  func(match []byte) bool // if set, only matches for which this returns true are redacted
+}
+
+// PIIPatterns are the patterns used by RedactPII if PIIPatterns isn't
+// set in Options.
+//
+// To extend them, append to a copy of this and set it as PIIPatterns
+// in Options.
+var PIIPatterns = []PIIPattern{
+	{
+		Name:   "email",
+		Regexp: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+	},
+	{
+		Name:   "card",
+		Regexp: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`),
+		Valid:  luhnValid,
+	},
+	{
+		Name:   "ssn",
+		Regexp: regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`),
+	},
+}
+
+// luhnValid returns true if the digits in match pass the Luhn check
+// used by credit card numbers. Any spaces or dashes are ignored.
+func luhnValid(match []byte) bool {
+	sum := 0
+	digits := 0
+	for i := len(match) - 1; i >= 0; i-- {
+		c := match[i]
+		if c == ' ' || c == '-' {
+			continue
+		}
+		if c < '0' || c > '9' {
+			return false
+		}
+		d := int(c - '0')
+		if digits%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+	}
+	return digits >= 13 && digits <= 19 && sum%10 == 0
+}
+
+// redactPII redacts any matches for patterns in the body of the dump
+// in buf. The headers are left alone.
+func redactPII(buf []byte, patterns []PIIPattern) []byte {
+	i := bytes.Index(buf, []byte("\r\n\r\n"))
+	if i < 0 {
+		return buf
+	}
+	i += 4
+	return append(buf[:i:i], redactPIIBody(buf[i:], patterns)...)
+}
+
+// redactPIIBody redacts any matches for patterns in body
+func redactPIIBody(body []byte, patterns []PIIPattern) []byte {
+	for _, pattern := range patterns {
+		replacement := []byte("[REDACTED " + pattern.Name + "]")
+		body = pattern.Regexp.ReplaceAllFunc(body, func(match []byte) []byte {
+			if pattern.Valid != nil && !pattern.Valid(match) {
+				return match
+			}
+			return replacement
+		})
+	}
+	return body
+}
@@ -0,0 +1,60 @@
+package debughttp
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLuhnValid(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want bool
+	}{
+		{"", false},
+		{"4111111111111111", true},
+		{"4111 1111 1111 1111", true},
+		{"4111-1111-1111-1111", true},
+		{"4111111111111112", false},
+		{"5500005555555559", true},
+		{"378282246310005", true},
+		{"0000000000", false}, // too short
+		{"12345678901234567890", false},
+		{"4111x111111111111", false},
+	} {
+		assert.Equal(t, test.want, luhnValid([]byte(test.in)), test.in)
+	}
+}
+
+func TestRedactPII(t *testing.T) {
+	const header = "POST / HTTP/1.1\r\nFrom: user@example.com\r\n\r\n"
+	for _, test := range []struct {
+		in   string
+		want string
+	}{
+		{"", ""},
+		{header, header},
+		{header + "no pii here", header + "no pii here"},
+		{header + `{"email":"user@example.com"}`, header + `{"email":"[REDACTED email]"}`},
+		{header + "card 4111 1111 1111 1111 ok", header + "card [REDACTED card] ok"},
+		{header + "card 4111111111111112 not luhn", header + "card 4111111111111112 not luhn"},
+		{header + "order 1234567890123 not luhn", header + "order 1234567890123 not luhn"},
+		{header + "ssn 123-45-6789.", header + "ssn [REDACTED ssn]."},
+		{header + "phone 555-123-4567", header + "phone 555-123-4567"},
+	} {
+		got := string(redactPII([]byte(test.in), PIIPatterns))
+		assert.Equal(t, test.want, got, test.in)
+	}
+}
+
+func TestRedactPIICustomPatterns(t *testing.T) {
+	patterns := append([]PIIPattern{}, PIIPatterns...)
+	patterns = append(patterns, PIIPattern{
+		Name:   "ip",
+		Regexp: regexp.MustCompile(`\b\d{1,3}(?:\.\d{1,3}){3}\b`),
+	})
+	const header = "HTTP/1.1 200 OK\r\n\r\n"
+	got := string(redactPII([]byte(header+"from 10.0.0.1 by a@b.com"), patterns))
+	assert.Equal(t, header+"from [REDACTED ip] by [REDACTED email]", got)
+}
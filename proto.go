@@ -0,0 +1,78 @@
+package debughttp
+
+import (
+	"mime"
+	"net/http"
+)
+
+// ProtoResolver finds how to show the protobuf body of req for
+// FormatBodies. dir says whether it is the request or the response
+// body.
+//
+// It returns a function which unmarshals the body and renders the
+// message as text, or nil if the message type isn't known in which
+// case the body is shown with the BodyFormatters as usual, a hex dump
+// by default.
+//
+// This keeps the protobuf dependency out of this package. For example,
+// with a registry of the message types of each path
+//
+//	func(req *http.Request, dir debughttp.Direction) func([]byte) ([]byte, error) {
+//		mt, err := protoregistry.GlobalTypes.FindMessageByName(types[req.URL.Path][dir])
+//		if err != nil {
+//			return nil
+//		}
+//		return func(body []byte) ([]byte, error) {
+//			msg := mt.New().Interface()
+//			if err := proto.Unmarshal(body, msg); err != nil {
+//				return nil, err
+//			}
+//			return prototext.MarshalOptions{Multiline: true}.Marshal(msg)
+//		}
+//	}
+type ProtoResolver func(req *http.Request, dir Direction) func(body []byte) ([]byte, error)
+
+// protobufMediaTypes are the media types of protobuf bodies
+var protobufMediaTypes = map[string]bool{
+	"application/x-protobuf":          true,
+	"application/protobuf":            true,
+	"application/vnd.google.protobuf": true,
+}
+
+// isProtobuf returns true if contentType is a protobuf media type
+func isProtobuf(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && protobufMediaTypes[mediaType]
+}
+
+// protoFormatter returns a BodyFormatter using render which falls back
+// to formatter if render fails
+func protoFormatter(render func(body []byte) ([]byte, error), formatter BodyFormatter) BodyFormatter {
+	return func(body []byte) ([]byte, bool) {
+		out, err := render(body)
+		if err != nil {
+			if formatter == nil {
+				return nil, false
+			}
+			return formatter(body)
+		}
+		if len(out) == 0 || out[len(out)-1] != '\n' {
+			out = append(out, '\n')
+		}
+		return out, true
+	}
+}
+
+// formatBody reformats the body in the dump in buf of the request or
+// response of req according to contentType using the ProtoResolver
+// for protobuf bodies if it can resolve the type and the
+// BodyFormatters otherwise.
+func (t *Transport) formatBody(buf []byte, req *http.Request, dir Direction, contentType string) []byte {
+	formatter := findBodyFormatter(t.opt.BodyFormatters, contentType)
+	if t.opt.ProtoResolver != nil && isProtobuf(contentType) {
+		if render := t.opt.ProtoResolver(req, dir); render != nil {
+			formatter = protoFormatter(render, formatter)
+		}
+	}
+	return formatBodyWith(buf, formatter)
+}
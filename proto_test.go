@@ -0,0 +1,75 @@
+package debughttp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsProtobuf(t *testing.T) {
+	assert.True(t, isProtobuf("application/x-protobuf"))
+	assert.True(t, isProtobuf("application/protobuf; proto=foo.Bar"))
+	assert.True(t, isProtobuf("application/vnd.google.protobuf"))
+	assert.False(t, isProtobuf("application/json"))
+	assert.False(t, isProtobuf(""))
+}
+
+// renderID "unmarshals" a message with just field 1 as a small varint
+func renderID(body []byte) ([]byte, error) {
+	if len(body) != 2 || body[0] != 0x08 || body[1] >= 0x80 {
+		return nil, errors.New("bad message")
+	}
+	return []byte(fmt.Sprintf("id: %d", body[1])), nil
+}
+
+func TestProtoResolver(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		switch r.URL.Path {
+		case "/bad":
+			_, _ = w.Write([]byte{0xff, 0x01})
+		default:
+			_, _ = w.Write([]byte{0x08, 0x2a})
+		}
+	}))
+	defer ts.Close()
+
+	client, capture := NewCaptureClient(&Options{
+		Flags:        DumpBodies,
+		FormatBodies: true,
+		ProtoResolver: func(req *http.Request, dir Direction) func([]byte) ([]byte, error) {
+			if req.URL.Path == "/unknown" || dir != DirectionResponse {
+				return nil
+			}
+			return renderID
+		},
+	})
+	for _, test := range []struct {
+		path string
+		want string
+	}{
+		{"/known", "\r\n\r\nid: 42\n"},
+		{"/unknown", "\r\n\r\n00000000  08 2a "},
+		{"/bad", "\r\n\r\n00000000  ff 01 "},
+	} {
+		capture.Reset()
+		resp, err := client.Post(ts.URL+test.path, "application/x-protobuf", bytes.NewReader([]byte{0x08, 0x01}))
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		lines := capture.Lines()
+		require.Equal(t, 8, len(lines), test.path)
+		// The request type isn't resolved
+		assert.Contains(t, lines[2], "\r\n\r\n00000000  08 01 ", test.path)
+		assert.Contains(t, lines[6], test.want, test.path)
+		if !strings.Contains(test.want, "id:") {
+			assert.NotContains(t, lines[6], "id:", test.path)
+		}
+	}
+}
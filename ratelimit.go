@@ -0,0 +1,71 @@
+package debughttp
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rateLimitHeaders are the fields shown by HighlightRateLimit, each
+// with the variants of the headers they are read from, in the order
+// they are tried
+var rateLimitHeaders = []struct {
+	field   string
+	headers []string
+}{
+	{"limit", []string{"X-RateLimit-Limit", "X-Rate-Limit-Limit", "RateLimit-Limit"}},
+	{"remaining", []string{"X-RateLimit-Remaining", "X-Rate-Limit-Remaining", "RateLimit-Remaining"}},
+	{"reset", []string{"X-RateLimit-Reset", "X-Rate-Limit-Reset", "RateLimit-Reset"}},
+	{"retry-after", []string{"Retry-After"}},
+}
+
+// minEpochSeconds is the smallest reset value treated as a Unix time
+// rather than a number of seconds to wait
+const minEpochSeconds = 1000000000
+
+// formatWait formats value, a number of seconds to wait, a Unix time
+// or an HTTP date, as how long to wait from now, eg "30s". It is
+// returned as is if it isn't any of those.
+func formatWait(value string, now time.Time) string {
+	var wait time.Duration
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds >= minEpochSeconds {
+			wait = time.Unix(seconds, 0).Sub(now).Round(time.Second)
+		} else {
+			wait = time.Duration(seconds) * time.Second
+		}
+	} else if date, err := http.ParseTime(value); err == nil {
+		wait = date.Sub(now).Round(time.Second)
+	} else {
+		return value
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait.String()
+}
+
+// rateLimitLine returns a line summarising the rate limit headers in
+// header, eg "rate-limit: remaining=0 reset=30s retry-after=30s", or
+// "" if there aren't any
+func rateLimitLine(header http.Header, now time.Time) string {
+	var b strings.Builder
+	for _, item := range rateLimitHeaders {
+		for _, name := range item.headers {
+			value := strings.TrimSpace(header.Get(name))
+			if value == "" {
+				continue
+			}
+			if item.field == "reset" || item.field == "retry-after" {
+				value = formatWait(value, now)
+			}
+			b.WriteString(" " + item.field + "=" + value)
+			break
+		}
+	}
+	if b.Len() == 0 {
+		return ""
+	}
+	return "rate-limit:" + b.String()
+}
@@ -0,0 +1,86 @@
+package debughttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatWait(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, test := range []struct {
+		in   string
+		want string
+	}{
+		{"30", "30s"},
+		{"0", "0s"},
+		{"3600", "1h0m0s"},
+		{"1577934275", "30s"},
+		{"1577934000", "0s"},
+		{"Thu, 02 Jan 2020 03:05:05 GMT", "1m0s"},
+		{"soon", "soon"},
+	} {
+		assert.Equal(t, test.want, formatWait(test.in, now), test.in)
+	}
+}
+
+func TestRateLimitLine(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, test := range []struct {
+		header http.Header
+		want   string
+	}{
+		{http.Header{}, ""},
+		{http.Header{"Content-Type": {"text/plain"}}, ""},
+		{http.Header{
+			"X-Ratelimit-Remaining": {"0"},
+			"X-Ratelimit-Reset":     {"30"},
+			"Retry-After":           {"30"},
+		}, "rate-limit: remaining=0 reset=30s retry-after=30s"},
+		{http.Header{
+			"X-Rate-Limit-Limit":     {"100"},
+			"X-Rate-Limit-Remaining": {"99"},
+		}, "rate-limit: limit=100 remaining=99"},
+		{http.Header{
+			"Ratelimit-Limit":     {"10"},
+			"Ratelimit-Remaining": {"5"},
+			"Ratelimit-Reset":     {"1577934285"},
+		}, "rate-limit: limit=10 remaining=5 reset=40s"},
+	} {
+		assert.Equal(t, test.want, rateLimitLine(test.header, now))
+	}
+}
+
+func TestHighlightRateLimit(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/limited" {
+			// Use a non canonical name to check the lookup
+			w.Header()["x-ratelimit-remaining"] = []string{"0"}
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer ts.Close()
+
+	client, capture := NewCaptureClient(&Options{
+		Flags:              DumpHeaders,
+		HighlightRateLimit: true,
+	})
+	resp, err := client.Get(ts.URL + "/limited")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	lines := capture.Lines()
+	require.Equal(t, 9, len(lines))
+	assert.Equal(t, "rate-limit: remaining=0 retry-after=7s", lines[6])
+
+	// Nothing is added without the headers
+	capture.Reset()
+	resp, err = client.Get(ts.URL)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, 8, len(capture.Lines()))
+}
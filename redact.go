@@ -0,0 +1,408 @@
+package debughttp
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Redactor redacts secrets from the dumped requests and responses.
+type Redactor interface {
+	// Redact returns buf, the dump of a request or response as
+	// selected by dir, with the secrets redacted. The dump has the
+	// headers and, if they are being dumped, the body which has
+	// the type contentType. The dump is in HTTP/1.1 wire format
+	// except for FormatHTTPFile.
+	Redact(buf []byte, dir Direction, contentType string) []byte
+}
+
+// RedactorFunc is an adapter to allow the use of an ordinary
+// function as a Redactor.
+type RedactorFunc func(buf []byte, dir Direction, contentType string) []byte
+
+// Redact calls f(buf, dir, contentType).
+func (f RedactorFunc) Redact(buf []byte, dir Direction, contentType string) []byte {
+	return f(buf, dir, contentType)
+}
+
+// authRedactor is the default Redactor which redacts the values of the
+// Auth headers in requests unless DumpAuth is set.
+type authRedactor struct {
+	t *Transport
+}
+
+// Redact implements Redactor.
+func (r authRedactor) Redact(buf []byte, dir Direction, contentType string) []byte {
+	return r.redactAudited(buf, dir, contentType, nil)
+}
+
+// redactAudited implements auditedRedactor.
+func (r authRedactor) redactAudited(buf []byte, dir Direction, contentType string, audit *redactionAudit) []byte {
+	if dir != DirectionRequest {
+		return buf
+	}
+	if r.t.opt.Flags&DumpAuth == 0 {
+		buf = r.t.cleanAuthsAudited(buf, audit)
+		if r.t.opt.MarkAuthPresent {
+			buf = r.t.markAuthAbsent(buf)
+		}
+		return buf
+	} else if r.t.opt.RedactJWT {
+		return r.t.cleanJWTsAudited(buf, audit)
+	}
+	return buf
+}
+
+// setCookieRedactor is the default Redactor which redacts the values
+// of the cookies in the Set-Cookie headers of responses unless
+// DumpAuth or ShowSetCookie are set.
+type setCookieRedactor struct {
+	t *Transport
+}
+
+// Redact implements Redactor.
+func (r setCookieRedactor) Redact(buf []byte, dir Direction, contentType string) []byte {
+	return r.redactAudited(buf, dir, contentType, nil)
+}
+
+// redactAudited implements auditedRedactor.
+func (r setCookieRedactor) redactAudited(buf []byte, dir Direction, contentType string, audit *redactionAudit) []byte {
+	if dir != DirectionResponse || !r.t.redactSetCookie() {
+		return buf
+	}
+	mask := r.t.maskFunc()
+	return rewriteHeaders(buf, []byte("Set-Cookie"), func(value []byte) []byte {
+		if name := cookieName(value); name != "" {
+			audit.add("Set-Cookie(" + name + ")")
+		}
+		return maskSetCookie(value, mask)
+	})
+}
+
+// redactSetCookie returns true if the Set-Cookie headers should be
+// redacted
+func (t *Transport) redactSetCookie() bool {
+	return t.opt.Flags&DumpAuth == 0 && !t.opt.ShowSetCookie
+}
+
+// maskSetCookie masks the cookie value in the Set-Cookie header value
+// using mask, leaving the cookie name and the attributes, eg
+// "Path=/; HttpOnly", visible.
+func maskSetCookie(value []byte, mask func(value []byte) []byte) []byte {
+	end := bytes.IndexByte(value, ';')
+	if end < 0 {
+		end = len(value)
+	}
+	start := bytes.IndexByte(value[:end], '=') + 1
+	out := make([]byte, 0, len(value))
+	out = append(out, value[:start]...)
+	out = append(out, mask(value[start:end])...)
+	return append(out, value[end:]...)
+}
+
+// urlHeaders are the headers which may contain URLs with credentials
+var urlHeaders = [][]byte{
+	[]byte("Location"),
+	[]byte("Content-Location"),
+	[]byte("Referer"),
+	[]byte("Origin"),
+}
+
+// urlRedactor is the default Redactor which redacts the credentials in
+// the URLs in urlHeaders unless DumpAuth is set.
+type urlRedactor struct {
+	t *Transport
+}
+
+// Redact implements Redactor.
+func (r urlRedactor) Redact(buf []byte, dir Direction, contentType string) []byte {
+	return r.redactAudited(buf, dir, contentType, nil)
+}
+
+// redactAudited implements auditedRedactor.
+func (r urlRedactor) redactAudited(buf []byte, dir Direction, contentType string, audit *redactionAudit) []byte {
+	if dir == DirectionRequest {
+		buf = r.t.redactRequestLine(buf, audit)
+	}
+	if r.t.opt.Flags&DumpAuth != 0 {
+		return buf
+	}
+	for _, name := range urlHeaders {
+		buf = rewriteHeaders(buf, name, func(value []byte) []byte {
+			scrubbed := r.t.scrubURLString(string(value))
+			if scrubbed != string(value) {
+				audit.add(string(name))
+			}
+			return []byte(scrubbed)
+		})
+	}
+	return buf
+}
+
+// funcRedactor is the default Redactor which rewrites the header
+// values with RedactFunc if it is set, even if DumpAuth is set.
+type funcRedactor struct {
+	t *Transport
+}
+
+// Redact implements Redactor.
+func (r funcRedactor) Redact(buf []byte, dir Direction, contentType string) []byte {
+	return r.redactAudited(buf, dir, contentType, nil)
+}
+
+// redactAudited implements auditedRedactor.
+func (r funcRedactor) redactAudited(buf []byte, dir Direction, contentType string, audit *redactionAudit) []byte {
+	redactFunc := r.t.opt.RedactFunc
+	if redactFunc == nil {
+		return buf
+	}
+	return rewriteHeaderValues(buf, func(name string, value []byte) ([]byte, bool) {
+		newValue, ok := redactFunc(name, string(value))
+		if ok {
+			audit.changed(name, value, []byte(newValue))
+		}
+		return []byte(newValue), ok
+	})
+}
+
+// lineRedactor is the default Redactor which redacts the text
+// matching RedactLinePatterns in the request or status line and the
+// values of the urlHeaders if it is set, even if DumpAuth is set.
+type lineRedactor struct {
+	t *Transport
+}
+
+// Redact implements Redactor.
+func (r lineRedactor) Redact(buf []byte, dir Direction, contentType string) []byte {
+	return r.redactAudited(buf, dir, contentType, nil)
+}
+
+// redactAudited implements auditedRedactor.
+func (r lineRedactor) redactAudited(buf []byte, dir Direction, contentType string, audit *redactionAudit) []byte {
+	patterns := r.t.opt.RedactLinePatterns
+	if len(patterns) == 0 {
+		return buf
+	}
+	d, ok := splitDump(buf)
+	if !ok {
+		return buf
+	}
+	d.start = redactPatterns(d.start, patterns, audit)
+	for i, line := range d.headers {
+		name, _, ok := splitHeader(line)
+		if !ok {
+			continue
+		}
+		for _, urlHeader := range urlHeaders {
+			if name == string(urlHeader) {
+				colon := bytes.IndexByte(line, ':')
+				d.headers[i] = append(line[:colon+1:colon+1], redactPatterns(line[colon+1:], patterns, audit)...)
+				break
+			}
+		}
+	}
+	return d.join()
+}
+
+// redactPatterns returns line with the text matching any of patterns
+// replaced with "xxxxx", recording the patterns which matched in
+// audit. If a pattern has a capturing group only the text matching the
+// first one is replaced.
+func redactPatterns(line []byte, patterns []*regexp.Regexp, audit *redactionAudit) []byte {
+	for _, pattern := range patterns {
+		matches := pattern.FindAllSubmatchIndex(line, -1)
+		if matches == nil {
+			continue
+		}
+		audit.pattern(pattern)
+		group := 0
+		if pattern.NumSubexp() > 0 {
+			group = 1
+		}
+		out := make([]byte, 0, len(line))
+		last := 0
+		for _, match := range matches {
+			start, end := match[2*group], match[2*group+1]
+			if start < 0 {
+				continue
+			}
+			out = append(out, line[last:start]...)
+			out = append(out, "xxxxx"...)
+			last = end
+		}
+		line = append(out, line[last:]...)
+	}
+	return line
+}
+
+// redactLine returns line, eg a URL or the status of a response, with
+// the text matching RedactLinePatterns redacted
+func (t *Transport) redactLine(line string) string {
+	if len(t.opt.RedactLinePatterns) == 0 {
+		return line
+	}
+	return string(redactPatterns([]byte(line), t.opt.RedactLinePatterns, nil))
+}
+
+// scrubURL returns u as a string with the password, and the user name
+// if RedactURLUser is set, replaced with "xxxxx", as are the values of
+// the query parameters matching RedactQueryPatterns and the text
+// matching RedactLinePatterns.
+//
+// All the URLs which are logged should go through this.
+func (t *Transport) scrubURL(u *url.URL) string {
+	return t.redactLine(t.scrubURLCredentials(u))
+}
+
+// scrubURLCredentials is scrubURL without RedactLinePatterns
+func (t *Transport) scrubURLCredentials(u *url.URL) string {
+	if u.User == nil && len(t.opt.RedactQueryPatterns) == 0 {
+		return u.String()
+	}
+	scrubbed := *u
+	scrubbed.RawQuery = t.redactQuery(u.RawQuery)
+	if u.User == nil {
+		return scrubbed.String()
+	}
+	username := u.User.Username()
+	if t.opt.RedactURLUser {
+		username = "xxxxx"
+	}
+	if _, hasPassword := u.User.Password(); hasPassword {
+		scrubbed.User = url.UserPassword(username, "xxxxx")
+	} else {
+		scrubbed.User = url.User(username)
+	}
+	return scrubbed.String()
+}
+
+// scrubURLString is scrubURL for a URL in a string. It is returned
+// as is if it can't be parsed or has no credentials.
+func (t *Transport) scrubURLString(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.User == nil && len(t.opt.RedactQueryPatterns) == 0) {
+		return rawURL
+	}
+	return t.scrubURL(u)
+}
+
+// scrubRequestURI returns the request URI of u, eg "/path?query",
+// with the values of the query parameters matching
+// RedactQueryPatterns replaced with "xxxxx" and the text matching
+// RedactLinePatterns redacted
+func (t *Transport) scrubRequestURI(u *url.URL) string {
+	if len(t.opt.RedactQueryPatterns) == 0 {
+		return t.redactLine(u.RequestURI())
+	}
+	scrubbed := *u
+	scrubbed.RawQuery = t.redactQuery(u.RawQuery)
+	return t.redactLine(scrubbed.RequestURI())
+}
+
+// redactQuery returns rawQuery with the values of the parameters whose
+// names match RedactQueryPatterns replaced with "xxxxx". The rest of it
+// is left exactly as it was, including the order and the encoding.
+func (t *Transport) redactQuery(rawQuery string) string {
+	return t.redactQueryAudited(rawQuery, nil)
+}
+
+// redactQueryAudited is redactQuery recording the names of the
+// parameters redacted in audit
+func (t *Transport) redactQueryAudited(rawQuery string, audit *redactionAudit) string {
+	patterns := t.opt.RedactQueryPatterns
+	if len(patterns) == 0 || rawQuery == "" {
+		return rawQuery
+	}
+	params := strings.Split(rawQuery, "&")
+	for i, param := range params {
+		equals := strings.IndexByte(param, '=')
+		if equals < 0 {
+			continue
+		}
+		key := param[:equals]
+		name, err := url.QueryUnescape(key)
+		if err != nil {
+			name = key
+		}
+		for _, pattern := range patterns {
+			if pattern.MatchString(name) {
+				audit.add("query(" + name + ")")
+				params[i] = key + "=xxxxx"
+				break
+			}
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// redactRequestLine redacts the query of the target on the request
+// line at the start of the dump in buf with redactQuery, recording the
+// parameters redacted in audit
+func (t *Transport) redactRequestLine(buf []byte, audit *redactionAudit) []byte {
+	if len(t.opt.RedactQueryPatterns) == 0 {
+		return buf
+	}
+	end := bytes.IndexByte(buf, '\n')
+	if end < 0 {
+		end = len(buf)
+	}
+	line := buf[:end]
+	start := bytes.IndexByte(line, ' ')
+	if start < 0 {
+		return buf
+	}
+	start++
+	targetEnd := bytes.IndexAny(line[start:], " \r")
+	if targetEnd < 0 {
+		targetEnd = len(line)
+	} else {
+		targetEnd += start
+	}
+	query := bytes.IndexByte(line[start:targetEnd], '?')
+	if query < 0 {
+		return buf
+	}
+	query += start + 1
+	redacted := t.redactQueryAudited(string(buf[query:targetEnd]), audit)
+	out := make([]byte, 0, len(buf)+len(redacted))
+	out = append(out, buf[:query]...)
+	out = append(out, redacted...)
+	return append(out, buf[targetEnd:]...)
+}
+
+// redact runs buf, a dump of req or its response, through all the
+// Redactors in order, logging what was redacted if RedactAudit is set
+func (t *Transport) redact(req *http.Request, buf []byte, dir Direction, contentType string) []byte {
+	if !t.opt.RedactAudit {
+		return t.runRedactors(buf, dir, contentType)
+	}
+	// The default Redactors say what they redacted, the ones in
+	// Options.Redactors are compared before and after
+	audit := new(redactionAudit)
+	defaults := len(t.redactors) - len(t.opt.Redactors)
+	for i, redactor := range t.redactors {
+		if r, ok := redactor.(auditedRedactor); ok {
+			buf = r.redactAudited(buf, dir, contentType, audit)
+			continue
+		}
+		before := append([]byte(nil), buf...)
+		buf = redactor.Redact(buf, dir, contentType)
+		if !bytes.Equal(before, buf) {
+			audit.redactor(i - defaults)
+		}
+	}
+	if len(audit.names) > 0 {
+		t.logf(req, "redacted: %s", strings.Join(audit.names, ", "))
+	}
+	return buf
+}
+
+// runRedactors runs buf through the Redactors without auditing them
+func (t *Transport) runRedactors(buf []byte, dir Direction, contentType string) []byte {
+	for _, redactor := range t.redactors {
+		buf = redactor.Redact(buf, dir, contentType)
+	}
+	return buf
+}
@@ -0,0 +1,99 @@
+package debughttp
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// routeNote returns the SNI server name sent and the proxy used for
+// tx to show with DumpConn, eg " sni=example.com proxy=http://proxy:3128"
+//
+// The SNI is only shown for https requests and is "none" if the host
+// is an IP address as those aren't sent. The proxy is the one chosen
+// by the Proxy function of the *http.Transport, recorded by New as it
+// is called, so it isn't shown if there isn't one, eg made by
+// NewRoundTripper, and is "none" if the request went direct.
+func (t *Transport) routeNote(tx *transaction) string {
+	req := tx.req
+	note := ""
+	if req.URL.Scheme == "https" {
+		note += " sni=" + t.sniName(tx)
+	}
+	if t.Transport != nil {
+		note += " proxy=" + t.proxyName(tx)
+	}
+	return note
+}
+
+// sniName returns the server name sent in the TLS handshake for tx or
+// "none" if there wasn't one
+func (t *Transport) sniName(tx *transaction) string {
+	var name string
+	switch {
+	case tx.resp != nil && tx.resp.TLS != nil:
+		name = tx.resp.TLS.ServerName
+	case t.Transport != nil && t.Transport.TLSClientConfig != nil && t.Transport.TLSClientConfig.ServerName != "":
+		name = t.Transport.TLSClientConfig.ServerName
+	default:
+		name = tx.req.URL.Hostname()
+	}
+	if name == "" || net.ParseIP(name) != nil {
+		return "none"
+	}
+	return name
+}
+
+// proxyName returns the proxy the *http.Transport used for tx with
+// any credentials redacted or "none" if it went direct
+func (t *Transport) proxyName(tx *transaction) string {
+	if t.Transport.Proxy == nil {
+		return "none"
+	}
+	if !tx.conn.proxyResolved {
+		return "unknown"
+	}
+	if tx.conn.proxyErr != nil {
+		return "error"
+	}
+	if tx.conn.proxy == nil {
+		return "none"
+	}
+	return t.scrubURL(tx.conn.proxy)
+}
+
+// wantsConn returns true if opt, or one of its Sinks or PerHost
+// Options, has DumpConn set
+func wantsConn(opt *Options) bool {
+	if (opt.Flags|verbosityFlags(opt.Verbosity))&DumpConn != 0 {
+		return true
+	}
+	for _, sink := range opt.Sinks {
+		if sink.Flags&DumpConn != 0 {
+			return true
+		}
+	}
+	for _, hostOpt := range opt.PerHost {
+		if (hostOpt.Flags|verbosityFlags(hostOpt.Verbosity))&DumpConn != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyTransport returns a clone of transport whose Proxy records the
+// proxy it chooses for each request in the connInfo of the request so
+// the one actually used is logged even if Proxy doesn't always choose
+// the same one, eg round robin.
+func proxyTransport(transport *http.Transport) *http.Transport {
+	transport = transport.Clone()
+	proxy := transport.Proxy
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		proxyURL, err := proxy(req)
+		if info, ok := req.Context().Value(connInfoKey{}).(*connInfo); ok {
+			info.proxyResolved, info.proxy, info.proxyErr = true, proxyURL, err
+		}
+		return proxyURL, err
+	}
+	return transport
+}
@@ -0,0 +1,143 @@
+package debughttp
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// connectionLine returns the DumpConn line from lines
+func connectionLine(t *testing.T, lines []string) string {
+	for _, line := range lines {
+		if strings.HasPrefix(line, "connection:") {
+			return line
+		}
+	}
+	t.Fatalf("no connection line in %q", lines)
+	return ""
+}
+
+func TestRouteNoProxy(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Response body")
+	}))
+	defer ts.Close()
+
+	for _, test := range []struct {
+		name       string
+		serverName string
+		want       string
+	}{
+		{name: "IP", want: " sni=none proxy=none"},
+		{name: "ServerName", serverName: "example.com", want: " sni=example.com proxy=none"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			inner := ts.Client().Transport.(*http.Transport).Clone()
+			inner.Proxy = nil
+			inner.TLSClientConfig.ServerName = test.serverName
+			var capture Capture
+			client := &http.Client{Transport: New(&Options{
+				Flags: DumpHeaders | DumpConn,
+				Logf:  capture.Logf,
+			}, inner)}
+			resp, err := client.Get(ts.URL)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			line := connectionLine(t, capture.Lines())
+			assert.Regexp(t, `^connection: local=\S+ remote=\S+ reused=false idle=0s`, line)
+			assert.Regexp(t, `reused=false idle=0s`+test.want+`$`, line)
+		})
+	}
+}
+
+func TestRouteProxy(t *testing.T) {
+	// A forward proxy which answers the requests itself
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "http://example.com/path", r.RequestURI)
+		fmt.Fprint(w, "Response from the proxy")
+	}))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	require.NoError(t, err)
+	authProxyURL := *proxyURL
+	authProxyURL.User = url.UserPassword("user", "SECRET")
+
+	var capture Capture
+	client := &http.Client{Transport: New(&Options{
+		Flags: DumpHeaders | DumpConn,
+		Logf:  capture.Logf,
+	}, &http.Transport{Proxy: http.ProxyURL(&authProxyURL)})}
+	resp, err := client.Get("http://example.com/path")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	// The proxy is shown without its password and there is no SNI
+	// for http
+	line := connectionLine(t, capture.Lines())
+	assert.Regexp(t, `^connection: local=\S+ remote=`+proxyURL.Host+` reused=false idle=0s proxy=http://user:xxxxx@`+proxyURL.Host+`$`, line)
+	assert.NotContains(t, capture.String(), "SECRET")
+}
+
+func TestRouteProxyRoundRobin(t *testing.T) {
+	// Two forward proxies saying which they are
+	var proxies []*url.URL
+	for i := 0; i < 2; i++ {
+		name := fmt.Sprint("proxy", i)
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, name)
+		}))
+		defer proxy.Close()
+		proxyURL, err := url.Parse(proxy.URL)
+		require.NoError(t, err)
+		proxies = append(proxies, proxyURL)
+	}
+	calls := 0
+	inner := &http.Transport{Proxy: func(req *http.Request) (*url.URL, error) {
+		proxyURL := proxies[calls%len(proxies)]
+		calls++
+		return proxyURL, nil
+	}}
+
+	var capture Capture
+	client := &http.Client{Transport: New(&Options{
+		Flags: DumpHeaders | DumpConn,
+		Logf:  capture.Logf,
+	}, inner)}
+	for i := 0; i < 4; i++ {
+		capture.Reset()
+		resp, err := client.Get("http://example.com/")
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		// The proxy logged is the one which was used, not the next
+		used := proxies[i%len(proxies)]
+		assert.Equal(t, fmt.Sprint("proxy", i%len(proxies)), string(body))
+		assert.True(t, strings.HasSuffix(connectionLine(t, capture.Lines()), " proxy="+used.String()), capture.String())
+	}
+	assert.Equal(t, 4, calls)
+}
+
+func TestRouteProxyFailed(t *testing.T) {
+	// The proxy is shown even if the connection to it failed
+	var capture Capture
+	client := &http.Client{Transport: New(&Options{
+		Flags: DumpHeaders | DumpConn,
+		Logf:  capture.Logf,
+	}, &http.Transport{
+		Proxy:           http.ProxyURL(&url.URL{Scheme: "http", Host: "127.0.0.1:1"}),
+		TLSClientConfig: &tls.Config{ServerName: "example.org"},
+	})}
+	_, err := client.Get("https://example.com/")
+	require.Error(t, err)
+	assert.Equal(t, "connection: sni=example.org proxy=http://127.0.0.1:1", connectionLine(t, capture.Lines()))
+}
@@ -0,0 +1,118 @@
+package debughttp
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// deferredLine is a line logged to a deferredLogger
+type deferredLine struct {
+	lvl level
+	msg string
+}
+
+// deferredLogger holds the lines logged for a transaction before the
+// round trip until ShouldLog has decided whether to log them.
+type deferredLogger struct {
+	mu     sync.Mutex
+	req    *http.Request
+	lines  []deferredLine
+	done   bool       // set once ShouldLog has been called
+	target *Transport // where to log the lines once done or nil to drop them
+	t      *Transport // copy of the output which logs to this
+}
+
+// deferLogs returns a copy of t which logs to a new deferredLogger
+// for tx until ShouldLog has been called by logAfter
+func (t *Transport) deferLogs(tx *transaction) *Transport {
+	d := &deferredLogger{req: tx.req}
+	c := t.withFlags(t.opt.Flags)
+	c.out = nil
+	c.opt.Writer = nil
+	c.opt.LogfCtx = nil
+	c.opt.LeveledLogger = d
+	d.t = c
+	if tx.deferred == nil {
+		tx.deferred = make(map[*Transport]*deferredLogger)
+	}
+	tx.deferred[t] = d
+	return c
+}
+
+// logger returns the Transport which out should log the transaction
+// with, which is a copy logging to a deferredLogger before the round
+// trip if ShouldLog is set
+func (tx *transaction) logger(out *Transport) *Transport {
+	if d := tx.deferred[out]; d != nil {
+		return d.t
+	}
+	return out
+}
+
+// logf records the line or logs it to the target if done
+func (d *deferredLogger) logf(lvl level, format string, v ...interface{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.done {
+		if d.target != nil {
+			d.target.logfLevel(d.req, lvl, format, v...)
+		}
+		return
+	}
+	d.lines = append(d.lines, deferredLine{lvl: lvl, msg: fmt.Sprintf(format, v...)})
+}
+
+// Debugf implements Logger
+func (d *deferredLogger) Debugf(format string, v ...interface{}) {
+	d.logf(levelDebug, format, v...)
+}
+
+// Warnf implements Logger
+func (d *deferredLogger) Warnf(format string, v ...interface{}) {
+	d.logf(levelWarn, format, v...)
+}
+
+// Errorf implements Logger
+func (d *deferredLogger) Errorf(format string, v ...interface{}) {
+	d.logf(levelError, format, v...)
+}
+
+// finish logs the lines recorded so far, and any logged later, to t
+// if keep is set or drops them otherwise
+func (d *deferredLogger) finish(t *Transport, keep bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.done = true
+	if keep {
+		d.target = t
+		for _, line := range d.lines {
+			t.logfLevel(d.req, line.lvl, "%s", line.msg)
+		}
+	}
+	d.lines = nil
+}
+
+// shouldLog calls ShouldLog, if set, to decide whether to log tx,
+// logging or dropping the lines deferred before the round trip
+func (t *Transport) shouldLog(tx *transaction) bool {
+	if t.opt.ShouldLog == nil {
+		return true
+	}
+	keep := t.opt.ShouldLog(tx.req, tx.resp, tx.duration, tx.err)
+	if d := tx.deferred[t]; d != nil {
+		d.finish(t, keep)
+	}
+	return keep
+}
+
+// bodySelected returns true if BodyFilter selects the request body of
+// req for logging. It is selected if the body can't be read.
+func (t *Transport) bodySelected(req *http.Request) bool {
+	body, err := t.requestBody(req)
+	if err != nil {
+		t.logfLevel(req, levelWarn, "BodyFilter: failed to read request body: %v", err)
+		return true
+	}
+	return t.opt.BodyFilter(body, req.Header.Get("Content-Type"))
+}
@@ -0,0 +1,138 @@
+package debughttp
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShouldLog(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = ioutil.ReadAll(r.Body)
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		_, _ = w.Write([]byte("response"))
+	}))
+	defer ts.Close()
+
+	var calls int
+	var gotErr error
+	client, capture := NewCaptureClient(&Options{
+		Flags:    DumpBodies | DumpSummary,
+		BodyHash: true,
+		ShouldLog: func(req *http.Request, resp *http.Response, dur time.Duration, err error) bool {
+			calls++
+			gotErr = err
+			assert.True(t, dur > 0)
+			return err != nil || resp.StatusCode >= 400
+		},
+	})
+	do := func(url string) error {
+		resp, err := client.Post(url, "text/plain", strings.NewReader("request"))
+		if err != nil {
+			return err
+		}
+		_, err = ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return nil
+	}
+
+	// Vetoed transactions log nothing, even the body hashes
+	require.NoError(t, do(ts.URL+"/ok"))
+	assert.Equal(t, 1, calls)
+	assert.Empty(t, capture.Lines())
+
+	// Others are logged in the usual order
+	require.NoError(t, do(ts.URL+"/fail"))
+	assert.Equal(t, 2, calls)
+	lines := capture.Lines()
+	require.Equal(t, 11, len(lines))
+	assert.Equal(t, SeparatorReq, lines[0])
+	assert.True(t, strings.HasSuffix(lines[2], "\r\n\r\nrequest"), lines[2])
+	assert.True(t, strings.HasPrefix(lines[4], "HTTP REQUEST BODY ("), lines[4])
+	assert.Equal(t, SeparatorResp, lines[5])
+	assert.Contains(t, lines[9], " -> 500 Internal Server Error ")
+	assert.True(t, strings.HasPrefix(lines[10], "HTTP RESPONSE BODY ("), lines[10])
+
+	// Failed round trips are passed the error
+	capture.Reset()
+	assert.Error(t, do("http://127.0.0.1:0/"))
+	assert.Equal(t, 3, calls)
+	assert.Error(t, gotErr)
+	lines = capture.Lines()
+	require.NotEmpty(t, lines)
+	assert.Contains(t, lines[len(lines)-1], " -> failed: ")
+}
+
+func TestBodyFilter(t *testing.T) {
+	var received []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received = append(received, string(body))
+	}))
+	defer ts.Close()
+
+	client, capture := NewCaptureClient(&Options{
+		Flags: DumpBodies | DumpSummary,
+		BodyFilter: func(body []byte, contentType string) bool {
+			if contentType != "application/json" {
+				return false
+			}
+			var query struct {
+				OperationName string `json:"operationName"`
+			}
+			return json.Unmarshal(body, &query) == nil && query.OperationName == "GetUser"
+		},
+	})
+	transport := client.Transport.(*Transport)
+	for _, test := range []struct {
+		body       string
+		replayable bool
+		want       bool
+	}{
+		{`{"operationName":"ListFiles","query":"{files}"}`, true, false},
+		{`{"operationName":"GetUser","query":"{user}"}`, true, true},
+		{`{"operationName":"ListFiles","query":"{files}"}`, false, false},
+		{`{"operationName":"GetUser","query":"{user}"}`, false, true},
+	} {
+		capture.Reset()
+		var body io.Reader = strings.NewReader(test.body)
+		if !test.replayable {
+			body = ioutil.NopCloser(body)
+		}
+		req, err := http.NewRequest("POST", ts.URL+"/graphql", body)
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		if !test.replayable {
+			req.GetBody = nil
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		out := capture.String()
+		if test.want {
+			assert.Contains(t, out, test.body, test.body)
+			assert.Contains(t, out, "POST "+ts.URL+"/graphql -> 200 OK", test.body)
+		} else {
+			assert.NotContains(t, out, "HTTP REQUEST", test.body)
+			assert.NotContains(t, out, "-> 200 OK", test.body)
+		}
+		// The body is only buffered once
+		assert.True(t, strings.Count(out, "can't be replayed") <= 1, out)
+	}
+	assert.Equal(t, int64(4), transport.Stats().Requests)
+	require.Equal(t, 4, len(received))
+	for i, test := range []string{"ListFiles", "GetUser", "ListFiles", "GetUser"} {
+		assert.Contains(t, received[i], test)
+	}
+}
@@ -0,0 +1,37 @@
+package debughttp
+
+import (
+	"io"
+	"net/http"
+)
+
+// Sink is an extra destination for the dumped transactions with its
+// own Format and Flags, eg to write a raw dump to a file while also
+// writing .http files elsewhere.
+//
+// The other Options, eg Auth or PIIPatterns, are taken from the
+// Options the Sink is in.
+type Sink struct {
+	Logf   func(format string, v ...interface{}) // log with this - defaults to log.Printf if Writer is not set either
+	Writer io.Writer                             // if set, write to this instead of Logf
+	Format Format                                // how to format the dumped transactions - defaults to FormatRaw
+	Flags  DumpFlags                             // what to dump to this sink
+}
+
+// newSink makes a Transport to render the transactions for sink using
+// the rest of t's Options. It never does round trips itself.
+func newSink(t *Transport, sink Sink, transport *http.Transport) *Transport {
+	sinkOpt := t.opt
+	sinkOpt.Logf = sink.Logf
+	sinkOpt.LogfCtx = nil
+	sinkOpt.LeveledLogger = nil
+	sinkOpt.Writer = sink.Writer
+	sinkOpt.Gzip = false
+	sinkOpt.Format = sink.Format
+	sinkOpt.Flags = sink.Flags
+	sinkOpt.Verbosity = 0
+	sinkOpt.OnEvent = nil
+	sinkOpt.PerHost = nil
+	sinkOpt.Sinks = nil
+	return newTransport(&sinkOpt, transport, nil)
+}
@@ -0,0 +1,95 @@
+package debughttp
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSinks(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprintln(w, "Response body")
+	}))
+	defer ts.Close()
+
+	var lines, sinkLines []string
+	var httpFile bytes.Buffer
+	client := NewClient(&Options{
+		Flags: DumpHeaders,
+		Logf: func(format string, v ...interface{}) {
+			lines = append(lines, fmt.Sprintf(format, v...))
+		},
+		Sinks: []Sink{{
+			Flags: DumpBodies | DumpSummary,
+			Logf: func(format string, v ...interface{}) {
+				sinkLines = append(sinkLines, fmt.Sprintf(format, v...))
+			},
+		}, {
+			Flags:  DumpHeaders,
+			Format: FormatHTTPFile,
+			Writer: &httpFile,
+		}},
+	})
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.NoError(t, CloseClient(client))
+
+	// Only one round trip and the body is still readable
+	assert.Equal(t, 1, requests)
+	assert.Equal(t, "Response body\n", string(body))
+
+	// Logf gets the headers only
+	require.Equal(t, 8, len(lines))
+	assert.NotContains(t, lines[6], "Response body")
+
+	// The first sink gets the bodies and a summary
+	require.Equal(t, 9, len(sinkLines))
+	assert.Contains(t, sinkLines[6], "Response body")
+	assert.Contains(t, sinkLines[8], "GET "+ts.URL+" -> 200 OK")
+
+	// The second sink gets a .http file with the Auth redacted
+	out := httpFile.String()
+	assert.True(t, strings.HasPrefix(out, "### GET "+ts.URL+"\n"), out)
+	assert.Contains(t, out, "Authorization: XXXX")
+	assert.NotContains(t, out, "secret")
+}
+
+func TestSinkLeveledLogger(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	var recorder levelRecorder
+	var sinkLines []string
+	client := NewClient(&Options{
+		Flags:         DumpSummary,
+		LeveledLogger: &recorder,
+		Sinks: []Sink{{
+			Flags: DumpHeaders,
+			Logf: func(format string, v ...interface{}) {
+				sinkLines = append(sinkLines, fmt.Sprintf(format, v...))
+			},
+		}},
+	})
+	resp, err := client.Get(ts.URL)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	// The sink logs to its own Logf, not the LeveledLogger
+	assert.Equal(t, 1, len(recorder.lines))
+	assert.Equal(t, 8, len(sinkLines))
+}
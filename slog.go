@@ -0,0 +1,108 @@
+//go:build go1.21
+
+package debughttp
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// SlogLevel is the default level function for SlogEvents. Requests and
+// successful responses are logged at slog.LevelDebug and failed round
+// trips and 5xx responses at slog.LevelWarn.
+func SlogLevel(ev Event) slog.Level {
+	if ev.Err != nil || ev.Status >= http.StatusInternalServerError {
+		return slog.LevelWarn
+	}
+	return slog.LevelDebug
+}
+
+// SlogEvents returns a function to use as Options.OnEvent which logs
+// each Event as a record to logger at the level returned by level.
+//
+// If logger is nil slog.Default() is used and if level is nil
+// SlogLevel is used. This lets the client stay instrumented in
+// production with only the errors being shown unless debug logging
+// is enabled.
+func SlogEvents(logger *slog.Logger, level func(Event) slog.Level) func(Event) {
+	if level == nil {
+		level = SlogLevel
+	}
+	return func(ev Event) {
+		l := logger
+		if l == nil {
+			l = slog.Default()
+		}
+		lvl := level(ev)
+		ctx := context.Background()
+		if !l.Enabled(ctx, lvl) {
+			return
+		}
+		attrs := []slog.Attr{
+			slog.String("id", ev.ID),
+			slog.String("method", ev.Method),
+			slog.String("url", ev.URL),
+		}
+		msg := "HTTP REQUEST"
+		if ev.Direction == DirectionResponse {
+			msg = "HTTP RESPONSE"
+			attrs = append(attrs, slog.Duration("duration", ev.Duration))
+			if ev.Err != nil {
+				attrs = append(attrs, slog.String("error", ev.Err.Error()), slog.String("err_kind", ev.ErrKind))
+			} else {
+				attrs = append(attrs, slog.Int("status", ev.Status))
+			}
+		}
+		if ev.Headers != nil {
+			attrs = append(attrs, slog.Any("headers", ev.Headers))
+		}
+		if ev.Body != nil {
+			attrs = append(attrs, slog.String("body", string(ev.Body)))
+		}
+		l.LogAttrs(ctx, lvl, msg, attrs...)
+	}
+}
+
+// slogLogger adapts a *slog.Logger to the Logger interface
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// SlogLogger returns a Logger to use as Options.LeveledLogger which
+// logs each line of the dumps as a record to logger at the
+// corresponding slog level.
+//
+// If logger is nil slog.Default() is used.
+func SlogLogger(logger *slog.Logger) Logger {
+	return slogLogger{logger: logger}
+}
+
+// log logs the line at lvl if it is enabled
+func (l slogLogger) log(lvl slog.Level, format string, v ...interface{}) {
+	logger := l.logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	ctx := context.Background()
+	if !logger.Enabled(ctx, lvl) {
+		return
+	}
+	logger.Log(ctx, lvl, fmt.Sprintf(format, v...))
+}
+
+// Debugf logs at slog.LevelDebug
+func (l slogLogger) Debugf(format string, v ...interface{}) {
+	l.log(slog.LevelDebug, format, v...)
+}
+
+// Warnf logs at slog.LevelWarn
+func (l slogLogger) Warnf(format string, v ...interface{}) {
+	l.log(slog.LevelWarn, format, v...)
+}
+
+// Errorf logs at slog.LevelError
+func (l slogLogger) Errorf(format string, v ...interface{}) {
+	l.log(slog.LevelError, format, v...)
+}
@@ -0,0 +1,109 @@
+//go:build go1.21
+
+package debughttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlogLevel(t *testing.T) {
+	for _, test := range []struct {
+		ev   Event
+		want slog.Level
+	}{
+		{Event{Direction: DirectionRequest}, slog.LevelDebug},
+		{Event{Direction: DirectionResponse, Status: 200}, slog.LevelDebug},
+		{Event{Direction: DirectionResponse, Status: 404}, slog.LevelDebug},
+		{Event{Direction: DirectionResponse, Status: 503}, slog.LevelWarn},
+		{Event{Direction: DirectionResponse, Err: errors.New("boom")}, slog.LevelWarn},
+	} {
+		assert.Equal(t, test.want, SlogLevel(test.ev), test.ev)
+	}
+}
+
+func TestSlogEvents(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	records := func() (out []map[string]interface{}) {
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			if line == "" {
+				continue
+			}
+			assert.NotContains(t, line, "POTATO")
+			var record map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(line), &record))
+			out = append(out, record)
+		}
+		buf.Reset()
+		return out
+	}
+
+	for _, test := range []struct {
+		name     string
+		minLevel slog.Level
+		path     string
+		want     []string
+	}{
+		{name: "Debug", minLevel: slog.LevelDebug, path: "/ok", want: []string{"DEBUG HTTP REQUEST", "DEBUG HTTP RESPONSE"}},
+		{name: "WarnOK", minLevel: slog.LevelWarn, path: "/ok"},
+		{name: "WarnFail", minLevel: slog.LevelWarn, path: "/fail", want: []string{"WARN HTTP RESPONSE"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: test.minLevel}))
+			client := NewClient(&Options{
+				Logf:    func(format string, v ...interface{}) {},
+				OnEvent: SlogEvents(logger, nil),
+			})
+			req, err := http.NewRequest(http.MethodGet, ts.URL+test.path, nil)
+			require.NoError(t, err)
+			req.Header.Set("Authorization", "POTATO")
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+
+			var got []string
+			for _, record := range records() {
+				got = append(got, record["level"].(string)+" "+record["msg"].(string))
+				assert.Equal(t, ts.URL+test.path, record["url"])
+			}
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	client := NewClient(&Options{
+		Flags:         DumpSummary,
+		LeveledLogger: SlogLogger(logger),
+	})
+	_, err := client.Get("http://127.0.0.1:1/")
+	require.Error(t, err)
+
+	var record map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "ERROR", record["level"])
+	assert.True(t, strings.HasPrefix(record["msg"].(string), "GET http://127.0.0.1:1/ -> failed: "), record["msg"])
+
+	// Debug lines are filtered out
+	buf.Reset()
+	SlogLogger(logger).Debugf("hidden %d", 1)
+	assert.Equal(t, 0, buf.Len())
+}
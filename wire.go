@@ -0,0 +1,180 @@
+package debughttp
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// maxWireCapture is the maximum number of bytes captured in each
+// direction for each request by DumpWire
+const maxWireCapture = 64 * 1024
+
+// wireConn wraps a net.Conn recording the bytes written to and read
+// from it since the last call to reset.
+type wireConn struct {
+	net.Conn
+	mu       sync.Mutex
+	sent     wireBuffer
+	received wireBuffer
+}
+
+// wireBuffer captures up to maxWireCapture bytes counting the rest
+type wireBuffer struct {
+	buf []byte
+	n   int64
+}
+
+// write captures p
+func (b *wireBuffer) write(p []byte) {
+	b.n += int64(len(p))
+	if room := maxWireCapture - len(b.buf); room > 0 {
+		if len(p) > room {
+			p = p[:room]
+		}
+		b.buf = append(b.buf, p...)
+	}
+}
+
+// bytes returns a copy of the captured bytes noting any which
+// weren't captured
+func (b *wireBuffer) bytes() []byte {
+	out := append([]byte(nil), b.buf...)
+	if missed := b.n - int64(len(b.buf)); missed > 0 {
+		out = append(out, fmt.Sprintf("\n... [%d bytes not captured]\n", missed)...)
+	}
+	return out
+}
+
+// Read reads from the connection recording the bytes
+func (c *wireConn) Read(p []byte) (n int, err error) {
+	n, err = c.Conn.Read(p)
+	c.mu.Lock()
+	c.received.write(p[:n])
+	c.mu.Unlock()
+	return n, err
+}
+
+// Write writes to the connection recording the bytes
+func (c *wireConn) Write(p []byte) (n int, err error) {
+	n, err = c.Conn.Write(p)
+	c.mu.Lock()
+	c.sent.write(p[:n])
+	c.mu.Unlock()
+	return n, err
+}
+
+// reset discards the bytes recorded so far, eg when the connection is
+// reused for a new request
+func (c *wireConn) reset() {
+	c.mu.Lock()
+	c.sent, c.received = wireBuffer{}, wireBuffer{}
+	c.mu.Unlock()
+}
+
+// captured returns the bytes recorded since the last reset
+func (c *wireConn) captured() (sent, received []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sent.bytes(), c.received.bytes()
+}
+
+// wantsWire returns true if opt or any of its Sinks set DumpWire
+func wantsWire(opt *Options) bool {
+	if opt.Flags&DumpWire != 0 {
+		return true
+	}
+	for _, sink := range opt.Sinks {
+		if sink.Flags&DumpWire != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// wireTransport returns a clone of transport whose connections are
+// wrapped in wireConn.
+//
+// TLS connections are made here rather than by the transport so the
+// plain text is captured, and only offer HTTP/1.1 as the HTTP/2 wire
+// format is binary framed.
+func wireTransport(transport *http.Transport) *http.Transport {
+	transport = transport.Clone()
+	dial := transport.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &wireConn{Conn: conn}, nil
+	}
+	dialTLS := transport.DialTLSContext
+	if dialTLS == nil {
+		dialTLS = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dial(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			config := &tls.Config{}
+			if transport.TLSClientConfig != nil {
+				config = transport.TLSClientConfig.Clone()
+			}
+			if config.ServerName == "" {
+				config.ServerName, _, _ = net.SplitHostPort(addr)
+			}
+			config.NextProtos = []string{"http/1.1"}
+			tlsConn := tls.Client(conn, config)
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				_ = conn.Close()
+				return nil, err
+			}
+			return tlsConn, nil
+		}
+	}
+	transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialTLS(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &wireConn{Conn: conn}, nil
+	}
+	return transport
+}
+
+// logWire logs the bytes sent and received on the connection of tx
+func (t *Transport) logWire(tx *transaction) {
+	conn, ok := tx.conn.conn.(*wireConn)
+	if !ok {
+		return
+	}
+	req := tx.req
+	sent, received := conn.captured()
+	sent = t.redact(req, sent, DirectionRequest, req.Header.Get("Content-Type"))
+	// The wire bytes may be chunked or hold a partial message so the
+	// whole of them is searched for PII
+	if t.opt.RedactPII {
+		sent = redactPIIBody(sent, t.opt.PIIPatterns)
+	}
+	t.logf(req, "%s", t.separator(req, DirectionRequest))
+	t.logf(req, "HTTP WIRE SENT (%s)", tx.id())
+	t.logf(req, "%s", sent)
+	t.logf(req, "%s", t.separator(req, DirectionRequest))
+	contentType := ""
+	if tx.resp != nil {
+		contentType = tx.resp.Header.Get("Content-Type")
+	}
+	received = t.redact(req, received, DirectionResponse, contentType)
+	if t.opt.RedactPII {
+		received = redactPIIBody(received, t.opt.PIIPatterns)
+	}
+	t.logf(req, "%s", t.separator(req, DirectionResponse))
+	t.logf(req, "HTTP WIRE RECEIVED SO FAR (%s)", tx.id())
+	t.logf(req, "%s", received)
+	t.logf(req, "%s", t.separator(req, DirectionResponse))
+}
@@ -0,0 +1,108 @@
+package debughttp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWireBuffer(t *testing.T) {
+	var b wireBuffer
+	b.write([]byte("hello"))
+	assert.Equal(t, "hello", string(b.bytes()))
+	b.write(make([]byte, maxWireCapture))
+	got := b.bytes()
+	assert.Equal(t, maxWireCapture+len("\n... [5 bytes not captured]\n"), len(got))
+	assert.True(t, strings.HasSuffix(string(got), "\n... [5 bytes not captured]\n"))
+}
+
+func TestDumpWire(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("X-Echo", string(body))
+		fmt.Fprint(w, "Response body")
+	})
+	for _, test := range []struct {
+		name   string
+		server *httptest.Server
+	}{
+		{name: "HTTP", server: httptest.NewServer(handler)},
+		{name: "HTTPS", server: httptest.NewTLSServer(handler)},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ts := test.server
+			defer ts.Close()
+
+			var lines []string
+			base := ts.Client().Transport.(*http.Transport)
+			transport := New(&Options{
+				Flags: DumpWire,
+				Logf: func(format string, v ...interface{}) {
+					lines = append(lines, fmt.Sprintf(format, v...))
+				},
+			}, base)
+			assert.NotEqual(t, base, transport.Transport)
+			assert.Nil(t, base.DialTLSContext, "original transport unchanged")
+			client := &http.Client{Transport: transport}
+
+			// Do two requests to check the connection is reused
+			for i := 0; i < 2; i++ {
+				lines = nil
+				req, err := http.NewRequest(http.MethodPost, ts.URL+"/path", strings.NewReader("Request body"))
+				require.NoError(t, err)
+				req.Header.Set("Authorization", "SECRET")
+				resp, err := client.Do(req)
+				require.NoError(t, err)
+				body, err := ioutil.ReadAll(resp.Body)
+				require.NoError(t, err)
+				require.NoError(t, resp.Body.Close())
+				assert.Equal(t, "Response body", string(body))
+
+				require.Equal(t, 8, len(lines))
+				id := fmt.Sprintf("(req %p)", req)
+				assert.Equal(t, "HTTP WIRE SENT "+id, lines[1])
+				assert.True(t, strings.HasPrefix(lines[2], "POST /path HTTP/1.1\r\nHost: "+req.URL.Host+"\r\n"), lines[2])
+				assert.Contains(t, lines[2], "\r\nAuthorization: XXXX\r\n")
+				assert.NotContains(t, lines[2], "SECRET")
+				assert.True(t, strings.HasSuffix(lines[2], "\r\n\r\nRequest body"), lines[2])
+				assert.Equal(t, "HTTP WIRE RECEIVED SO FAR "+id, lines[5])
+				assert.True(t, strings.HasPrefix(lines[6], "HTTP/1.1 200 OK\r\n"), lines[6])
+				assert.Contains(t, lines[6], "\r\nX-Echo: Request body\r\n")
+				assert.Equal(t, 1, strings.Count(lines[6], "HTTP/1.1 200 OK"))
+			}
+		})
+	}
+}
+
+func TestDumpWirePII(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Reply to user@example.com")
+	}))
+	defer ts.Close()
+
+	var lines []string
+	transport := New(&Options{
+		Flags:     DumpWire,
+		RedactPII: true,
+		Logf: func(format string, v ...interface{}) {
+			lines = append(lines, fmt.Sprintf(format, v...))
+		},
+	}, ts.Client().Transport.(*http.Transport))
+	client := &http.Client{Transport: transport}
+	resp, err := client.Post(ts.URL, "text/plain", strings.NewReader("From other@example.com"))
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, "Reply to user@example.com", string(body))
+
+	require.Equal(t, 8, len(lines))
+	assert.True(t, strings.HasSuffix(lines[2], "\r\n\r\nFrom [REDACTED email]"), lines[2])
+	assert.True(t, strings.HasSuffix(lines[6], "\r\n\r\nReply to [REDACTED email]"), lines[6])
+}
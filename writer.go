@@ -0,0 +1,43 @@
+package debughttp
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// writerOutput writes the logs to an io.Writer, one line per call
+type writerOutput struct {
+	mu  sync.Mutex
+	w   io.Writer
+	buf []byte
+}
+
+// newWriterOutput makes a writerOutput for the Writer in t's Options,
+// compressing it if Gzip is set. If the Writer was made by
+// NewNetWriter it is closed when t is.
+func newWriterOutput(t *Transport) *writerOutput {
+	out := &writerOutput{w: t.opt.Writer}
+	if nw, ok := t.opt.Writer.(*netWriter); ok {
+		t.closers = append(t.closers, nw)
+	}
+	if t.opt.Gzip {
+		gz := gzip.NewWriter(t.opt.Writer)
+		out.w = gz
+		t.closers = append(t.closers, gz)
+	}
+	return out
+}
+
+// printf writes the formatted message to the Writer adding a
+// newline if needed.
+func (out *writerOutput) printf(format string, v ...interface{}) {
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	out.buf = append(out.buf[:0], fmt.Sprintf(format, v...)...)
+	if len(out.buf) == 0 || out.buf[len(out.buf)-1] != '\n' {
+		out.buf = append(out.buf, '\n')
+	}
+	_, _ = out.w.Write(out.buf)
+}
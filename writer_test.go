@@ -0,0 +1,63 @@
+package debughttp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Response body")
+	}))
+	defer ts.Close()
+
+	for _, test := range []struct {
+		name string
+		gzip bool
+	}{
+		{name: "Plain"},
+		{name: "Gzip", gzip: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			client := NewClient(&Options{
+				Flags:  DumpBodies,
+				Writer: &buf,
+				Gzip:   test.gzip,
+				Logf: func(format string, v ...interface{}) {
+					t.Error("Logf called when Writer set")
+				},
+			})
+			for i := 0; i < 3; i++ {
+				resp, err := client.Get(ts.URL)
+				require.NoError(t, err)
+				_, err = ioutil.ReadAll(resp.Body)
+				require.NoError(t, err)
+				require.NoError(t, resp.Body.Close())
+			}
+			require.NoError(t, CloseClient(client))
+
+			out := buf.Bytes()
+			if test.gzip {
+				gz, err := gzip.NewReader(&buf)
+				require.NoError(t, err)
+				out, err = ioutil.ReadAll(gz)
+				require.NoError(t, err)
+				assert.Less(t, buf.Len(), len(out))
+			}
+			text := string(out)
+			assert.Equal(t, 12, strings.Count(text, SeparatorReq+"\n")+strings.Count(text, SeparatorResp+"\n"))
+			assert.Equal(t, 3, strings.Count(text, "HTTP REQUEST"))
+			assert.Equal(t, 3, strings.Count(text, "Response body\n"))
+		})
+	}
+}